@@ -0,0 +1,103 @@
+package hivdomainstatus
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Random bytes of an API key
+const API_KEY_BYTES = 32
+
+// The last use of a key is recorded at most once in this interval
+const API_KEY_TOUCH_INTERVAL = time.Minute
+
+// Creates a key with a random secret. Only the hash of the secret is stored,
+// so it has to be handed out now.
+func NewApiKey(name string, scope string) (key *ApiKey, secret string, err error) {
+	if scope != API_KEY_SCOPE_READ && scope != API_KEY_SCOPE_WRITE {
+		err = fmt.Errorf("Invalid scope: %s", scope)
+		return
+	}
+	b := make([]byte, API_KEY_BYTES)
+	_, err = rand.Read(b)
+	if err != nil {
+		return
+	}
+	secret = hex.EncodeToString(b)
+	key = new(ApiKey)
+	key.Name = name
+	key.Scope = scope
+	key.Prefix = secret[0:8]
+	key.KeyHash = HashApiKey(secret)
+	return
+}
+
+func HashApiKey(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// Returns true if the key may be used for requests with method
+func (key *ApiKey) Allows(method string) bool {
+	if key.Scope == API_KEY_SCOPE_WRITE {
+		return true
+	}
+	return key.Scope == API_KEY_SCOPE_READ && (method == "GET" || method == "HEAD" || method == "OPTIONS")
+}
+
+// Authenticates requests with an API key sent as bearer token
+type ApiKeyAuth struct {
+	apiKeyRepo ApiKeyRepositoryInterface
+}
+
+func NewApiKeyAuth(apiKeyRepo ApiKeyRepositoryInterface) (auth *ApiKeyAuth) {
+	auth = new(ApiKeyAuth)
+	auth.apiKeyRepo = apiKeyRepo
+	return
+}
+
+// Wraps handler, which is only called for requests with a valid key of a sufficient scope
+func (auth *ApiKeyAuth) Protect(handler RouteHandler) RouteHandler {
+	return func(w http.ResponseWriter, r *http.Request, routeParams []string) {
+		authorization := r.Header.Get("Authorization")
+		if len(authorization) == 0 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="hiv-domain-status"`)
+			HttpProblem(w, r, http.StatusUnauthorized, "An API key is required")
+			return
+		}
+		if !strings.HasPrefix(authorization, "Bearer ") {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="hiv-domain-status", error="invalid_request"`)
+			HttpProblem(w, r, http.StatusUnauthorized, "Expected a bearer token")
+			return
+		}
+		key, err := auth.apiKeyRepo.FindByHash(HashApiKey(strings.TrimSpace(authorization[len("Bearer "):])))
+		if err != nil && err != sql.ErrNoRows {
+			HttpError(w, r, err)
+			return
+		}
+		if err == sql.ErrNoRows || key.Revoked != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="hiv-domain-status", error="invalid_token"`)
+			HttpProblem(w, r, http.StatusUnauthorized, "Invalid API key")
+			return
+		}
+		if !key.Allows(r.Method) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="hiv-domain-status", error="insufficient_scope"`)
+			HttpProblem(w, r, http.StatusForbidden, "The API key is read-only")
+			return
+		}
+		if key.LastUsed == nil || time.Since(*key.LastUsed) > API_KEY_TOUCH_INTERVAL {
+			touchErr := auth.apiKeyRepo.Touch(key)
+			if touchErr != nil {
+				log.Printf("ERROR: Failed to record use of API key %d: %s\n", key.Id, touchErr.Error())
+			}
+		}
+		handler(w, r, routeParams)
+	}
+}
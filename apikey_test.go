@@ -0,0 +1,134 @@
+package hivdomainstatus
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func SetupApiKeyTest(t *testing.T) (repo *ApiKeyRepository) {
+	c, configErr := NewConfig()
+	if configErr != nil {
+		t.Fatal(configErr)
+	}
+	db, _ := sql.Open("postgres", c.DSN())
+	db.Exec("TRUNCATE api_key RESTART IDENTITY")
+	repo = NewApiKeyRepository(db)
+	return
+}
+
+// Fails to look up any key
+type failingApiKeyRepository struct {
+	ApiKeyRepositoryInterface
+}
+
+func (repo *failingApiKeyRepository) FindByHash(hash string) (key *ApiKey, err error) {
+	err = errors.New("connection refused")
+	return
+}
+
+func TestThatItCreatesApiKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	key, secret, err := NewApiKey("CRM sync", API_KEY_SCOPE_READ)
+	assert.Nil(err)
+	assert.Equal(API_KEY_BYTES*2, len(secret))
+	assert.Equal(secret[0:8], key.Prefix)
+	assert.Equal(HashApiKey(secret), key.KeyHash)
+	assert.NotEqual(secret, key.KeyHash)
+	assert.True(key.Allows("GET"))
+	assert.True(key.Allows("HEAD"))
+	assert.False(key.Allows("POST"))
+	assert.False(key.Allows("DELETE"))
+
+	key.Scope = API_KEY_SCOPE_WRITE
+	assert.True(key.Allows("DELETE"))
+
+	_, _, err = NewApiKey("CRM sync", "admin")
+	assert.NotNil(err)
+}
+
+func TestThatItRequiresAnApiKey(t *testing.T) {
+	assert := assert.New(t)
+
+	repo := SetupApiKeyTest(t)
+	readKey, readSecret, _ := NewApiKey("dashboard", API_KEY_SCOPE_READ)
+	assert.Nil(repo.Persist(readKey))
+	writeKey, writeSecret, _ := NewApiKey("CRM sync", API_KEY_SCOPE_WRITE)
+	assert.Nil(repo.Persist(writeKey))
+
+	called := 0
+	handler := NewApiKeyAuth(repo).Protect(func(w http.ResponseWriter, r *http.Request, routeParams []string) {
+		called++
+		w.WriteHeader(http.StatusNoContent)
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, nil)
+	}))
+	defer ts.Close()
+
+	request := func(method string, secret string) (res *http.Response) {
+		req, _ := http.NewRequest(method, ts.URL+"/domain", nil)
+		if len(secret) > 0 {
+			req.Header.Set("Authorization", "Bearer "+secret)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return
+	}
+
+	res := request("GET", "")
+	assert.Equal(http.StatusUnauthorized, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+	assert.Equal(`Bearer realm="hiv-domain-status"`, res.Header.Get("WWW-Authenticate"))
+	res = request("GET", "invalid")
+	assert.Equal(http.StatusUnauthorized, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+	assert.Equal(0, called)
+
+	res = request("GET", readSecret)
+	assert.Equal(http.StatusNoContent, res.StatusCode)
+	res = request("DELETE", readSecret)
+	assert.Equal(http.StatusForbidden, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+	assert.Equal(1, called)
+	res = request("DELETE", writeSecret)
+	assert.Equal(http.StatusNoContent, res.StatusCode)
+	assert.Equal(2, called)
+
+	used, _ := repo.FindById(readKey.Id)
+	assert.NotNil(used.LastUsed)
+
+	assert.Nil(repo.Revoke(writeKey))
+	res = request("GET", writeSecret)
+	assert.Equal(http.StatusUnauthorized, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+	assert.Equal(2, called)
+}
+
+func TestThatItDoesNotRejectApiKeysIfTheLookupFails(t *testing.T) {
+	assert := assert.New(t)
+
+	handler := NewApiKeyAuth(new(failingApiKeyRepository)).Protect(func(w http.ResponseWriter, r *http.Request, routeParams []string) {
+		t.Error("Handler must not be called")
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, nil)
+	}))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL+"/domain", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(err)
+	assert.Equal(http.StatusInternalServerError, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+	assert.Equal("", res.Header.Get("WWW-Authenticate"))
+}
@@ -31,13 +31,21 @@ type DomainCheckResult struct {
 	IframeTarget   string
 	IframeTargetOk bool
 	Valid          bool
+	Hosts          []*DomainCheckHost
+	Egress         string
 	verbose        bool
-	wwwRemoved     bool
 	isAllowedTld   IsAllowedTld
+	proxy          *ProxySelector
 }
 
 type IsAllowedTld func(domain string) bool
 
+// Policies which decide how the www and apex host results make up the validity of a domain
+const (
+	HOST_POLICY_EITHER = "either"
+	HOST_POLICY_BOTH   = "both"
+)
+
 func NewDomainCheckResult(domain string, isAllowedTld IsAllowedTld) (checkResult *DomainCheckResult) {
 	checkResult = new(DomainCheckResult)
 	checkResult.Domain = domain
@@ -66,13 +74,6 @@ func (checkResult *DomainCheckResult) Check() (err error) {
 	}
 	err = checkResult.fetch()
 	if err != nil {
-		if checkResult.IsWWW() && !checkResult.wwwRemoved {
-			// Fetch page without www (if not present)
-			checkResult.wwwRemoved = true
-			checkResult.URL, _ = url.Parse("http://" + checkResult.Domain + "/")
-			err = checkResult.Check()
-			return
-		}
 		checkResult.Valid = false
 		return
 	}
@@ -101,8 +102,7 @@ func (checkResult *DomainCheckResult) Check() (err error) {
 			redirectUrl.Scheme = checkResult.URL.Scheme
 			checkResult.IframeTarget = redirectUrl.String()
 		}
-		redirectChecker := NewDomainCheckResult(redirectUrl.Host, checkResult.isAllowedTld)
-		redirectChecker.URL = redirectUrl
+		redirectChecker := checkResult.newHostChecker(redirectUrl.Host, redirectUrl)
 		redirectChecker.SaveBody = false
 		redirectCheckErr := redirectChecker.Check()
 		if redirectCheckErr != nil {
@@ -123,6 +123,80 @@ func (checkResult *DomainCheckResult) Check() (err error) {
 	return
 }
 
+// Checks the www and the apex host of the domain independently, the
+// validity of the domain is decided by policy.
+// The fields of the result are taken from the first valid host (www first).
+func (checkResult *DomainCheckResult) CheckHosts(policy string) (err error) {
+	if policy != HOST_POLICY_EITHER && policy != HOST_POLICY_BOTH {
+		checkResult.Valid = false
+		err = fmt.Errorf("Unknown host policy: %s", policy)
+		return
+	}
+	var primary *DomainCheckResult
+	var primaryErr error
+	var firstErr error
+	numValid := 0
+	checkResult.Hosts = make([]*DomainCheckHost, 0, 2)
+	for _, host := range []string{"www." + checkResult.Domain, checkResult.Domain} {
+		hostUrl, _ := url.Parse("http://" + host + "/")
+		hostChecker := checkResult.newHostChecker(host, hostUrl)
+		hostErr := hostChecker.Check()
+		h := new(DomainCheckHost)
+		h.Host = host
+		h.URL = hostChecker.URL.String()
+		h.StatusCode = hostChecker.StatusCode
+		h.ScriptPresent = hostChecker.ScriptPresent
+		h.Valid = hostChecker.Valid
+		if hostErr != nil {
+			h.Error = hostErr.Error()
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %s", host, hostErr.Error())
+			}
+		}
+		if hostChecker.Valid {
+			numValid++
+		}
+		checkResult.Hosts = append(checkResult.Hosts, h)
+		if primary == nil || (!primary.Valid && hostChecker.Valid) {
+			primary = hostChecker
+			primaryErr = hostErr
+		}
+	}
+
+	checkResult.DnsOk = primary.DnsOk
+	checkResult.Addresses = primary.Addresses
+	checkResult.URL = primary.URL
+	checkResult.StatusCode = primary.StatusCode
+	checkResult.ScriptPresent = primary.ScriptPresent
+	checkResult.IframePresent = primary.IframePresent
+	checkResult.IframeTarget = primary.IframeTarget
+	checkResult.IframeTargetOk = primary.IframeTargetOk
+	checkResult.Egress = primary.Egress
+	checkResult.bodyFile = primary.bodyFile
+	if policy == HOST_POLICY_BOTH {
+		checkResult.Valid = numValid == len(checkResult.Hosts)
+		if !checkResult.Valid {
+			err = firstErr
+		}
+	} else {
+		checkResult.Valid = numValid > 0
+		if !checkResult.Valid {
+			err = primaryErr
+		}
+	}
+	return
+}
+
+// Creates a checker for another host which shares the settings of this one
+func (checkResult *DomainCheckResult) newHostChecker(host string, u *url.URL) (hostChecker *DomainCheckResult) {
+	hostChecker = NewDomainCheckResult(host, checkResult.isAllowedTld)
+	hostChecker.URL = u
+	hostChecker.SaveBody = checkResult.SaveBody
+	hostChecker.verbose = checkResult.verbose
+	hostChecker.proxy = checkResult.proxy
+	return
+}
+
 var lookupHost = func(domain string) (addresses []string, err error) {
 	addresses, err = net.LookupHost(domain)
 	if err != nil {
@@ -159,10 +233,15 @@ var timeout = time.Duration(5 * time.Second)
 // then opens it
 func (checkResult *DomainCheckResult) fetch() (err error) {
 	log.Printf("[%s] Fetching %s\n", checkResult.Domain, checkResult.URL)
+	checkResult.Egress = checkResult.proxy.Via(checkResult.URL)
+	if checkResult.verbose {
+		log.Printf("[%s] Egress via %s\n", checkResult.Domain, checkResult.Egress)
+	}
 	var response *http.Response
 	url := checkResult.URL.String()
 	transport := http.Transport{
-		Dial: TimeoutDialer(time.Duration(5 * time.Second)),
+		Dial:  TimeoutDialer(time.Duration(5 * time.Second)),
+		Proxy: checkResult.proxy.Proxy,
 	}
 	client := http.Client{
 		Transport: &transport,
@@ -263,7 +342,13 @@ func (checkResult *DomainCheckResult) checkIframe() (err error) {
 
 func CheckDomain(config *Config, domain string) (checkResult *DomainCheckResult, err error) {
 	checkResult = NewDomainCheckResult(domain, isHivDomain)
-	err = checkResult.Check()
+	checkResult.verbose = config.Crawler.Verbose
+	checkResult.proxy, err = NewProxySelector(config)
+	if err == nil {
+		err = checkResult.CheckHosts(config.Crawler.HostPolicy)
+	} else {
+		checkResult.Valid = false
+	}
 	if !checkResult.Valid {
 		log.Printf("[%s] PROBLEM: %s\n", checkResult.Domain, err.Error())
 	} else {
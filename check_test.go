@@ -78,6 +78,57 @@ func TestThatItChecksDomain(t *testing.T) {
 	assert.True(testChecker.Valid)
 }
 
+func TestThatItChecksWWWAndApexHost(t *testing.T) {
+	assert := assert.New(t)
+
+	// Serves as proxy for both hosts
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "www.example.hiv" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`<script src="` + CLICKCOUNTER_SCRIPT + `">`))
+	}))
+	defer ts.Close()
+
+	lookupHost = func(domain string) (addresses []string, err error) {
+		return []string{"1.2.3.4"}, nil
+	}
+
+	c := NewDefaultConfig()
+	c.Proxy.Url = ts.URL
+	proxy, proxyErr := NewProxySelector(c)
+	assert.Nil(proxyErr)
+
+	for _, policy := range []string{HOST_POLICY_EITHER, HOST_POLICY_BOTH} {
+		testChecker := NewDomainCheckResult("example.hiv", isHivDomain)
+		testChecker.proxy = proxy
+		err := testChecker.CheckHosts(policy)
+
+		assert.Equal(2, len(testChecker.Hosts))
+		assert.Equal("www.example.hiv", testChecker.Hosts[0].Host)
+		assert.Equal("http://www.example.hiv/", testChecker.Hosts[0].URL)
+		assert.Equal(http.StatusOK, testChecker.Hosts[0].StatusCode)
+		assert.True(testChecker.Hosts[0].ScriptPresent)
+		assert.True(testChecker.Hosts[0].Valid)
+		assert.Equal("example.hiv", testChecker.Hosts[1].Host)
+		assert.Equal(http.StatusInternalServerError, testChecker.Hosts[1].StatusCode)
+		assert.False(testChecker.Hosts[1].ScriptPresent)
+		assert.False(testChecker.Hosts[1].Valid)
+		assert.NotEmpty(testChecker.Hosts[1].Error)
+
+		assert.Equal("http://www.example.hiv/", testChecker.URL.String())
+		assert.Equal(ts.URL, testChecker.Egress)
+		if policy == HOST_POLICY_EITHER {
+			assert.Nil(err)
+			assert.True(testChecker.Valid)
+		} else {
+			assert.NotNil(err)
+			assert.False(testChecker.Valid)
+		}
+	}
+}
+
 func TestThatItDetectsHivDomain(t *testing.T) {
 	assert := assert.New(t)
 	assert.True(isHivDomain("hanseventures.hiv"))
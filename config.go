@@ -2,12 +2,16 @@ package hivdomainstatus
 
 import (
 	"fmt"
+	"strings"
 	"code.google.com/p/gcfg"
 )
 
 type Config struct {
 	Server struct {
 		Port int
+		Url  string
+		Auth      bool
+		AccessLog bool
 	}
 	Database   struct {
 		Host     string
@@ -16,6 +20,47 @@ type Config struct {
 		Password string
 		Sslmode  string
 	}
+	Crawler struct {
+		Verbose    bool
+		HostPolicy string
+	}
+	Scheduler struct {
+		Enabled      bool
+		Concurrency  int
+		MinInterval  string
+		MaxInterval  string
+		StableAfter  string
+		PollInterval string
+	}
+	Queue struct {
+		Lease       string
+		Heartbeat   string
+		MaxAttempts int
+	}
+	Webhook struct {
+		MaxAttempts int
+		Backoff     string
+		Timeout     string
+	}
+	Smtp struct {
+		Enabled        bool
+		Host           string
+		Port           int
+		Username       string
+		Password       string
+		From           string
+		Secret         string
+		GracePeriod    string
+		RateLimit      int
+		RecipientLimit int
+		Lease          string
+	}
+	Proxy struct {
+		Url      string
+		Username string
+		Password string
+		NoProxy  string
+	}
 }
 
 func (c *Config) DSN() (dsn string) {
@@ -29,9 +74,36 @@ func (c *Config) DSN() (dsn string) {
 	return
 }
 
+// Public url of the server, used for links outside of requests
+func (c *Config) BaseUrl() string {
+	if len(c.Server.Url) > 0 {
+		return strings.TrimRight(c.Server.Url, "/")
+	}
+	return fmt.Sprintf("http://localhost:%d", c.Server.Port)
+}
+
 func NewDefaultConfig() (c *Config) {
 	c = new(Config)
+	c.Server.Auth = true
 	c.Database.Sslmode = "disable"
+	c.Crawler.HostPolicy = HOST_POLICY_EITHER
+	c.Scheduler.Concurrency = 4
+	c.Scheduler.MinInterval = "15m"
+	c.Scheduler.MaxInterval = "24h"
+	c.Scheduler.StableAfter = "336h"
+	c.Scheduler.PollInterval = "10s"
+	c.Queue.Lease = "2m"
+	c.Queue.Heartbeat = "30s"
+	c.Queue.MaxAttempts = 3
+	c.Webhook.MaxAttempts = 8
+	c.Webhook.Backoff = "30s"
+	c.Webhook.Timeout = "10s"
+	c.Smtp.Host = "localhost"
+	c.Smtp.Port = 25
+	c.Smtp.GracePeriod = "72h"
+	c.Smtp.RateLimit = 100
+	c.Smtp.RecipientLimit = 5
+	c.Smtp.Lease = "5m"
 	return
 }
 
@@ -1,37 +1,43 @@
 package hivdomainstatus
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type DomainCheckController struct {
 	domainCheckRepo DomainCheckRepositoryInterface
+	domainRepo      DomainRepositoryInterface
 }
 
 func (c *DomainCheckController) ListingHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
-	if r.Method != "GET" {
-		HttpProblem(w, http.StatusBadRequest, "Method not allow: "+r.Method)
+	mediaType, acceptable := negotiateResponse(w, r, listMediaTypes)
+	if !acceptable {
 		return
 	}
 	formErr := r.ParseForm()
 	if formErr != nil {
-		HttpProblem(w, http.StatusInternalServerError, formErr.Error())
+		HttpProblem(w, r, http.StatusBadRequest, formErr.Error())
 		return
 	}
 
 	itemsPerPage := 100
 	offsetKey := r.Form.Get("offsetKey")
-	items, findErr := c.domainCheckRepo.FindPaginated(itemsPerPage, offsetKey)
+	filter := new(DomainCheckFilter)
+	filter.Tag = strings.ToLower(r.Form.Get("tag"))
+	items, findErr := c.domainCheckRepo.FindPaginated(itemsPerPage, offsetKey, filter)
 	if findErr != nil {
-		HttpProblem(w, http.StatusInternalServerError, findErr.Error())
+		HttpError(w, r, findErr)
 		return
 	}
 
-	total, maxKey, statsErr := c.domainCheckRepo.Stats()
+	total, maxKey, statsErr := c.domainCheckRepo.Stats(filter)
 	if statsErr != nil {
-		HttpProblem(w, http.StatusInternalServerError, statsErr.Error())
+		HttpError(w, r, statsErr)
 		return
 	}
 
@@ -47,14 +53,79 @@ func (c *DomainCheckController) ListingHandler(w http.ResponseWriter, r *http.Re
 		list.Items[i] = e
 	}
 
-	w.Header().Add("Content-Type", "application/json")
 	// Add nwext link
+	nextKey := maxKey
 	if len(items) > 0 {
-		last := list.Items[len(items)-1]
-		w.Header().Add("Link", fmt.Sprintf(`<%s/check?offsetKey=%s>; rel="next"`, getHttpHost(r), last.Id))
-	} else {
-		w.Header().Add("Link", fmt.Sprintf(`<%s/check?offsetKey=%s>; rel="next"`, getHttpHost(r), maxKey))
+		nextKey = list.Items[len(items)-1].Id
 	}
-	encoder := json.NewEncoder(w)
-	encoder.Encode(list)
+	links := map[string]string{"next": fmt.Sprintf("%s/check?%s", getHttpHost(r), checkListingQuery(filter, nextKey))}
+	w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="next"`, links["next"]))
+	if mediaType == MEDIA_TYPE_CSV {
+		writeCsv(w, checkCsvHeader, checkCsvRecords(items))
+		return
+	}
+	writeModel(w, mediaType, list, links)
+}
+
+// Columns of the CSV representation of checks
+var checkCsvHeader = []string{
+	"id", "domain", "valid", "dnsOk", "addresses", "url", "statusCode", "scriptPresent", "iframePresent", "iframeTarget", "iframeTargetOk", "failureReason", "inMaintenance", "created",
+}
+
+func checkCsvRecords(checks []*DomainCheck) (records [][]string) {
+	records = make([][]string, len(checks))
+	for i, check := range checks {
+		created := ""
+		if check.Created != nil {
+			created = check.Created.Format(time.RFC3339)
+		}
+		records[i] = []string{
+			strconv.FormatInt(check.Id, 10), check.Domain, strconv.FormatBool(check.Valid), strconv.FormatBool(check.DnsOK), strings.Join(check.Addresses, ";"), check.URL, strconv.Itoa(check.StatusCode), strconv.FormatBool(check.ScriptPresent), strconv.FormatBool(check.IframePresent), check.IframeTarget, strconv.FormatBool(check.IframeTargetOk), check.FailureReason(), strconv.FormatBool(check.InMaintenance), created,
+		}
+	}
+	return
+}
+
+// Returns the query string of a listing page, keeping the filter
+func checkListingQuery(filter *DomainCheckFilter, offsetKey string) string {
+	q := url.Values{}
+	q.Set("offsetKey", offsetKey)
+	if len(filter.Tag) > 0 {
+		q.Set("tag", filter.Tag)
+	}
+	return q.Encode()
+}
+
+// Shows a check with links to its domain and the previous and next check of that domain
+func (c *DomainCheckController) ItemHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
+	mediaType, acceptable := negotiateResponse(w, r, itemMediaTypes)
+	if !acceptable {
+		return
+	}
+	id, err := strconv.ParseInt(routeParams[1], 0, 64)
+	if err != nil {
+		HttpProblem(w, r, http.StatusBadRequest, "Invalid id: "+routeParams[1])
+		return
+	}
+	check, findErr := c.domainCheckRepo.FindById(id)
+	if findErr != nil {
+		HttpError(w, r, notFound(findErr, "Check not found: "+routeParams[1]))
+		return
+	}
+
+	route := getHttpHost(r) + "/check/%d"
+	m := transformCheckEntity(check, route)
+	domain, domainErr := c.domainRepo.FindByName(check.Domain)
+	if domainErr == nil {
+		m.DomainLink = fmt.Sprintf("%s/domain/%d", getHttpHost(r), domain.Id)
+	}
+	previous, previousErr := c.domainCheckRepo.FindPreviousByDomain(check)
+	if previousErr == nil {
+		m.Previous = fmt.Sprintf(route, previous.Id)
+	}
+	next, nextErr := c.domainCheckRepo.FindNextByDomain(check)
+	if nextErr == nil {
+		m.Next = fmt.Sprintf(route, next.Id)
+	}
+	writeModel(w, mediaType, m, nil)
 }
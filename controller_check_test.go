@@ -7,12 +7,13 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func SetupDomainCheckTest(t *testing.T) (cntrl *DomainCheckController) {
+func SetupDomainCheckTest(t *testing.T) (cntrl *DomainCheckController, tagRepo *TagRepository) {
 	assert := assert.New(t)
 	c, configErr := NewConfig()
 	if configErr != nil {
@@ -22,9 +23,13 @@ func SetupDomainCheckTest(t *testing.T) (cntrl *DomainCheckController) {
 
 	cntrl = new(DomainCheckController)
 	domainRepo := NewDomainRepository(db)
+	tagRepo = NewTagRepository(db)
 	cntrl.domainCheckRepo = NewDomainCheckRepository(db)
+	cntrl.domainRepo = domainRepo
 	db.Exec("TRUNCATE domain RESTART IDENTITY")
 	db.Exec("TRUNCATE domain_check RESTART IDENTITY")
+	db.Exec("TRUNCATE tag RESTART IDENTITY CASCADE")
+	db.Exec("TRUNCATE domain_tag")
 
 	data := []string{"example.hiv", "acme.hiv"}
 	for _, name := range data {
@@ -50,7 +55,7 @@ func SetupDomainCheckTest(t *testing.T) (cntrl *DomainCheckController) {
 func TestThatItListsDomainChecks(t *testing.T) {
 	assert := assert.New(t)
 
-	cntrl := SetupDomainCheckTest(t)
+	cntrl, _ := SetupDomainCheckTest(t)
 
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cntrl.ListingHandler(w, r, nil)
@@ -66,7 +71,7 @@ func TestThatItListsDomainChecks(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	assert.Equal("application/json", res.Header.Get("Content-Type"))
+	assert.Equal("application/ld+json", res.Header.Get("Content-Type"))
 
 	var l DomainCheckListModel
 	unmarshalErr := json.Unmarshal(b, &l)
@@ -94,3 +99,83 @@ func TestThatItListsDomainChecks(t *testing.T) {
 	assert.True(l.Items[0].IframeTargetOk)
 	assert.True(l.Items[0].Valid)
 }
+
+func TestThatItFiltersDomainChecksByTag(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl, tagRepo := SetupDomainCheckTest(t)
+	assert.Nil(tagRepo.SetDomainTags(2, []string{"partner"}))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.ListingHandler(w, r, nil)
+	}))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/check?tag=Partner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l DomainCheckListModel
+	assert.Nil(json.Unmarshal(b, &l))
+	assert.Equal(1, l.Total)
+	assert.Equal("acme.hiv", l.Items[0].Domain)
+	assert.Equal(`<`+ts.URL+`/check?offsetKey=2&tag=partner>; rel="next"`, res.Header.Get("Link"))
+}
+
+func TestThatItFetchesASingleCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl, _ := SetupDomainCheckTest(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.ItemHandler(w, r, regexp.MustCompile("^/check/([0-9]+)$").FindStringSubmatch(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	for _, valid := range []bool{false, true} {
+		check := new(DomainCheck)
+		check.Domain = "example.hiv"
+		check.URL = "http://example.hiv"
+		check.Valid = valid
+		assert.Nil(cntrl.domainCheckRepo.Persist(check))
+	}
+
+	getCheck := func(id int) (m *DomainCheckModel) {
+		res, err := http.Get(fmt.Sprintf("%s/check/%d", ts.URL, id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(http.StatusOK, res.StatusCode)
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		m = new(DomainCheckModel)
+		assert.Nil(json.Unmarshal(b, m))
+		return
+	}
+
+	// Checks 1, 3 and 4 are of example.hiv, 2 of acme.hiv
+	m := getCheck(3)
+	assert.Equal(ts.URL+"/check/3", m.JsonLDId)
+	assert.Equal("example.hiv", m.Domain)
+	assert.Equal(ts.URL+"/domain/1", m.DomainLink)
+	assert.Equal(ts.URL+"/check/1", m.Previous)
+	assert.Equal(ts.URL+"/check/4", m.Next)
+
+	m = getCheck(1)
+	assert.Equal("", m.Previous)
+	m = getCheck(4)
+	assert.Equal("", m.Next)
+	m = getCheck(2)
+	assert.Equal(ts.URL+"/domain/2", m.DomainLink)
+
+	res, err := http.Get(ts.URL + "/check/99")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(http.StatusNotFound, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+}
@@ -2,44 +2,67 @@ package hivdomainstatus
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type DomainController struct {
-	domainRepo      DomainRepositoryInterface
-	domainCheckRepo DomainCheckRepositoryInterface
+	db                 *sql.DB
+	domainRepo         DomainRepositoryInterface
+	domainCheckRepo    DomainCheckRepositoryInterface
+	domainScheduleRepo DomainScheduleRepositoryInterface
+	tagRepo            TagRepositoryInterface
+	maintenanceRepo    MaintenanceWindowRepositoryInterface
+	jobRepo            CheckJobRepositoryInterface
 }
 
+// Tags are lower case, start with a letter or digit and may contain - _ .
+var tagPattern = regexp.MustCompile("^[a-z0-9][a-z0-9_.-]{0,63}$")
+
+// Host names of at least two labels, labels do not start or end with a hyphen
+var domainNamePattern = regexp.MustCompile(`^(?i)([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
 func (c *DomainController) ListingHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
 	if r.Method == "POST" {
 		c.createItem(w, r, routeParams)
 		return
 	}
-	if r.Method != "GET" {
-		HttpProblem(w, http.StatusBadRequest, "Method not allow: "+r.Method)
+	mediaType, acceptable := negotiateResponse(w, r, listMediaTypes)
+	if !acceptable {
 		return
 	}
 	formErr := r.ParseForm()
 	if formErr != nil {
-		HttpProblem(w, http.StatusInternalServerError, formErr.Error())
+		HttpProblem(w, r, http.StatusBadRequest, formErr.Error())
 		return
 	}
 
 	itemsPerPage := 100
 	offsetKey := r.Form.Get("offsetKey")
-	items, findErr := c.domainRepo.FindPaginated(itemsPerPage, offsetKey)
+	filter := new(DomainFilter)
+	filterErr := parseDomainFilter(r.Form, filter)
+	if filterErr != nil {
+		HttpError(w, r, filterErr)
+		return
+	}
+	items, findErr := c.domainRepo.FindPaginated(itemsPerPage, offsetKey, filter)
 	if findErr != nil {
-		HttpProblem(w, http.StatusInternalServerError, findErr.Error())
+		HttpError(w, r, findErr)
 		return
 	}
 
-	total, maxKey, statsErr := c.domainRepo.Stats()
+	total, maxKey, statsErr := c.domainRepo.Stats(filter)
 	if statsErr != nil {
-		HttpProblem(w, http.StatusInternalServerError, statsErr.Error())
+		HttpError(w, r, statsErr)
 		return
 	}
 
@@ -49,6 +72,7 @@ func (c *DomainController) ListingHandler(w http.ResponseWriter, r *http.Request
 	list.JsonLDType = "http://jsonld.click4life.hiv/Domain"
 	list.JsonLDId = getHttpHost(r)
 	list.Items = make([]*DomainModel, len(items))
+	checks := make([]*DomainCheck, len(items))
 
 	for i, item := range items {
 		e := transformEntity(item, getHttpHost(r)+"/domain/%d")
@@ -58,79 +82,839 @@ func (c *DomainController) ListingHandler(w http.ResponseWriter, r *http.Request
 		if checkErr == nil {
 			e.Check = transformCheckEntity(domainCheck, getHttpHost(r)+"/check/%d")
 			e.Valid = domainCheck.Valid
+			checks[i] = domainCheck
+		}
+		schedule, scheduleErr := c.domainScheduleRepo.FindByDomain(item.Name)
+		if scheduleErr == nil {
+			e.NextCheck = schedule.NextCheck
 		}
+		tags, tagsErr := c.tagRepo.FindByDomain(item.Id)
+		if tagsErr == nil {
+			e.Tags = tags
+		}
+		e.Maintenance = c.activeMaintenance(r, item)
 	}
 
-	w.Header().Add("Content-Type", "application/json")
 	// Add nwext link
+	links := make(map[string]string)
 	if len(items) > 0 {
-		last := list.Items[len(items)-1]
-		w.Header().Add("Link", fmt.Sprintf(`<%s/domain?offsetKey=%s>; rel="next"`, getHttpHost(r), last.Id))
-	} else {
-		w.Header().Add("Link", fmt.Sprintf(`<%s/domain?offsetKey=%s>; rel="next"`, getHttpHost(r), maxKey))
+		links["next"] = fmt.Sprintf("%s/domain?%s", getHttpHost(r), domainListingQuery(filter, filter.offsetKey(items[len(items)-1])))
+	} else if len(filter.Sort) == 0 || filter.Sort == "id" {
+		// New domains are appended, so the last page can be polled
+		links["next"] = fmt.Sprintf("%s/domain?%s", getHttpHost(r), domainListingQuery(filter, maxKey))
+	}
+	if next, ok := links["next"]; ok {
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="next"`, next))
+	}
+	if mediaType == MEDIA_TYPE_CSV {
+		records := make([][]string, len(items))
+		for i, item := range items {
+			records[i] = exportCsvRecord(transformExportEntity(item, checks[i]))
+		}
+		writeCsv(w, exportCsvHeader, records)
+		return
 	}
-	encoder := json.NewEncoder(w)
-	encoder.Encode(list)
+	writeModel(w, mediaType, list, links)
 }
 
 func (c *DomainController) createItem(w http.ResponseWriter, r *http.Request, routeParams []string) {
+	mediaType, acceptable := negotiateResponse(w, r, itemMediaTypes)
+	if !acceptable {
+		return
+	}
+	m, ok := readDomainModel(w, r)
+	if !ok {
+		return
+	}
+	domain := new(Domain)
+	domain.Name = m.Name
+	if !c.storeItem(w, r, domain, m) {
+		return
+	}
+	w.Header().Add("Location", transformEntity(domain, getHttpHost(r)+"/domain/%d").JsonLDId)
+	c.writeItem(w, r, domain, mediaType, http.StatusCreated)
+}
+
+// Reads and validates a domain from the request body, sends a problem if that fails
+func readDomainModel(w http.ResponseWriter, r *http.Request) (m *DomainModel, ok bool) {
 	if r.Header.Get("Content-Type") != "application/json" {
-		HttpProblem(w, http.StatusBadRequest, "Expected application/json got "+r.Header.Get("Content-Type"))
+		HttpProblem(w, r, http.StatusBadRequest, "Expected application/json got "+r.Header.Get("Content-Type"))
 		return
 	}
 	b, err := ioutil.ReadAll(r.Body)
 	r.Body.Close()
 	if err != nil {
-		HttpProblem(w, http.StatusBadRequest, "Failed to read body: "+err.Error())
+		HttpProblem(w, r, http.StatusBadRequest, "Failed to read body: "+err.Error())
 		return
 	}
 
-	var m DomainModel
-	unmarshalErr := json.Unmarshal(b, &m)
+	m = new(DomainModel)
+	unmarshalErr := json.Unmarshal(b, m)
 	if unmarshalErr != nil {
-		HttpProblem(w, http.StatusBadRequest, "Failed to parse request: "+bytes.NewBuffer(b).String())
+		HttpProblem(w, r, http.StatusBadRequest, "Failed to parse request: "+bytes.NewBuffer(b).String())
 		return
 	}
-	domain := new(Domain)
-	domain.Name = m.Name
-	err = c.domainRepo.Persist(domain)
+	validationErr := validateDomain(m)
+	if validationErr != nil {
+		HttpError(w, r, validationErr)
+		return
+	}
+	ok = true
+	return
+}
+
+// Stores the metadata and tags of m on domain in one transaction, sends a problem if that fails
+func (c *DomainController) storeItem(w http.ResponseWriter, r *http.Request, domain *Domain, m *DomainModel) (ok bool) {
+	tx, err := c.db.Begin()
 	if err != nil {
-		HttpProblem(w, http.StatusBadRequest, "Failed to create domain: "+err.Error())
+		HttpError(w, r, err)
 		return
 	}
-	m = *transformEntity(domain, getHttpHost(r)+"/domain/%d")
-	w.Header().Add("Location", m.JsonLDId)
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	err = c.storeItemTx(tx, domain, m)
+	if err != nil {
+		tx.Rollback()
+		HttpError(w, r, err)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		HttpError(w, r, err)
+		return
+	}
+	ok = true
+	return
+}
+
+func (c *DomainController) storeItemTx(tx *sql.Tx, domain *Domain, m *DomainModel) (err error) {
+	applyDomainModel(domain, m)
+	err = c.domainRepo.PersistTx(tx, domain)
+	if err != nil {
+		return
+	}
+	err = c.tagRepo.SetDomainTagsTx(tx, domain.Id, m.Tags)
+	return
+}
+
+// Copies the metadata of a validated model to domain, a domain stays enabled or disabled if enabled is omitted
+func applyDomainModel(domain *Domain, m *DomainModel) {
+	domain.OwnerName = m.OwnerName
+	domain.Contacts = m.Contacts
+	domain.Registrar = m.Registrar
+	domain.Notes = m.Notes
+	domain.ExternalRef = m.ExternalRef
+	if m.Enabled != nil {
+		domain.Disabled = !*m.Enabled
+	}
+}
+
+// Returns the query string of a listing page, keeping the filter
+func domainListingQuery(filter *DomainFilter, offsetKey string) string {
+	q := url.Values{}
+	q.Set("offsetKey", offsetKey)
+	if len(filter.Owner) > 0 {
+		q.Set("owner", filter.Owner)
+	}
+	if len(filter.Contact) > 0 {
+		q.Set("contact", filter.Contact)
+	}
+	if len(filter.Registrar) > 0 {
+		q.Set("registrar", filter.Registrar)
+	}
+	if len(filter.ExternalRef) > 0 {
+		q.Set("externalRef", filter.ExternalRef)
+	}
+	if len(filter.Tag) > 0 {
+		q.Set("tag", filter.Tag)
+	}
+	if filter.Valid != nil {
+		q.Set("valid", strconv.FormatBool(*filter.Valid))
+	}
+	if filter.Enabled != nil {
+		q.Set("enabled", strconv.FormatBool(*filter.Enabled))
+	}
+	if len(filter.Name) > 0 {
+		q.Set("name", filter.Name)
+	}
+	if len(filter.NamePrefix) > 0 {
+		q.Set("namePrefix", filter.NamePrefix)
+	}
+	for param, t := range map[string]*time.Time{"createdAfter": filter.CreatedAfter, "createdBefore": filter.CreatedBefore, "lastCheckedBefore": filter.LastCheckedBefore} {
+		if t != nil {
+			q.Set(param, t.Format(time.RFC3339Nano))
+		}
+	}
+	if len(filter.FailureReason) > 0 {
+		q.Set("failureReason", filter.FailureReason)
+	}
+	if len(filter.Sort) > 0 {
+		q.Set("sort", filter.Sort)
+	}
+	return q.Encode()
+}
+
+// Reads the filter and sort parameters of a listing into filter
+func parseDomainFilter(form url.Values, filter *DomainFilter) (err error) {
+	filter.Owner = form.Get("owner")
+	filter.Contact = form.Get("contact")
+	filter.Registrar = form.Get("registrar")
+	filter.ExternalRef = form.Get("externalRef")
+	filter.Tag = strings.ToLower(form.Get("tag"))
+	for _, param := range []struct {
+		name   string
+		target **bool
+	}{
+		{"valid", &filter.Valid},
+		{"enabled", &filter.Enabled},
+	} {
+		if len(form.Get(param.name)) == 0 {
+			continue
+		}
+		b, parseErr := strconv.ParseBool(form.Get(param.name))
+		if parseErr != nil {
+			err = NewValidationError(fmt.Sprintf("Invalid %s: %s", param.name, form.Get(param.name)))
+			return
+		}
+		*param.target = &b
+	}
+	filter.Name = form.Get("name")
+	filter.NamePrefix = form.Get("namePrefix")
+	for _, param := range []struct {
+		name   string
+		target **time.Time
+	}{
+		{"createdAfter", &filter.CreatedAfter},
+		{"createdBefore", &filter.CreatedBefore},
+		{"lastCheckedBefore", &filter.LastCheckedBefore},
+	} {
+		if len(form.Get(param.name)) == 0 {
+			continue
+		}
+		t, parseErr := time.Parse(time.RFC3339, form.Get(param.name))
+		if parseErr != nil {
+			err = NewValidationError(fmt.Sprintf("Invalid %s: %s", param.name, form.Get(param.name)))
+			return
+		}
+		*param.target = &t
+	}
+	filter.FailureReason = form.Get("failureReason")
+	if len(filter.FailureReason) > 0 {
+		if _, ok := failureReasonConditions[filter.FailureReason]; !ok {
+			err = NewValidationError(fmt.Sprintf("Invalid failureReason: %s", filter.FailureReason))
+			return
+		}
+	}
+	filter.Sort = form.Get("sort")
+	if _, _, ok := filter.order(); !ok {
+		err = NewValidationError(fmt.Sprintf("Invalid sort: %s", filter.Sort))
+		return
+	}
+	return
+}
+
+// Checks the metadata of a domain, contacts are normalized to plain addresses
+func validateDomain(m *DomainModel) (err error) {
+	if len(m.Name) == 0 || len(m.Name) > 128 || !domainNamePattern.MatchString(m.Name) {
+		err = NewValidationError(fmt.Sprintf("Invalid name: %s", m.Name))
+		return
+	}
+	if len(m.OwnerName) > 256 {
+		err = NewValidationError("Owner name must not be longer than 256 characters")
+		return
+	}
+	if len(m.Contacts) > 10 {
+		err = NewValidationError("Not more than 10 contacts allowed")
+		return
+	}
+	for i, contact := range m.Contacts {
+		address, addressErr := mail.ParseAddress(contact)
+		if addressErr != nil || len(address.Address) > 256 {
+			err = NewValidationError(fmt.Sprintf("Invalid contact: %s", contact))
+			return
+		}
+		m.Contacts[i] = address.Address
+	}
+	if len(m.Registrar) > 128 {
+		err = NewValidationError("Registrar must not be longer than 128 characters")
+		return
+	}
+	if len(m.Notes) > 4096 {
+		err = NewValidationError("Notes must not be longer than 4096 characters")
+		return
+	}
+	if len(m.ExternalRef) > 128 {
+		err = NewValidationError("External reference must not be longer than 128 characters")
+		return
+	}
+	m.Tags, err = normalizeTags(m.Tags)
+	return
+}
+
+// Lower cases tags and removes duplicates, returns an error for invalid tags
+func normalizeTags(tags []string) (normalized []string, err error) {
+	normalized = make([]string, 0)
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !tagPattern.MatchString(tag) {
+			err = NewValidationError(fmt.Sprintf("Invalid tag: %s", tag))
+			return
+		}
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return
 }
 
 func (c *DomainController) ItemHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
 	id, err := strconv.ParseInt(routeParams[1], 0, 64)
 	if err != nil {
-		HttpProblem(w, http.StatusBadRequest, "Invalid id: "+routeParams[1])
+		HttpProblem(w, r, http.StatusBadRequest, "Invalid id: "+routeParams[1])
 		return
 	}
 	domain, findErr := c.domainRepo.FindById(id)
 	if findErr != nil {
-		HttpProblem(w, http.StatusNotFound, "Domain not found: "+routeParams[1])
+		HttpError(w, r, notFound(findErr, "Domain not found: "+routeParams[1]))
 		return
 	}
 
 	if r.Method == "DELETE" {
-		c.domainRepo.Remove(domain)
-		w.WriteHeader(http.StatusNoContent)
+		c.removeItem(w, r, domain)
+		return
+	}
+	if r.Method == "PATCH" {
+		c.patchItem(w, r, domain)
 		return
 	}
+	c.showItem(w, r, domain)
+}
+
+// The fields of a domain which can be changed with a merge patch
+var patchableDomainFields = map[string]bool{
+	"name":        true,
+	"ownerName":   true,
+	"contacts":    true,
+	"registrar":   true,
+	"notes":       true,
+	"externalRef": true,
+	"tags":        true,
+	"enabled":     true,
+}
 
+// Applies a JSON Merge Patch (RFC 7386) to a domain, null resets a field
+func (c *DomainController) patchItem(w http.ResponseWriter, r *http.Request, domain *Domain) {
+	// Before anything is changed
+	mediaType, acceptable := negotiateResponse(w, r, itemMediaTypes)
+	if !acceptable {
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "application/merge-patch+json" && contentType != "application/json" {
+		HttpProblem(w, r, http.StatusBadRequest, "Expected application/merge-patch+json got "+contentType)
+		return
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		HttpProblem(w, r, http.StatusBadRequest, "Failed to read body: "+err.Error())
+		return
+	}
+	var patch map[string]json.RawMessage
+	err = json.Unmarshal(b, &patch)
+	if err != nil {
+		HttpProblem(w, r, http.StatusBadRequest, "Failed to parse request: "+bytes.NewBuffer(b).String())
+		return
+	}
+
+	m := transformEntity(domain, getHttpHost(r)+"/domain/%d")
+	tags, err := c.tagRepo.FindByDomain(domain.Id)
+	if err != nil {
+		HttpError(w, r, err)
+		return
+	}
+	m.Tags = tags
+	for field, value := range patch {
+		if !patchableDomainFields[field] {
+			HttpProblem(w, r, http.StatusBadRequest, "Field can not be changed: "+field)
+			return
+		}
+		if string(value) != "null" {
+			continue
+		}
+		// Unmarshal keeps strings on null
+		switch field {
+		case "name":
+			m.Name = ""
+		case "ownerName":
+			m.OwnerName = ""
+		case "registrar":
+			m.Registrar = ""
+		case "notes":
+			m.Notes = ""
+		case "externalRef":
+			m.ExternalRef = ""
+		}
+	}
+	err = json.Unmarshal(b, m)
+	if err != nil {
+		HttpProblem(w, r, http.StatusBadRequest, "Failed to parse request: "+err.Error())
+		return
+	}
+	if value, ok := patch["enabled"]; ok && string(value) == "null" {
+		enabled := true
+		m.Enabled = &enabled
+	}
+	validationErr := validateDomain(m)
+	if validationErr != nil {
+		HttpError(w, r, validationErr)
+		return
+	}
+	domain.Name = m.Name
+	if !c.storeItem(w, r, domain, m) {
+		return
+	}
+	c.writeItem(w, r, domain, mediaType, http.StatusOK)
+}
+
+func (c *DomainController) removeItem(w http.ResponseWriter, r *http.Request, domain *Domain) {
+	err := c.domainRepo.Remove(domain)
+	if err != nil {
+		HttpError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Shows (GET), creates or updates (PUT) or removes (DELETE) the domain with the given name
+func (c *DomainController) ByNameHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
+	name := routeParams[1]
+	if r.Method == "PUT" {
+		c.putItem(w, r, name)
+		return
+	}
+	domain, findErr := c.domainRepo.FindByName(name)
+	if findErr != nil {
+		HttpError(w, r, notFound(findErr, "Domain not found: "+name))
+		return
+	}
+	if r.Method == "DELETE" {
+		c.removeItem(w, r, domain)
+		return
+	}
+	c.showItem(w, r, domain)
+}
+
+// Creates or updates the domain with name in one transaction, concurrent requests
+// with the same body have the same outcome
+func (c *DomainController) putItem(w http.ResponseWriter, r *http.Request, name string) {
+	// Before anything is changed
+	mediaType, acceptable := negotiateResponse(w, r, itemMediaTypes)
+	if !acceptable {
+		return
+	}
+	m, ok := readDomainModel(w, r)
+	if !ok {
+		return
+	}
+	if m.Name != name {
+		HttpProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Name %s does not match %s", m.Name, name))
+		return
+	}
+	tx, err := c.db.Begin()
+	if err != nil {
+		HttpError(w, r, err)
+		return
+	}
+	created, err := c.domainRepo.CreateIfMissingTx(tx, name)
+	var domain *Domain
+	if err == nil {
+		domain, err = c.domainRepo.FindByNameTx(tx, name)
+	}
+	if err == nil {
+		err = c.storeItemTx(tx, domain, m)
+	}
+	if err != nil {
+		tx.Rollback()
+		HttpError(w, r, err)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		HttpError(w, r, err)
+		return
+	}
+	if created {
+		w.Header().Add("Location", transformEntity(domain, getHttpHost(r)+"/domain/%d").JsonLDId)
+		c.writeItem(w, r, domain, mediaType, http.StatusCreated)
+		return
+	}
+	c.writeItem(w, r, domain, mediaType, http.StatusOK)
+}
+
+func (c *DomainController) showItem(w http.ResponseWriter, r *http.Request, domain *Domain) {
+	mediaType, acceptable := negotiateResponse(w, r, itemMediaTypes)
+	if !acceptable {
+		return
+	}
+	c.writeItem(w, r, domain, mediaType, http.StatusOK)
+}
+
+// Writes domain as the negotiated mediaType with status
+func (c *DomainController) writeItem(w http.ResponseWriter, r *http.Request, domain *Domain, mediaType string, status int) {
 	// Find latest check
 	domainCheck, checkErr := c.domainCheckRepo.FindLatestByDomain(domain.Name)
 
-	w.Header().Add("Content-Type", "application/json")
 	m := transformEntity(domain, getHttpHost(r)+"/domain/%d")
 	if checkErr == nil {
 		m.Check = transformCheckEntity(domainCheck, getHttpHost(r)+"/check/%d")
 		m.Valid = domainCheck.Valid
 	}
-	encoder := json.NewEncoder(w)
-	encoder.Encode(m)
+	schedule, scheduleErr := c.domainScheduleRepo.FindByDomain(domain.Name)
+	if scheduleErr == nil {
+		m.NextCheck = schedule.NextCheck
+	}
+	tags, tagsErr := c.tagRepo.FindByDomain(domain.Id)
+	if tagsErr == nil {
+		m.Tags = tags
+	}
+	m.Maintenance = c.activeMaintenance(r, domain)
+	writeModelStatus(w, status, mediaType, m, map[string]string{
+		"checks":      m.JsonLDId + "/checks",
+		"tags":        m.JsonLDId + "/tags",
+		"maintenance": m.JsonLDId + "/maintenance",
+	})
+}
+
+// Lists (GET), replaces (PUT with a JSON array) or adds (POST with a JSON string) the tags of a domain
+func (c *DomainController) TagsHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
+	id, err := strconv.ParseInt(routeParams[1], 0, 64)
+	if err != nil {
+		HttpProblem(w, r, http.StatusBadRequest, "Invalid id: "+routeParams[1])
+		return
+	}
+	domain, findErr := c.domainRepo.FindById(id)
+	if findErr != nil {
+		HttpError(w, r, notFound(findErr, "Domain not found: "+routeParams[1]))
+		return
+	}
+	mediaType, acceptable := negotiateResponse(w, r, tagsMediaTypes)
+	if !acceptable {
+		return
+	}
+
+	if r.Method != "GET" {
+		if r.Header.Get("Content-Type") != "application/json" {
+			HttpProblem(w, r, http.StatusBadRequest, "Expected application/json got "+r.Header.Get("Content-Type"))
+			return
+		}
+		b, readErr := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if readErr != nil {
+			HttpProblem(w, r, http.StatusBadRequest, "Failed to read body: "+readErr.Error())
+			return
+		}
+		var tags []string
+		if r.Method == "POST" {
+			var tag string
+			err = json.Unmarshal(b, &tag)
+			tags = []string{tag}
+		} else {
+			err = json.Unmarshal(b, &tags)
+		}
+		if err != nil {
+			HttpProblem(w, r, http.StatusBadRequest, "Failed to parse request: "+bytes.NewBuffer(b).String())
+			return
+		}
+		tags, err = normalizeTags(tags)
+		if err != nil {
+			HttpError(w, r, err)
+			return
+		}
+		if r.Method == "POST" {
+			err = c.tagRepo.AddDomainTag(domain.Id, tags[0])
+		} else {
+			err = c.tagRepo.SetDomainTags(domain.Id, tags)
+		}
+		if err != nil {
+			HttpError(w, r, err)
+			return
+		}
+	}
+
+	tags, err := c.tagRepo.FindByDomain(domain.Id)
+	if err != nil {
+		HttpError(w, r, err)
+		return
+	}
+	writeModel(w, mediaType, tags, nil)
+}
+
+// Removes a tag from a domain
+func (c *DomainController) TagHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
+	id, err := strconv.ParseInt(routeParams[1], 0, 64)
+	if err != nil {
+		HttpProblem(w, r, http.StatusBadRequest, "Invalid id: "+routeParams[1])
+		return
+	}
+	domain, findErr := c.domainRepo.FindById(id)
+	if findErr != nil {
+		HttpError(w, r, notFound(findErr, "Domain not found: "+routeParams[1]))
+		return
+	}
+	err = c.tagRepo.RemoveDomainTag(domain.Id, strings.ToLower(routeParams[2]))
+	if err != nil {
+		HttpError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Returns the maintenance windows of domain which are active now
+func (c *DomainController) activeMaintenance(r *http.Request, domain *Domain) (windows []*MaintenanceWindowModel) {
+	windows = []*MaintenanceWindowModel{}
+	now := time.Now()
+	items, err := c.maintenanceRepo.FindActiveByDomain(domain.Id, now)
+	if err != nil {
+		return
+	}
+	for _, item := range items {
+		windows = append(windows, transformMaintenanceWindowEntity(item, getHttpHost(r)+"/domain/%d/maintenance/%d", now))
+	}
+	return
+}
+
+// Lists (GET) or adds (POST) the maintenance windows of a domain
+func (c *DomainController) MaintenanceHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
+	id, err := strconv.ParseInt(routeParams[1], 0, 64)
+	if err != nil {
+		HttpProblem(w, r, http.StatusBadRequest, "Invalid id: "+routeParams[1])
+		return
+	}
+	domain, findErr := c.domainRepo.FindById(id)
+	if findErr != nil {
+		HttpError(w, r, notFound(findErr, "Domain not found: "+routeParams[1]))
+		return
+	}
+	mediaType, acceptable := negotiateResponse(w, r, itemMediaTypes)
+	if !acceptable {
+		return
+	}
+	if r.Method == "POST" {
+		c.createMaintenance(w, r, domain, mediaType)
+		return
+	}
+
+	items, err := c.maintenanceRepo.FindByDomain(domain.Id)
+	if err != nil {
+		HttpError(w, r, err)
+		return
+	}
+	now := time.Now()
+	list := new(MaintenanceWindowListModel)
+	list.Total = len(items)
+	list.JsonLDContext = "http://jsonld.click4life.hiv/List"
+	list.JsonLDType = "http://jsonld.click4life.hiv/MaintenanceWindow"
+	list.JsonLDId = fmt.Sprintf("%s/domain/%d/maintenance", getHttpHost(r), domain.Id)
+	list.Items = make([]*MaintenanceWindowModel, len(items))
+	for i, item := range items {
+		list.Items[i] = transformMaintenanceWindowEntity(item, getHttpHost(r)+"/domain/%d/maintenance/%d", now)
+	}
+	writeModel(w, mediaType, list, nil)
+}
+
+func (c *DomainController) createMaintenance(w http.ResponseWriter, r *http.Request, domain *Domain, mediaType string) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		HttpProblem(w, r, http.StatusBadRequest, "Expected application/json got "+r.Header.Get("Content-Type"))
+		return
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		HttpProblem(w, r, http.StatusBadRequest, "Failed to read body: "+err.Error())
+		return
+	}
+	var m MaintenanceWindowModel
+	unmarshalErr := json.Unmarshal(b, &m)
+	if unmarshalErr != nil {
+		HttpProblem(w, r, http.StatusBadRequest, "Failed to parse request: "+bytes.NewBuffer(b).String())
+		return
+	}
+	validationErr := validateMaintenanceWindow(&m)
+	if validationErr != nil {
+		HttpError(w, r, validationErr)
+		return
+	}
+	window := new(MaintenanceWindow)
+	window.DomainId = domain.Id
+	window.Starts = m.Starts
+	window.Ends = m.Ends
+	window.Reason = m.Reason
+	err = c.maintenanceRepo.Persist(window)
+	if err != nil {
+		HttpError(w, r, err)
+		return
+	}
+	created := transformMaintenanceWindowEntity(window, getHttpHost(r)+"/domain/%d/maintenance/%d", time.Now())
+	w.Header().Add("Location", created.JsonLDId)
+	writeModelStatus(w, http.StatusCreated, mediaType, created, nil)
+}
+
+func validateMaintenanceWindow(m *MaintenanceWindowModel) (err error) {
+	if m.Starts == nil || m.Ends == nil {
+		err = NewValidationError("Start and end are required")
+		return
+	}
+	if !m.Starts.Before(*m.Ends) {
+		err = NewValidationError("Start must be before end")
+		return
+	}
+	if len(m.Reason) > 1024 {
+		err = NewValidationError("Reason must not be longer than 1024 characters")
+		return
+	}
+	return
+}
+
+// Shows (GET) or removes (DELETE) a maintenance window of a domain
+func (c *DomainController) MaintenanceItemHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
+	domainId, err := strconv.ParseInt(routeParams[1], 0, 64)
+	if err != nil {
+		HttpProblem(w, r, http.StatusBadRequest, "Invalid id: "+routeParams[1])
+		return
+	}
+	id, err := strconv.ParseInt(routeParams[2], 0, 64)
+	if err != nil {
+		HttpProblem(w, r, http.StatusBadRequest, "Invalid id: "+routeParams[2])
+		return
+	}
+	window, findErr := c.maintenanceRepo.FindById(id)
+	if findErr != nil || window.DomainId != domainId {
+		HttpError(w, r, notFound(findErr, "Maintenance window not found: "+routeParams[2]))
+		return
+	}
+
+	if r.Method == "DELETE" {
+		err = c.maintenanceRepo.Remove(window)
+		if err != nil {
+			HttpError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	mediaType, acceptable := negotiateResponse(w, r, itemMediaTypes)
+	if !acceptable {
+		return
+	}
+	writeModel(w, mediaType, transformMaintenanceWindowEntity(window, getHttpHost(r)+"/domain/%d/maintenance/%d", time.Now()), nil)
+}
+
+// Queues an immediate check of a domain, the returned job tells when it is done
+func (c *DomainController) CheckHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
+	id, err := strconv.ParseInt(routeParams[1], 0, 64)
+	if err != nil {
+		HttpProblem(w, r, http.StatusBadRequest, "Invalid id: "+routeParams[1])
+		return
+	}
+	domain, findErr := c.domainRepo.FindById(id)
+	if findErr != nil {
+		HttpError(w, r, notFound(findErr, "Domain not found: "+routeParams[1]))
+		return
+	}
+	mediaType, acceptable := negotiateResponse(w, r, itemMediaTypes)
+	if !acceptable {
+		return
+	}
+	job, err := c.jobRepo.Enqueue(domain.Name)
+	if err != nil {
+		HttpError(w, r, err)
+		return
+	}
+	m := transformCheckJobEntity(job, getHttpHost(r)+"/job/%d", getHttpHost(r)+"/check/%d")
+	w.Header().Add("Location", m.JsonLDId)
+	writeModelStatus(w, http.StatusAccepted, mediaType, m, nil)
+}
+
+// Lists the checks of a domain newest first, optionally restricted to since and until (RFC 3339)
+func (c *DomainController) ChecksHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
+	id, err := strconv.ParseInt(routeParams[1], 0, 64)
+	if err != nil {
+		HttpProblem(w, r, http.StatusBadRequest, "Invalid id: "+routeParams[1])
+		return
+	}
+	domain, findErr := c.domainRepo.FindById(id)
+	if findErr != nil {
+		HttpError(w, r, notFound(findErr, "Domain not found: "+routeParams[1]))
+		return
+	}
+	mediaType, acceptable := negotiateResponse(w, r, listMediaTypes)
+	if !acceptable {
+		return
+	}
+	formErr := r.ParseForm()
+	if formErr != nil {
+		HttpProblem(w, r, http.StatusBadRequest, formErr.Error())
+		return
+	}
+
+	itemsPerPage := 100
+	offsetKey := r.Form.Get("offsetKey")
+	filter := new(DomainCheckFilter)
+	filter.Domain = domain.Name
+	for param, target := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if len(r.Form.Get(param)) == 0 {
+			continue
+		}
+		t, parseErr := time.Parse(time.RFC3339, r.Form.Get(param))
+		if parseErr != nil {
+			HttpProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid %s: %s", param, r.Form.Get(param)))
+			return
+		}
+		*target = &t
+	}
+	items, findErr := c.domainCheckRepo.FindHistory(itemsPerPage, offsetKey, filter)
+	if findErr != nil {
+		HttpError(w, r, findErr)
+		return
+	}
+	total, _, statsErr := c.domainCheckRepo.Stats(filter)
+	if statsErr != nil {
+		HttpError(w, r, statsErr)
+		return
+	}
+
+	route := fmt.Sprintf("%s/domain/%d/checks", getHttpHost(r), domain.Id)
+	list := new(DomainCheckListModel)
+	list.Total = total
+	list.JsonLDContext = "http://jsonld.click4life.hiv/List"
+	list.JsonLDType = "http://jsonld.click4life.hiv/DomainCheck"
+	list.JsonLDId = route
+	list.Items = make([]*DomainCheckModel, len(items))
+	for i, item := range items {
+		list.Items[i] = transformCheckEntity(item, getHttpHost(r)+"/check/%d")
+	}
+
+	// The history goes back in time, so unlike the listings there is no next link
+	// after the oldest check which could be polled for new items
+	links := map[string]string{"domain": fmt.Sprintf("%s/domain/%d", getHttpHost(r), domain.Id)}
+	if len(items) > 0 {
+		last := list.Items[len(items)-1]
+		q := url.Values{}
+		q.Set("offsetKey", last.Id)
+		for _, param := range []string{"since", "until"} {
+			if len(r.Form.Get(param)) > 0 {
+				q.Set(param, r.Form.Get(param))
+			}
+		}
+		links["next"] = fmt.Sprintf("%s?%s", route, q.Encode())
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="next"`, links["next"]))
+	}
+	if mediaType == MEDIA_TYPE_CSV {
+		writeCsv(w, checkCsvHeader, checkCsvRecords(items))
+		return
+	}
+	writeModel(w, mediaType, list, links)
 }
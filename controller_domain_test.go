@@ -8,8 +8,11 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -23,10 +26,20 @@ func SetupDomainTest(t *testing.T) (cntrl *DomainController) {
 	db, _ := sql.Open("postgres", c.DSN())
 
 	cntrl = new(DomainController)
+	cntrl.db = db
 	cntrl.domainRepo = NewDomainRepository(db)
 	cntrl.domainCheckRepo = NewDomainCheckRepository(db)
+	cntrl.domainScheduleRepo = NewDomainScheduleRepository(db)
+	cntrl.tagRepo = NewTagRepository(db)
+	cntrl.maintenanceRepo = NewMaintenanceWindowRepository(db)
+	cntrl.jobRepo = NewCheckJobRepository(db)
 	db.Exec("TRUNCATE domain RESTART IDENTITY")
 	db.Exec("TRUNCATE domain_check RESTART IDENTITY")
+	db.Exec("TRUNCATE domain_schedule RESTART IDENTITY")
+	db.Exec("TRUNCATE tag RESTART IDENTITY CASCADE")
+	db.Exec("TRUNCATE domain_tag")
+	db.Exec("TRUNCATE maintenance_window RESTART IDENTITY")
+	db.Exec("TRUNCATE check_job RESTART IDENTITY")
 
 	data := []string{"example.hiv", "acme.hiv"}
 	for _, name := range data {
@@ -69,7 +82,7 @@ func TestThatItListsDomains(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	assert.Equal("application/json", res.Header.Get("Content-Type"))
+	assert.Equal("application/ld+json", res.Header.Get("Content-Type"))
 
 	var l DomainListModel
 	unmarshalErr := json.Unmarshal(b, &l)
@@ -122,6 +135,48 @@ func TestThatItReturnsNextUrlAfterEndOfDomains(t *testing.T) {
 	assert.Equal(`<`+ts.URL+`/domain?offsetKey=2>; rel="next"`, res.Header.Get("Link"))
 }
 
+func TestThatItNegotiatesTheDomainListing(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl := SetupDomainTest(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.ListingHandler(w, r, nil)
+	}))
+	defer ts.Close()
+
+	get := func(accept string) (res *http.Response, b []byte) {
+		req, _ := http.NewRequest("GET", ts.URL+"/domain", nil)
+		req.Header.Set("Accept", accept)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return
+	}
+
+	res, b := get("application/hal+json")
+	assert.Equal("application/hal+json", res.Header.Get("Content-Type"))
+	assert.Equal("Accept", res.Header.Get("Vary"))
+	var hal map[string]interface{}
+	assert.Nil(json.Unmarshal(b, &hal))
+	links := hal["_links"].(map[string]interface{})
+	assert.Equal(ts.URL+"/domain?offsetKey=2", links["next"].(map[string]interface{})["href"])
+	items := hal["_embedded"].(map[string]interface{})["items"].([]interface{})
+	assert.Equal(2, len(items))
+
+	res, b = get("text/csv")
+	assert.Equal("text/csv; charset=utf-8", res.Header.Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	assert.Equal(3, len(lines))
+	assert.True(strings.HasPrefix(lines[1], "1,example.hiv,"))
+
+	res, _ = get("text/html")
+	assert.Equal(http.StatusNotAcceptable, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+}
+
 func TestThatItAddsNewDomain(t *testing.T) {
 	assert := assert.New(t)
 
@@ -137,6 +192,50 @@ func TestThatItAddsNewDomain(t *testing.T) {
 		t.Fatal(err)
 	}
 	assert.Equal(http.StatusCreated, res.StatusCode)
+	assert.Equal("application/ld+json", res.Header.Get("Content-Type"))
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	var m DomainModel
+	assert.Nil(json.Unmarshal(b, &m))
+	assert.Equal("test.hiv", m.Name)
+	assert.Equal(res.Header.Get("Location"), m.JsonLDId)
+
+	// Not created if the response can not be negotiated
+	req, _ := http.NewRequest("POST", ts.URL+"/domain", bytes.NewBufferString(`{"name":"other.hiv"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/csv")
+	res, err = http.DefaultClient.Do(req)
+	assert.Nil(err)
+	assert.Equal(http.StatusNotAcceptable, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+	_, err = cntrl.domainRepo.FindByName("other.hiv")
+	assert.NotNil(err)
+}
+
+func TestThatItRejectsDuplicateDomains(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl := SetupDomainTest(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.ListingHandler(w, r, nil)
+	}))
+	defer ts.Close()
+
+	res, err := http.Post(ts.URL+"/domain", "application/json", bytes.NewBufferString(`{"name":"example.hiv"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(http.StatusConflict, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+
+	p := new(HttpProblemModel)
+	assert.Nil(json.Unmarshal(b, p))
+	assert.Equal(PROBLEM_TYPE_CONFLICT, p.Type)
+	assert.Equal(http.StatusConflict, p.Status)
+	assert.Equal("A domain with this name already exists", p.Detail)
+	assert.Equal("/domain", p.Instance)
 }
 
 func TestThatItFetchesNewDomain(t *testing.T) {
@@ -151,6 +250,9 @@ func TestThatItFetchesNewDomain(t *testing.T) {
 	d := new(Domain)
 	d.Name = "test.hiv"
 	cntrl.domainRepo.Persist(d)
+	schedule := new(DomainSchedule)
+	schedule.Domain = d.Name
+	assert.Nil(cntrl.domainScheduleRepo.Persist(schedule))
 
 	// Fetch the new domain
 	fetchRes, fetchErr := http.Get(fmt.Sprintf("%s/domain/%d", ts.URL, d.Id))
@@ -164,7 +266,7 @@ func TestThatItFetchesNewDomain(t *testing.T) {
 	if readErr != nil {
 		t.Fatal(readErr)
 	}
-	assert.Equal("application/json", fetchRes.Header.Get("Content-Type"))
+	assert.Equal("application/ld+json", fetchRes.Header.Get("Content-Type"))
 
 	var m DomainModel
 	unmarshalErr := json.Unmarshal(b, &m)
@@ -174,6 +276,7 @@ func TestThatItFetchesNewDomain(t *testing.T) {
 	assert.Equal("test.hiv", m.Name)
 	assert.Equal(fmt.Sprintf("%s/domain/%d", ts.URL, d.Id), m.JsonLDId)
 	assert.Equal("http://jsonld.click4life.hiv/Domain", m.JsonLDContext)
+	assert.NotNil(m.NextCheck)
 }
 
 func TestThatItDeletesDomain(t *testing.T) {
@@ -203,3 +306,567 @@ func TestThatItDeletesDomain(t *testing.T) {
 	all, _ := cntrl.domainRepo.FindAll()
 	assert.Equal(2, len(all))
 }
+
+func TestThatItAddsDomainWithMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl := SetupDomainTest(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.ListingHandler(w, r, nil)
+	}))
+	defer ts.Close()
+
+	var data = []byte(`{"name":"test.hiv","ownerName":"Test Inc.","contacts":["Owner <owner@test.hiv>"],"registrar":"ACME","notes":"VIP","externalRef":"CRM-7"}`)
+	res, err := http.Post(ts.URL+"/domain", "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(http.StatusCreated, res.StatusCode)
+
+	d, findErr := cntrl.domainRepo.FindByName("test.hiv")
+	assert.Nil(findErr)
+	assert.Equal("Test Inc.", d.OwnerName)
+	assert.Equal([]string{"owner@test.hiv"}, d.Contacts)
+	assert.Equal("ACME", d.Registrar)
+	assert.Equal("VIP", d.Notes)
+	assert.Equal("CRM-7", d.ExternalRef)
+
+	// Filter listing
+	res, err = http.Get(ts.URL + "/domain?registrar=acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l DomainListModel
+	assert.Nil(json.Unmarshal(b, &l))
+	assert.Equal(1, l.Total)
+	assert.Equal("test.hiv", l.Items[0].Name)
+	assert.Equal("CRM-7", l.Items[0].ExternalRef)
+	assert.Equal([]string{"owner@test.hiv"}, l.Items[0].Contacts)
+	assert.Equal(`<`+ts.URL+`/domain?offsetKey=3&registrar=acme>; rel="next"`, res.Header.Get("Link"))
+}
+
+func TestThatItValidatesDomainMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl := SetupDomainTest(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.ListingHandler(w, r, nil)
+	}))
+	defer ts.Close()
+
+	for _, data := range []string{
+		`{"name":""}`,
+		`{"name":"test.hiv","contacts":["not an email"]}`,
+		`{"name":"test.hiv","externalRef":"` + strings.Repeat("x", 129) + `"}`,
+	} {
+		res, err := http.Post(ts.URL+"/domain", "application/json", bytes.NewBufferString(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(http.StatusBadRequest, res.StatusCode, data)
+		assert.Equal("application/problem+json", res.Header.Get("Content-Type"), data)
+	}
+}
+
+func TestThatItTagsDomains(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl := SetupDomainTest(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if matches := regexp.MustCompile("^/domain/([0-9]+)/tags/([^/]+)$").FindStringSubmatch(r.URL.Path); matches != nil {
+			cntrl.TagHandler(w, r, matches)
+		} else if matches := regexp.MustCompile("^/domain/([0-9]+)/tags$").FindStringSubmatch(r.URL.Path); matches != nil {
+			cntrl.TagsHandler(w, r, matches)
+		} else {
+			cntrl.ListingHandler(w, r, nil)
+		}
+	}))
+	defer ts.Close()
+
+	doRequest := func(method string, path string, body string) (tags []string) {
+		req, _ := http.NewRequest(method, ts.URL+path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		json.Unmarshal(b, &tags)
+		return
+	}
+
+	assert.Equal([]string{"campaign-1", "partner"}, doRequest("PUT", "/domain/1/tags", `["Partner", "campaign-1", "partner"]`))
+	assert.Equal([]string{"campaign-1", "partner", "premium"}, doRequest("POST", "/domain/1/tags", `"premium"`))
+	doRequest("DELETE", "/domain/1/tags/campaign-1", "")
+	assert.Equal([]string{"partner", "premium"}, doRequest("GET", "/domain/1/tags", ""))
+	doRequest("PUT", "/domain/2/tags", `["partner"]`)
+
+	// Tags are only available as JSON
+	req, _ := http.NewRequest("PUT", ts.URL+"/domain/1/tags", bytes.NewBufferString(`["other"]`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/csv")
+	tagsRes, err := http.DefaultClient.Do(req)
+	assert.Nil(err)
+	assert.Equal(http.StatusNotAcceptable, tagsRes.StatusCode)
+	assert.Equal("application/problem+json", tagsRes.Header.Get("Content-Type"))
+	assert.Equal([]string{"partner", "premium"}, doRequest("GET", "/domain/1/tags", ""))
+
+	// Filter listing
+	res, err := http.Get(ts.URL + "/domain?tag=premium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l DomainListModel
+	assert.Nil(json.Unmarshal(b, &l))
+	assert.Equal(1, l.Total)
+	assert.Equal("example.hiv", l.Items[0].Name)
+	assert.Equal([]string{"partner", "premium"}, l.Items[0].Tags)
+
+	// Create with tags
+	var data = []byte(`{"name":"test.hiv","tags":["premium"]}`)
+	res, err = http.Post(ts.URL+"/domain", "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(http.StatusCreated, res.StatusCode)
+	tags, _ := cntrl.tagRepo.FindByDomain(3)
+	assert.Equal([]string{"premium"}, tags)
+
+	// Invalid tags are rejected
+	res, err = http.Post(ts.URL+"/domain", "application/json", bytes.NewBufferString(`{"name":"other.hiv","tags":["no spaces"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(http.StatusBadRequest, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+}
+
+func TestThatItManagesMaintenanceWindows(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl := SetupDomainTest(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if matches := regexp.MustCompile("^/domain/([0-9]+)/maintenance/([0-9]+)$").FindStringSubmatch(r.URL.Path); matches != nil {
+			cntrl.MaintenanceItemHandler(w, r, matches)
+		} else if matches := regexp.MustCompile("^/domain/([0-9]+)/maintenance$").FindStringSubmatch(r.URL.Path); matches != nil {
+			cntrl.MaintenanceHandler(w, r, matches)
+		} else {
+			cntrl.ItemHandler(w, r, regexp.MustCompile("^/domain/([0-9]+)$").FindStringSubmatch(r.URL.Path))
+		}
+	}))
+	defer ts.Close()
+
+	now := time.Now()
+	data := fmt.Sprintf(`{"starts":"%s","ends":"%s","reason":"Relaunch"}`, now.Add(-time.Hour).Format(time.RFC3339), now.Add(time.Hour).Format(time.RFC3339))
+	res, err := http.Post(ts.URL+"/domain/1/maintenance", "application/json", bytes.NewBufferString(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(http.StatusCreated, res.StatusCode)
+	assert.Equal(ts.URL+"/domain/1/maintenance/1", res.Header.Get("Location"))
+	assert.Equal("application/ld+json", res.Header.Get("Content-Type"))
+	created := new(MaintenanceWindowModel)
+	assert.Nil(json.NewDecoder(res.Body).Decode(created))
+	res.Body.Close()
+	assert.Equal("Relaunch", created.Reason)
+	assert.True(created.Active)
+
+	req, _ := http.NewRequest("POST", ts.URL+"/domain/1/maintenance", bytes.NewBufferString(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/xml")
+	res, err = http.DefaultClient.Do(req)
+	assert.Nil(err)
+	assert.Equal(http.StatusNotAcceptable, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+	data = fmt.Sprintf(`{"starts":"%s","ends":"%s"}`, now.Add(24*time.Hour).Format(time.RFC3339), now.Add(48*time.Hour).Format(time.RFC3339))
+	res, err = http.Post(ts.URL+"/domain/1/maintenance", "application/json", bytes.NewBufferString(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(http.StatusCreated, res.StatusCode)
+
+	// Ends before it starts
+	data = fmt.Sprintf(`{"starts":"%s","ends":"%s"}`, now.Format(time.RFC3339), now.Add(-time.Hour).Format(time.RFC3339))
+	res, err = http.Post(ts.URL+"/domain/1/maintenance", "application/json", bytes.NewBufferString(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(http.StatusBadRequest, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+
+	res, err = http.Get(ts.URL + "/domain/1/maintenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	var l MaintenanceWindowListModel
+	assert.Nil(json.Unmarshal(b, &l))
+	assert.Equal(2, l.Total)
+	assert.False(l.Items[0].Active)
+	assert.True(l.Items[1].Active)
+	assert.Equal("Relaunch", l.Items[1].Reason)
+
+	// Only active windows are shown on the domain
+	res, err = http.Get(ts.URL + "/domain/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	var m DomainModel
+	assert.Nil(json.Unmarshal(b, &m))
+	assert.Equal(1, len(m.Maintenance))
+	assert.Equal(ts.URL+"/domain/1/maintenance/1", m.Maintenance[0].JsonLDId)
+
+	// Windows belong to their domain
+	res, err = http.Get(ts.URL + "/domain/2/maintenance/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(http.StatusNotFound, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+
+	req, _ = http.NewRequest("DELETE", ts.URL+"/domain/1/maintenance/1", nil)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(http.StatusNoContent, res.StatusCode)
+	windows, _ := cntrl.maintenanceRepo.FindByDomain(1)
+	assert.Equal(1, len(windows))
+}
+
+func TestThatItQueuesAnImmediateCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl := SetupDomainTest(t)
+	jobCntrl := new(CheckJobController)
+	jobCntrl.jobRepo = cntrl.jobRepo
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if matches := regexp.MustCompile("^/job/([0-9]+)$").FindStringSubmatch(r.URL.Path); matches != nil {
+			jobCntrl.ItemHandler(w, r, matches)
+		} else {
+			cntrl.CheckHandler(w, r, regexp.MustCompile("^/domain/([0-9]+)/check$").FindStringSubmatch(r.URL.Path))
+		}
+	}))
+	defer ts.Close()
+
+	getJob := func(location string) (m *CheckJobModel) {
+		res, err := http.Get(location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		m = new(CheckJobModel)
+		assert.Nil(json.Unmarshal(b, m))
+		return
+	}
+
+	res, err := http.Post(ts.URL+"/domain/1/check", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(http.StatusAccepted, res.StatusCode)
+	assert.Equal(ts.URL+"/job/1", res.Header.Get("Location"))
+	assert.Equal("application/ld+json", res.Header.Get("Content-Type"))
+	job := getJob(res.Header.Get("Location"))
+	assert.Equal("example.hiv", job.Domain)
+	assert.Equal(JOB_STATE_QUEUED, job.State)
+	assert.Equal("", job.Check)
+
+	// An open job is reused
+	res, err = http.Post(ts.URL+"/domain/1/check", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(ts.URL+"/job/1", res.Header.Get("Location"))
+
+	claimed, err := cntrl.jobRepo.Claim("test", time.Minute)
+	assert.Nil(err)
+	assert.Equal(JOB_STATE_RUNNING, getJob(ts.URL+"/job/1").State)
+	claimed.State = JOB_STATE_DONE
+	claimed.CheckId = 1
+	assert.Nil(cntrl.jobRepo.Complete(claimed))
+	job = getJob(ts.URL + "/job/1")
+	assert.Equal(JOB_STATE_DONE, job.State)
+	assert.Equal(ts.URL+"/check/1", job.Check)
+
+	res, err = http.Post(ts.URL+"/domain/99/check", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(http.StatusNotFound, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+
+	// Not queued if the response can not be negotiated
+	req, _ := http.NewRequest("POST", ts.URL+"/domain/2/check", nil)
+	req.Header.Set("Accept", "text/csv")
+	res, err = http.DefaultClient.Do(req)
+	assert.Nil(err)
+	assert.Equal(http.StatusNotAcceptable, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+	jobs, _ := cntrl.jobRepo.FindAll()
+	assert.Equal(1, len(jobs))
+}
+
+func TestThatItListsTheCheckHistoryOfADomain(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl := SetupDomainTest(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.ChecksHandler(w, r, regexp.MustCompile("^/domain/([0-9]+)/checks$").FindStringSubmatch(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	for _, valid := range []bool{false, true} {
+		check := new(DomainCheck)
+		check.Domain = "example.hiv"
+		check.URL = "http://example.hiv"
+		check.Valid = valid
+		assert.Nil(cntrl.domainCheckRepo.Persist(check))
+	}
+	other := new(DomainCheck)
+	other.Domain = "acme.hiv"
+	other.URL = "http://acme.hiv"
+	assert.Nil(cntrl.domainCheckRepo.Persist(other))
+
+	getHistory := func(query string) (l *DomainCheckListModel) {
+		res, err := http.Get(ts.URL + "/domain/1/checks" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		l = new(DomainCheckListModel)
+		assert.Nil(json.Unmarshal(b, l))
+		return
+	}
+
+	l := getHistory("")
+	assert.Equal(3, l.Total)
+	// Newest first
+	assert.Equal(ts.URL+"/check/3", l.Items[0].JsonLDId)
+	assert.Equal(ts.URL+"/check/2", l.Items[1].JsonLDId)
+	assert.Equal(ts.URL+"/check/1", l.Items[2].JsonLDId)
+
+	l = getHistory("?offsetKey=3")
+	assert.Equal(2, len(l.Items))
+	assert.Equal(ts.URL+"/check/2", l.Items[0].JsonLDId)
+
+	res, err := http.Get(ts.URL + "/domain/1/checks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(fmt.Sprintf(`<%s/domain/1/checks?offsetKey=1>; rel="next"`, ts.URL), res.Header.Get("Link"))
+	res, err = http.Get(ts.URL + "/domain/1/checks?offsetKey=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("", res.Header.Get("Link"))
+
+	tomorrow := url.QueryEscape(time.Now().Add(24 * time.Hour).Format(time.RFC3339))
+	assert.Equal(0, getHistory("?since="+tomorrow).Total)
+	assert.Equal(3, getHistory("?until="+tomorrow).Total)
+
+	res, err = http.Get(ts.URL + "/domain/1/checks?since=yesterday")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(http.StatusBadRequest, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+}
+
+func TestThatItKeepsListingFiltersInTheNextLink(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl := SetupDomainTest(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.ListingHandler(w, r, nil)
+	}))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/domain?valid=false&namePrefix=ac&sort=-name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	var l DomainListModel
+	assert.Nil(json.Unmarshal(b, &l))
+	assert.Equal(1, l.Total)
+	assert.Equal("acme.hiv", l.Items[0].Name)
+	assert.Equal(`<`+ts.URL+`/domain?namePrefix=ac&offsetKey=2%3Aacme.hiv&sort=-name&valid=false>; rel="next"`, res.Header.Get("Link"))
+
+	for _, query := range []string{"valid=maybe", "sort=owner", "failureReason=unknown", "createdAfter=yesterday"} {
+		res, err = http.Get(ts.URL + "/domain?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(http.StatusBadRequest, res.StatusCode, query)
+		assert.Equal("application/problem+json", res.Header.Get("Content-Type"), query)
+	}
+}
+
+func TestThatItUpsertsDomainsByName(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl := SetupDomainTest(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.ByNameHandler(w, r, regexp.MustCompile("^/domain/by-name/([^/]+)$").FindStringSubmatch(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	doRequest := func(method string, name string, body string) (res *http.Response) {
+		req, _ := http.NewRequest(method, ts.URL+"/domain/by-name/"+name, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	res := doRequest("GET", "example.hiv", "")
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	var m DomainModel
+	assert.Nil(json.Unmarshal(b, &m))
+	assert.Equal(ts.URL+"/domain/1", m.JsonLDId)
+
+	// Created if missing
+	res = doRequest("PUT", "new.hiv", `{"name":"new.hiv","registrar":"ACME"}`)
+	assert.Equal(http.StatusCreated, res.StatusCode)
+	assert.Equal(ts.URL+"/domain/3", res.Header.Get("Location"))
+
+	// Updated otherwise
+	res = doRequest("PUT", "new.hiv", `{"name":"new.hiv","registrar":"Other","tags":["synced"]}`)
+	assert.Equal(http.StatusOK, res.StatusCode)
+	b, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	m = DomainModel{}
+	assert.Nil(json.Unmarshal(b, &m))
+	assert.Equal("Other", m.Registrar)
+	assert.Equal([]string{"synced"}, m.Tags)
+	domain, _ := cntrl.domainRepo.FindByName("new.hiv")
+	assert.Equal(int64(3), domain.Id)
+
+	// Disabled domains stay disabled unless enabled is set
+	domain.Disabled = true
+	assert.Nil(cntrl.domainRepo.Persist(domain))
+	res = doRequest("PUT", "new.hiv", `{"name":"new.hiv","registrar":"Other"}`)
+	assert.Equal(http.StatusOK, res.StatusCode)
+	domain, _ = cntrl.domainRepo.FindByName("new.hiv")
+	assert.True(domain.Disabled)
+
+	res = doRequest("PUT", "new.hiv", `{"name":"other.hiv"}`)
+	assert.Equal(http.StatusBadRequest, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+
+	// Concurrent upserts create the domain once
+	statusCodes := make(chan int, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			req, _ := http.NewRequest("PUT", ts.URL+"/domain/by-name/concurrent.hiv", bytes.NewBufferString(`{"name":"concurrent.hiv","tags":["synced"]}`))
+			req.Header.Set("Content-Type", "application/json")
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				statusCodes <- 0
+				return
+			}
+			res.Body.Close()
+			statusCodes <- res.StatusCode
+		}()
+	}
+	created := 0
+	for i := 0; i < 5; i++ {
+		statusCode := <-statusCodes
+		if statusCode == http.StatusCreated {
+			created++
+		} else {
+			assert.Equal(http.StatusOK, statusCode)
+		}
+	}
+	assert.Equal(1, created)
+
+	res = doRequest("DELETE", "new.hiv", "")
+	assert.Equal(http.StatusNoContent, res.StatusCode)
+	res = doRequest("GET", "new.hiv", "")
+	assert.Equal(http.StatusNotFound, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+}
+
+func TestThatItPatchesDomains(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl := SetupDomainTest(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.ItemHandler(w, r, regexp.MustCompile("^/domain/([0-9]+)$").FindStringSubmatch(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	doPatch := func(body string) (res *http.Response, m *DomainModel) {
+		req, _ := http.NewRequest("PATCH", ts.URL+"/domain/2", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		m = new(DomainModel)
+		json.Unmarshal(b, m)
+		return
+	}
+
+	res, m := doPatch(`{"name":"acme2.hiv","registrar":"ACME","notes":"Typo","tags":["partner"],"enabled":false}`)
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.Equal("acme2.hiv", m.Name)
+	assert.Equal("ACME", m.Registrar)
+	assert.Equal([]string{"partner"}, m.Tags)
+	assert.False(*m.Enabled)
+	domain, _ := cntrl.domainRepo.FindById(2)
+	assert.Equal("acme2.hiv", domain.Name)
+	assert.True(domain.Disabled)
+
+	// Missing fields are kept, null resets them
+	_, m = doPatch(`{"notes":null,"enabled":true}`)
+	assert.Equal("ACME", m.Registrar)
+	assert.Equal("", m.Notes)
+	assert.Equal([]string{"partner"}, m.Tags)
+	assert.True(*m.Enabled)
+	doPatch(`{"enabled":false}`)
+	_, m = doPatch(`{"registrar":"Other"}`)
+	assert.False(*m.Enabled)
+	_, m = doPatch(`{"enabled":null}`)
+	assert.True(*m.Enabled)
+
+	for _, body := range []string{`{"name":null}`, `{"contacts":["not an address"]}`, `{"valid":true}`, `[]`} {
+		res, _ = doPatch(body)
+		assert.Equal(http.StatusBadRequest, res.StatusCode, body)
+		assert.Equal("application/problem+json", res.Header.Get("Content-Type"), body)
+	}
+
+	// Not changed if the response can not be negotiated
+	req, _ := http.NewRequest("PATCH", ts.URL+"/domain/2", bytes.NewBufferString(`{"registrar":"Unacceptable"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("Accept", "image/png")
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(err)
+	assert.Equal(http.StatusNotAcceptable, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+	domain, _ = cntrl.domainRepo.FindById(2)
+	assert.Equal("Other", domain.Registrar)
+}
@@ -1,22 +1,22 @@
 package hivdomainstatus
 
-import (
-	"encoding/json"
-	"net/http"
-)
+import "net/http"
 
 type EntryPoint struct {
 	JsonLDContext string            `json:"@context"`
 	Domains       *JsonLDTypedModel `json:"domains"`
 	Checks        *JsonLDTypedModel `json:"checks"`
+	Webhooks      *JsonLDTypedModel `json:"webhooks"`
+	Tags          *JsonLDTypedModel `json:"tags"`
+	Stats         *JsonLDTypedModel `json:"stats"`
 }
 
 type EntryPointController struct {
 }
 
 func (c *EntryPointController) EntryPointHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
-	if r.Method != "GET" {
-		w.WriteHeader(400)
+	mediaType, acceptable := negotiateResponse(w, r, itemMediaTypes)
+	if !acceptable {
 		return
 	}
 	entryPoint := new(EntryPoint)
@@ -29,7 +29,16 @@ func (c *EntryPointController) EntryPointHandler(w http.ResponseWriter, r *http.
 	entryPoint.Checks.JsonLDContext = "http://jsonld.click4life.hiv/List"
 	entryPoint.Checks.JsonLDType = "http://jsonld.click4life.hiv/DomainCheck"
 	entryPoint.Checks.JsonLDId = "/check"
-	w.Header().Add("Content-Type", "application/json")
-	encoder := json.NewEncoder(w)
-	encoder.Encode(entryPoint)
+	entryPoint.Webhooks = new(JsonLDTypedModel)
+	entryPoint.Webhooks.JsonLDContext = "http://jsonld.click4life.hiv/List"
+	entryPoint.Webhooks.JsonLDType = "http://jsonld.click4life.hiv/Webhook"
+	entryPoint.Webhooks.JsonLDId = "/webhook"
+	entryPoint.Tags = new(JsonLDTypedModel)
+	entryPoint.Tags.JsonLDContext = "http://jsonld.click4life.hiv/List"
+	entryPoint.Tags.JsonLDType = "http://jsonld.click4life.hiv/Tag"
+	entryPoint.Tags.JsonLDId = "/tag"
+	entryPoint.Stats = new(JsonLDTypedModel)
+	entryPoint.Stats.JsonLDType = "http://jsonld.click4life.hiv/Stats"
+	entryPoint.Stats.JsonLDId = "/stats"
+	writeModel(w, mediaType, entryPoint, nil)
 }
@@ -0,0 +1,131 @@
+package hivdomainstatus
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats
+const (
+	EXPORT_FORMAT_CSV    = "csv"
+	EXPORT_FORMAT_NDJSON = "ndjson"
+)
+
+var exportContentTypes = map[string]string{
+	EXPORT_FORMAT_CSV:    MEDIA_TYPE_CSV + "; charset=utf-8",
+	EXPORT_FORMAT_NDJSON: MEDIA_TYPE_NDJSON,
+}
+
+// Media types of the export formats, the first one is the default
+var exportMediaTypes = []string{MEDIA_TYPE_CSV, MEDIA_TYPE_NDJSON}
+
+var exportFormats = map[string]string{
+	MEDIA_TYPE_CSV:    EXPORT_FORMAT_CSV,
+	MEDIA_TYPE_NDJSON: EXPORT_FORMAT_NDJSON,
+}
+
+// Columns of the CSV export, named like the NDJSON fields
+var exportCsvHeader = []string{
+	"id", "name", "valid", "enabled", "ownerName", "contacts", "registrar", "notes", "externalRef", "created",
+	"checkId", "checked", "dnsOk", "url", "statusCode", "scriptPresent", "iframePresent", "iframeTarget", "iframeTargetOk", "failureReason", "inMaintenance",
+}
+
+type ExportController struct {
+	domainRepo DomainRepositoryInterface
+}
+
+// Streams all domains matching the listing filters with their latest check as CSV or NDJSON.
+// The format is taken from the format parameter or the Accept header, CSV is the default.
+func (c *ExportController) DomainsHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
+	formErr := r.ParseForm()
+	if formErr != nil {
+		HttpProblem(w, r, http.StatusBadRequest, formErr.Error())
+		return
+	}
+	format := r.Form.Get("format")
+	if len(format) == 0 {
+		mediaType, acceptable := negotiateResponse(w, r, exportMediaTypes)
+		if !acceptable {
+			return
+		}
+		format = exportFormats[mediaType]
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		HttpProblem(w, r, http.StatusBadRequest, "Invalid format: "+format)
+		return
+	}
+	filter := new(DomainFilter)
+	filterErr := parseDomainFilter(r.Form, filter)
+	if filterErr != nil {
+		HttpError(w, r, filterErr)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	csvWriter := csv.NewWriter(w)
+	encoder := json.NewEncoder(w)
+	// The headers are sent with the first domain, so a failing query can still be answered with a problem
+	started := false
+	start := func() {
+		started = true
+		w.Header().Add("Content-Type", contentType)
+		w.Header().Add("Content-Disposition", fmt.Sprintf(`attachment; filename="domains.%s"`, format))
+		if format == EXPORT_FORMAT_CSV {
+			csvWriter.Write(exportCsvHeader)
+		}
+	}
+	count := 0
+	err := c.domainRepo.Export(filter, func(domain *Domain, check *DomainCheck) (err error) {
+		if !started {
+			start()
+		}
+		m := transformExportEntity(domain, check)
+		if format == EXPORT_FORMAT_CSV {
+			err = csvWriter.Write(escapeCsvFormulas(exportCsvRecord(m)))
+		} else {
+			err = encoder.Encode(m)
+		}
+		count++
+		if count%100 == 0 && flusher != nil {
+			csvWriter.Flush()
+			flusher.Flush()
+		}
+		return
+	})
+	if err != nil && !started {
+		HttpError(w, r, err)
+		return
+	}
+	if !started {
+		start()
+	}
+	csvWriter.Flush()
+	if err != nil {
+		// The status has been sent already, the export ends early
+		log.Printf("ERROR: Export of domains failed: %s\n", err.Error())
+	}
+}
+
+func exportCsvRecord(m *DomainExportModel) []string {
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	checkId := ""
+	if m.CheckId > 0 {
+		checkId = strconv.FormatInt(m.CheckId, 10)
+	}
+	return []string{
+		strconv.FormatInt(m.Id, 10), m.Name, strconv.FormatBool(m.Valid), strconv.FormatBool(m.Enabled), m.OwnerName, strings.Join(m.Contacts, ";"), m.Registrar, m.Notes, m.ExternalRef, formatTime(m.Created),
+		checkId, formatTime(m.Checked), strconv.FormatBool(m.DnsOK), m.URL, strconv.Itoa(m.StatusCode), strconv.FormatBool(m.ScriptPresent), strconv.FormatBool(m.IframePresent), m.IframeTarget, strconv.FormatBool(m.IframeTargetOk), m.FailureReason, strconv.FormatBool(m.InMaintenance),
+	}
+}
@@ -0,0 +1,190 @@
+package hivdomainstatus
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func SetupExportTest(t *testing.T) (cntrl *ExportController) {
+	c, configErr := NewConfig()
+	if configErr != nil {
+		t.Fatal(configErr)
+	}
+	db, _ := sql.Open("postgres", c.DSN())
+	db.Exec("TRUNCATE domain RESTART IDENTITY")
+	db.Exec("TRUNCATE domain_check RESTART IDENTITY")
+	domainRepo := NewDomainRepository(db)
+	domainCheckRepo := NewDomainCheckRepository(db)
+	for _, name := range []string{"example.hiv", "acme.hiv", "new.hiv"} {
+		d := new(Domain)
+		d.Name = name
+		d.Valid = name == "example.hiv"
+		if name == "new.hiv" {
+			d.Notes = "=1+1"
+		}
+		domainRepo.Persist(d)
+	}
+	for _, valid := range []bool{false, true} {
+		check := new(DomainCheck)
+		check.Domain = "example.hiv"
+		check.Valid = valid
+		check.DnsOK = true
+		domainCheckRepo.Persist(check)
+	}
+	check := new(DomainCheck)
+	check.Domain = "acme.hiv"
+	domainCheckRepo.Persist(check)
+	cntrl = new(ExportController)
+	cntrl.domainRepo = domainRepo
+	return
+}
+
+// Exports the given domains without checks or fails
+type stubExportRepository struct {
+	DomainRepositoryInterface
+	domains []*Domain
+	err     error
+}
+
+func (repo *stubExportRepository) Export(filter *DomainFilter, fn func(domain *Domain, check *DomainCheck) error) (err error) {
+	if repo.err != nil {
+		err = repo.err
+		return
+	}
+	for _, domain := range repo.domains {
+		err = fn(domain, nil)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func TestThatItExportsDomainsAsCsv(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl := SetupExportTest(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.DomainsHandler(w, r, nil)
+	}))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/export/domains?sort=name")
+	assert.Nil(err)
+	defer res.Body.Close()
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.Equal("text/csv; charset=utf-8", res.Header.Get("Content-Type"))
+	records, csvErr := csv.NewReader(res.Body).ReadAll()
+	assert.Nil(csvErr)
+	assert.Equal(4, len(records))
+	assert.Equal(exportCsvHeader, records[0])
+	assert.Equal("acme.hiv", records[1][1])
+	assert.Equal("3", records[1][10])
+	assert.Equal("dns", records[1][19])
+	assert.Equal("example.hiv", records[2][1])
+	assert.Equal("2", records[2][10])
+	assert.Equal("new.hiv", records[3][1])
+	assert.Equal("", records[3][10])
+	// Not run as formula by spreadsheets
+	assert.Equal("'=1+1", records[3][7])
+}
+
+func TestThatItExportsFilteredDomainsAsNdjson(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl := SetupExportTest(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.DomainsHandler(w, r, nil)
+	}))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL+"/export/domains?valid=false", nil)
+	req.Header.Add("Accept", "application/x-ndjson")
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(err)
+	defer res.Body.Close()
+	assert.Equal("application/x-ndjson", res.Header.Get("Content-Type"))
+	items := make([]*DomainExportModel, 0)
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		m := new(DomainExportModel)
+		assert.Nil(json.Unmarshal(scanner.Bytes(), m))
+		items = append(items, m)
+	}
+	assert.Equal(2, len(items))
+	assert.Equal("acme.hiv", items[0].Name)
+	assert.Equal(FAILURE_REASON_DNS, items[0].FailureReason)
+	assert.Equal("new.hiv", items[1].Name)
+	assert.Nil(items[1].Checked)
+
+	res, err = http.Get(ts.URL + "/export/domains?format=xml")
+	assert.Nil(err)
+	res.Body.Close()
+	assert.Equal(http.StatusBadRequest, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+}
+
+func TestThatItNegotiatesTheExportFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl := new(ExportController)
+	cntrl.domainRepo = &stubExportRepository{domains: []*Domain{&Domain{Id: 1, Name: "example.hiv"}}}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.DomainsHandler(w, r, nil)
+	}))
+	defer ts.Close()
+
+	export := func(accept string) (res *http.Response) {
+		req, _ := http.NewRequest("GET", ts.URL+"/export/domains", nil)
+		req.Header.Set("Accept", accept)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return
+	}
+
+	assert.Equal("text/csv; charset=utf-8", export("").Header.Get("Content-Type"))
+	assert.Equal("application/x-ndjson", export("application/x-ndjson").Header.Get("Content-Type"))
+	assert.Equal("text/csv; charset=utf-8", export("application/x-ndjson;q=0, text/csv").Header.Get("Content-Type"))
+	res := export("application/xml")
+	assert.Equal(http.StatusNotAcceptable, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+}
+
+func TestThatItReportsAFailedExport(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl := new(ExportController)
+	cntrl.domainRepo = &stubExportRepository{err: errors.New("connection refused")}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.DomainsHandler(w, r, nil)
+	}))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/export/domains")
+	assert.Nil(err)
+	res.Body.Close()
+	assert.Equal(http.StatusInternalServerError, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+	assert.Equal("", res.Header.Get("Content-Disposition"))
+
+	// An empty export still has a header
+	cntrl.domainRepo = new(stubExportRepository)
+	res, err = http.Get(ts.URL + "/export/domains")
+	assert.Nil(err)
+	defer res.Body.Close()
+	assert.Equal(http.StatusOK, res.StatusCode)
+	records, csvErr := csv.NewReader(res.Body).ReadAll()
+	assert.Nil(csvErr)
+	assert.Equal([][]string{exportCsvHeader}, records)
+}
@@ -0,0 +1,228 @@
+package hivdomainstatus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Upper limits of an import request
+const (
+	IMPORT_MAX_BYTES = 10 << 20
+	IMPORT_MAX_LINES = 10000
+)
+
+// Counts the bytes read, so a body exceeding IMPORT_MAX_BYTES can be told apart from a malformed one
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	c.n += int64(n)
+	return
+}
+
+type ImportController struct {
+	importer *Importer
+}
+
+// Creates the domains of a JSON array, NDJSON or CSV body and reports the outcome of every line.
+// With ?mode=atomic nothing is stored if a line is rejected.
+func (c *ImportController) BulkHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
+	mode := r.URL.Query().Get("mode")
+	if mode != "" && mode != "atomic" && mode != "best-effort" {
+		HttpProblem(w, r, http.StatusBadRequest, "Invalid mode: "+mode)
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		HttpProblem(w, r, http.StatusBadRequest, "Invalid content type: "+r.Header.Get("Content-Type"))
+		return
+	}
+	// One byte more than allowed is read to detect too large bodies
+	limited := http.MaxBytesReader(w, r.Body, IMPORT_MAX_BYTES+1)
+	defer limited.Close()
+	body := &countingReader{r: limited}
+	var lines []*ImportLine
+	switch mediaType {
+	case MEDIA_TYPE_JSON:
+		lines, err = parseJsonImport(body)
+	case MEDIA_TYPE_NDJSON:
+		lines, err = parseNdjsonImport(body)
+	case MEDIA_TYPE_CSV:
+		lines, err = parseCsvImport(body)
+	default:
+		HttpProblem(w, r, http.StatusUnsupportedMediaType, "Expected application/json, application/x-ndjson or text/csv got "+mediaType)
+		return
+	}
+	if body.n > IMPORT_MAX_BYTES {
+		HttpProblem(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("Not more than %d bytes allowed", IMPORT_MAX_BYTES))
+		return
+	}
+	if err != nil {
+		HttpProblem(w, r, http.StatusBadRequest, "Failed to parse request: "+err.Error())
+		return
+	}
+	if len(lines) > IMPORT_MAX_LINES {
+		HttpProblem(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("Not more than %d domains allowed", IMPORT_MAX_LINES))
+		return
+	}
+
+	results, committed, err := c.importer.Import(lines, mode == "atomic")
+	if err != nil {
+		HttpError(w, r, err)
+		return
+	}
+	report := new(DomainImportModel)
+	report.JsonLDContext = "http://jsonld.click4life.hiv/DomainImport"
+	report.Committed = committed
+	report.Items = make([]*DomainImportLineModel, len(results))
+	for i, result := range results {
+		item := new(DomainImportLineModel)
+		item.Line = result.Line
+		item.Name = result.Name
+		item.State = result.State
+		item.Reason = result.Reason
+		switch result.State {
+		case IMPORT_STATE_CREATED:
+			report.Created++
+		case IMPORT_STATE_EXISTS:
+			report.Existed++
+		case IMPORT_STATE_REJECTED:
+			report.Rejected++
+		}
+		if result.DomainId > 0 && committed {
+			item.Domain = fmt.Sprintf("%s/domain/%d", getHttpHost(r), result.DomainId)
+		}
+		report.Items[i] = item
+	}
+	w.Header().Add("Content-Type", "application/json")
+	if !committed {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.Encode(report)
+}
+
+// Parses an element of a JSON import, either a domain object or just its name
+func parseImportItem(line int, raw []byte) (item *ImportLine) {
+	item = new(ImportLine)
+	item.Line = line
+	item.Domain = new(DomainModel)
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '"' {
+		item.Err = json.Unmarshal(raw, &item.Domain.Name)
+	} else {
+		item.Err = json.Unmarshal(raw, item.Domain)
+	}
+	if item.Err != nil {
+		item.Err = fmt.Errorf("Invalid JSON: %s", item.Err.Error())
+	}
+	return
+}
+
+// Parses a JSON array, line is the position in the array
+func parseJsonImport(r io.Reader) (lines []*ImportLine, err error) {
+	var items []json.RawMessage
+	err = json.NewDecoder(r).Decode(&items)
+	if err != nil {
+		return
+	}
+	lines = make([]*ImportLine, len(items))
+	for i, raw := range items {
+		lines[i] = parseImportItem(i+1, raw)
+	}
+	return
+}
+
+// Parses one JSON value per line, empty lines are skipped
+func parseNdjsonImport(r io.Reader) (lines []*ImportLine, err error) {
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		lines = append(lines, parseImportItem(n, scanner.Bytes()))
+	}
+	err = scanner.Err()
+	return
+}
+
+// Parses CSV with a header row naming the columns, e.g. name,ownerName,contacts,tags.
+// Lists (contacts, tags) are separated by semicolons.
+func parseCsvImport(r io.Reader) (lines []*ImportLine, err error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		err = fmt.Errorf("Missing header: %s", err.Error())
+		return
+	}
+	columns := make(map[string]int)
+	for i, column := range header {
+		columns[strings.TrimSpace(column)] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		err = fmt.Errorf("Missing column: name")
+		return
+	}
+	n := 1
+	for {
+		record, readErr := reader.Read()
+		if readErr == io.EOF {
+			break
+		}
+		n++
+		item := new(ImportLine)
+		item.Line = n
+		if readErr != nil {
+			if _, ok := readErr.(*csv.ParseError); !ok {
+				err = readErr
+				return
+			}
+			item.Err = readErr
+			lines = append(lines, item)
+			continue
+		}
+		if len(record) != len(header) {
+			item.Err = fmt.Errorf("Expected %d columns got %d", len(header), len(record))
+			lines = append(lines, item)
+			continue
+		}
+		get := func(column string) string {
+			if i, ok := columns[column]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		list := func(column string) (values []string) {
+			values = []string{}
+			for _, value := range strings.Split(get(column), ";") {
+				if value = strings.TrimSpace(value); len(value) > 0 {
+					values = append(values, value)
+				}
+			}
+			return
+		}
+		item.Domain = new(DomainModel)
+		item.Domain.Name = get("name")
+		item.Domain.OwnerName = get("ownerName")
+		item.Domain.Contacts = list("contacts")
+		item.Domain.Registrar = get("registrar")
+		item.Domain.Notes = get("notes")
+		item.Domain.ExternalRef = get("externalRef")
+		item.Domain.Tags = list("tags")
+		lines = append(lines, item)
+	}
+	return
+}
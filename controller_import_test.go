@@ -0,0 +1,169 @@
+package hivdomainstatus
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func SetupImportTest(t *testing.T) (cntrl *ImportController, domainRepo *DomainRepository, tagRepo *TagRepository) {
+	c, configErr := NewConfig()
+	if configErr != nil {
+		t.Fatal(configErr)
+	}
+	db, _ := sql.Open("postgres", c.DSN())
+	db.Exec("TRUNCATE domain RESTART IDENTITY")
+	db.Exec("TRUNCATE tag RESTART IDENTITY CASCADE")
+	db.Exec("TRUNCATE domain_tag")
+	domainRepo = NewDomainRepository(db)
+	tagRepo = NewTagRepository(db)
+	cntrl = new(ImportController)
+	cntrl.importer = NewImporter(db, domainRepo, tagRepo)
+
+	d := new(Domain)
+	d.Name = "example.hiv"
+	domainRepo.Persist(d)
+	return
+}
+
+func postImport(t *testing.T, url string, contentType string, body string) (res *http.Response, report *DomainImportModel) {
+	res, err := http.Post(url, contentType, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	report = new(DomainImportModel)
+	json.Unmarshal(b, report)
+	return
+}
+
+func TestThatItParsesCsvImports(t *testing.T) {
+	assert := assert.New(t)
+
+	lines, err := parseCsvImport(bytes.NewBufferString("name,contacts,tags\nexample.hiv,a@example.com; b@example.com,partner\nacme.hiv\n"))
+	assert.Nil(err)
+	assert.Equal(2, len(lines))
+	assert.Equal(2, lines[0].Line)
+	assert.Equal("example.hiv", lines[0].Domain.Name)
+	assert.Equal([]string{"a@example.com", "b@example.com"}, lines[0].Domain.Contacts)
+	assert.Equal([]string{"partner"}, lines[0].Domain.Tags)
+	assert.NotNil(lines[1].Err)
+
+	_, err = parseCsvImport(bytes.NewBufferString("domain\nexample.hiv\n"))
+	assert.NotNil(err)
+}
+
+func TestThatItRejectsInvalidDomainNames(t *testing.T) {
+	assert := assert.New(t)
+
+	names := []string{"foo bar", "http://x.hiv", "a..hiv", "hiv", ".hiv", "example.hiv.", "-example.hiv", "example-.hiv", " example.hiv", "exa_mple.hiv"}
+	lines := make([]*ImportLine, len(names))
+	for i, name := range names {
+		lines[i] = &ImportLine{Line: i + 1, Domain: &DomainModel{Name: name}}
+	}
+	results, committed, err := NewImporter(nil, nil, nil).Import(lines, false)
+	assert.Nil(err)
+	assert.True(committed)
+	for i, result := range results {
+		assert.Equal(IMPORT_STATE_REJECTED, result.State, names[i])
+		assert.Equal("Invalid name: "+names[i], result.Reason)
+	}
+
+	for _, name := range []string{"example.hiv", "Example.HIV", "xn--mnchen-3ya.hiv", "www.my-domain.hiv"} {
+		assert.Nil(validateDomain(&DomainModel{Name: name}), name)
+	}
+}
+
+func TestThatItLimitsImports(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl := new(ImportController)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.BulkHandler(w, r, nil)
+	}))
+	defer ts.Close()
+
+	res, _ := postImport(t, ts.URL+"/domain/bulk", "text/plain", "example.hiv")
+	assert.Equal(http.StatusUnsupportedMediaType, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+
+	res, _ = postImport(t, ts.URL+"/domain/bulk", "application/x-ndjson", strings.Repeat("\"example.hiv\"\n", IMPORT_MAX_LINES+1))
+	assert.Equal(http.StatusRequestEntityTooLarge, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+
+	res, _ = postImport(t, ts.URL+"/domain/bulk", "application/json", "["+strings.Repeat(" ", IMPORT_MAX_BYTES)+"]")
+	assert.Equal(http.StatusRequestEntityTooLarge, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+}
+
+func TestThatItImportsDomainsBestEffort(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl, domainRepo, tagRepo := SetupImportTest(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.BulkHandler(w, r, nil)
+	}))
+	defer ts.Close()
+
+	res, report := postImport(t, ts.URL+"/domain/bulk", "application/json", `["acme.hiv", {"name":"example.hiv"}, {"name":"tagged.hiv","tags":["partner"]}, {"name":""}]`)
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.True(report.Committed)
+	assert.Equal(2, report.Created)
+	assert.Equal(1, report.Existed)
+	assert.Equal(1, report.Rejected)
+	assert.Equal(IMPORT_STATE_CREATED, report.Items[0].State)
+	assert.Equal(ts.URL+"/domain/2", report.Items[0].Domain)
+	assert.Equal(IMPORT_STATE_EXISTS, report.Items[1].State)
+	assert.Equal(ts.URL+"/domain/1", report.Items[1].Domain)
+	assert.Equal(IMPORT_STATE_REJECTED, report.Items[3].State)
+	assert.Equal(4, report.Items[3].Line)
+	assert.NotEqual("", report.Items[3].Reason)
+	tags, _ := tagRepo.FindByDomain(3)
+	assert.Equal([]string{"partner"}, tags)
+
+	_, report = postImport(t, ts.URL+"/domain/bulk", "application/x-ndjson", "{\"name\":\"ndjson.hiv\"}\n\n\"acme.hiv\"\n{broken\n")
+	assert.Equal(1, report.Created)
+	assert.Equal(1, report.Existed)
+	assert.Equal(1, report.Rejected)
+	assert.Equal(4, report.Items[2].Line)
+
+	_, report = postImport(t, ts.URL+"/domain/bulk", "text/csv; charset=utf-8", "name,registrar\ncsv.hiv,ACME\n")
+	assert.Equal(1, report.Created)
+	d, err := domainRepo.FindByName("csv.hiv")
+	assert.Nil(err)
+	assert.Equal("ACME", d.Registrar)
+}
+
+func TestThatItImportsDomainsAtomically(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl, domainRepo, _ := SetupImportTest(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.BulkHandler(w, r, nil)
+	}))
+	defer ts.Close()
+
+	res, report := postImport(t, ts.URL+"/domain/bulk?mode=atomic", "application/json", `["acme.hiv", "acme.hiv", {"name":"bad.hiv","contacts":["nope"]}]`)
+	assert.Equal(http.StatusBadRequest, res.StatusCode)
+	assert.False(report.Committed)
+	assert.Equal(IMPORT_STATE_CREATED, report.Items[0].State)
+	assert.Equal(IMPORT_STATE_EXISTS, report.Items[1].State)
+	assert.Equal(IMPORT_STATE_REJECTED, report.Items[2].State)
+	_, err := domainRepo.FindByName("acme.hiv")
+	assert.Equal(sql.ErrNoRows, err)
+
+	res, report = postImport(t, ts.URL+"/domain/bulk?mode=atomic", "application/json", `["acme.hiv", "other.hiv"]`)
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.True(report.Committed)
+	assert.Equal(2, report.Created)
+	_, err = domainRepo.FindByName("acme.hiv")
+	assert.Nil(err)
+}
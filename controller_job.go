@@ -0,0 +1,28 @@
+package hivdomainstatus
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+type CheckJobController struct {
+	jobRepo CheckJobRepositoryInterface
+}
+
+// Shows the state of a check job, once done it links to the resulting check
+func (c *CheckJobController) ItemHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
+	id, err := strconv.ParseInt(routeParams[1], 0, 64)
+	if err != nil {
+		HttpProblem(w, r, http.StatusBadRequest, "Invalid id: "+routeParams[1])
+		return
+	}
+	job, findErr := c.jobRepo.FindById(id)
+	if findErr != nil {
+		HttpError(w, r, notFound(findErr, "Job not found: "+routeParams[1]))
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.Encode(transformCheckJobEntity(job, getHttpHost(r)+"/job/%d", getHttpHost(r)+"/check/%d"))
+}
@@ -0,0 +1,59 @@
+package hivdomainstatus
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Days of the validity history by default and at most
+const (
+	STATS_DEFAULT_DAYS = 30
+	STATS_MAX_DAYS     = 366
+)
+
+type StatsController struct {
+	domainRepo DomainRepositoryInterface
+}
+
+// Shows the numbers of all domains and the daily share of valid domains of the last days.
+// Accepts the filters of the domain listing.
+func (c *StatsController) StatsHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
+	mediaType, acceptable := negotiateResponse(w, r, itemMediaTypes)
+	if !acceptable {
+		return
+	}
+	formErr := r.ParseForm()
+	if formErr != nil {
+		HttpProblem(w, r, http.StatusBadRequest, formErr.Error())
+		return
+	}
+	days := STATS_DEFAULT_DAYS
+	if len(r.Form.Get("days")) > 0 {
+		var err error
+		days, err = strconv.Atoi(r.Form.Get("days"))
+		if err != nil || days < 1 || days > STATS_MAX_DAYS {
+			HttpProblem(w, r, http.StatusBadRequest, "Invalid days: "+r.Form.Get("days"))
+			return
+		}
+	}
+	filter := new(DomainFilter)
+	filterErr := parseDomainFilter(r.Form, filter)
+	if filterErr != nil {
+		HttpError(w, r, filterErr)
+		return
+	}
+
+	stats, err := c.domainRepo.Aggregate(filter)
+	if err != nil {
+		HttpError(w, r, err)
+		return
+	}
+	until := time.Now()
+	history, err := c.domainRepo.ValidityHistory(filter, until.AddDate(0, 0, 1-days), until)
+	if err != nil {
+		HttpError(w, r, err)
+		return
+	}
+	writeModel(w, mediaType, transformStats(stats, history, getHttpHost(r)+"/stats"), nil)
+}
@@ -0,0 +1,112 @@
+package hivdomainstatus
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func SetupStatsTest(t *testing.T) (cntrl *StatsController, db *sql.DB) {
+	c, configErr := NewConfig()
+	if configErr != nil {
+		t.Fatal(configErr)
+	}
+	db, _ = sql.Open("postgres", c.DSN())
+	db.Exec("TRUNCATE domain RESTART IDENTITY")
+	db.Exec("TRUNCATE domain_check RESTART IDENTITY")
+	domainRepo := NewDomainRepository(db)
+	domainCheckRepo := NewDomainCheckRepository(db)
+	for _, name := range []string{"example.hiv", "acme.hiv", "new.hiv", "disabled.hiv"} {
+		d := new(Domain)
+		d.Name = name
+		d.Valid = name == "example.hiv"
+		d.Disabled = name == "disabled.hiv"
+		domainRepo.Persist(d)
+	}
+	// example.hiv failed yesterday and recovered today
+	check := new(DomainCheck)
+	check.Domain = "example.hiv"
+	check.DnsOK = true
+	check.StatusCode = 500
+	domainCheckRepo.Persist(check)
+	db.Exec("UPDATE domain_check SET created = created - interval '1 day' WHERE id = 1")
+	check = new(DomainCheck)
+	check.Domain = "example.hiv"
+	check.Valid = true
+	check.DnsOK = true
+	check.URL = "https://example.hiv/"
+	check.StatusCode = 200
+	check.ScriptPresent = true
+	domainCheckRepo.Persist(check)
+	check = new(DomainCheck)
+	check.Domain = "acme.hiv"
+	check.URL = "http://acme.hiv/"
+	domainCheckRepo.Persist(check)
+
+	cntrl = new(StatsController)
+	cntrl.domainRepo = domainRepo
+	return
+}
+
+func TestThatItAggregatesDomainStats(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl, _ := SetupStatsTest(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.StatsHandler(w, r, nil)
+	}))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/stats?days=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal("application/ld+json", res.Header.Get("Content-Type"))
+
+	m := new(StatsModel)
+	assert.Nil(json.Unmarshal(b, m))
+	assert.Equal(4, m.Domains)
+	assert.Equal(1, m.Valid)
+	assert.Equal(3, m.Invalid)
+	assert.Equal(1, m.Disabled)
+	assert.Equal(2, m.NeverChecked)
+	assert.Equal(1, m.DnsOK)
+	assert.Equal(1, m.ScriptPresent)
+	assert.Equal(1, m.HttpsOK)
+	assert.Equal(1, m.FailureReasons[FAILURE_REASON_DNS])
+	assert.Equal(0, m.FailureReasons[FAILURE_REASON_HTTP])
+
+	assert.Equal(2, len(m.Daily))
+	assert.Equal(time.Now().AddDate(0, 0, -1).Format("2006-01-02"), m.Daily[0].Day)
+	assert.Equal(1, m.Daily[0].Checked)
+	assert.Equal(0.0, *m.Daily[0].ValidPercent)
+	assert.Equal(2, m.Daily[1].Checked)
+	assert.Equal(50.0, *m.Daily[1].ValidPercent)
+
+	res, err = http.Get(ts.URL + "/stats?days=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	assert.Equal(http.StatusBadRequest, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+}
+
+func TestThatItCalculatesTheValidPercentage(t *testing.T) {
+	assert := assert.New(t)
+
+	day := time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)
+	stats := new(DomainStats)
+	m := transformStats(stats, []*DailyValidity{&DailyValidity{&day, 3, 2}, &DailyValidity{&day, 0, 0}}, "/stats")
+	assert.Equal("2016-05-01", m.Daily[0].Day)
+	assert.Equal(66.7, *m.Daily[0].ValidPercent)
+	assert.Nil(m.Daily[1].ValidPercent)
+}
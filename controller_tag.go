@@ -0,0 +1,33 @@
+package hivdomainstatus
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type TagController struct {
+	tagRepo TagRepositoryInterface
+}
+
+// Lists all tags with the number of tagged domains
+func (c *TagController) ListingHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
+	items, findErr := c.tagRepo.FindAll()
+	if findErr != nil {
+		HttpError(w, r, findErr)
+		return
+	}
+
+	list := new(TagListModel)
+	list.Total = len(items)
+	list.JsonLDContext = "http://jsonld.click4life.hiv/List"
+	list.JsonLDType = "http://jsonld.click4life.hiv/Tag"
+	list.JsonLDId = getHttpHost(r) + "/tag"
+	list.Items = make([]*TagModel, len(items))
+	for i, item := range items {
+		list.Items[i] = transformTagEntity(item, getHttpHost(r)+"/domain?tag=%s")
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.Encode(list)
+}
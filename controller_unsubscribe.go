@@ -0,0 +1,58 @@
+package hivdomainstatus
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// Handles the unsubscribe links of notification mails
+type UnsubscribeController struct {
+	unsubscribeRepo UnsubscribeRepositoryInterface
+	secret          string
+}
+
+// Asks for confirmation, so link scanners of mail servers do not unsubscribe anyone
+var unsubscribeConfirmation = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body>
+<form method="post" action="/unsubscribe">
+<p>Stop sending notifications about .hiv domains to {{.Email}}?</p>
+<input type="hidden" name="email" value="{{.Email}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Unsubscribe</button>
+</form>
+</body>
+</html>
+`))
+
+// GET shows a confirmation form, POST unsubscribes (also used for one-click unsubscribe of mail clients)
+func (c *UnsubscribeController) UnsubscribeHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
+	if len(c.secret) == 0 {
+		HttpProblem(w, r, http.StatusNotFound, "Notifications are not enabled")
+		return
+	}
+	formErr := r.ParseForm()
+	if formErr != nil {
+		HttpProblem(w, r, http.StatusBadRequest, formErr.Error())
+		return
+	}
+	email := r.Form.Get("email")
+	token := r.Form.Get("token")
+	if len(email) == 0 || !VerifyUnsubscribeToken(c.secret, email, token) {
+		HttpProblem(w, r, http.StatusForbidden, "Invalid unsubscribe link")
+		return
+	}
+	if r.Method != "POST" {
+		w.Header().Add("Content-Type", "text/html; charset=utf-8")
+		unsubscribeConfirmation.Execute(w, map[string]string{"Email": email, "Token": token})
+		return
+	}
+	err := c.unsubscribeRepo.Unsubscribe(email)
+	if err != nil {
+		HttpError(w, r, err)
+		return
+	}
+	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("You will no longer receive notifications at " + email + ".\n"))
+}
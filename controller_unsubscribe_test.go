@@ -0,0 +1,68 @@
+package hivdomainstatus
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Records the unsubscribed addresses in memory
+type memoryUnsubscribeRepository struct {
+	UnsubscribeRepositoryInterface
+	emails []string
+}
+
+func (repo *memoryUnsubscribeRepository) Unsubscribe(email string) (err error) {
+	repo.emails = append(repo.emails, email)
+	return
+}
+
+func TestThatItConfirmsUnsubscribing(t *testing.T) {
+	assert := assert.New(t)
+
+	repo := new(memoryUnsubscribeRepository)
+	cntrl := new(UnsubscribeController)
+	cntrl.unsubscribeRepo = repo
+	cntrl.secret = "s3cr3t"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.UnsubscribeHandler(w, r, []string{})
+	}))
+	defer ts.Close()
+
+	form := url.Values{}
+	form.Set("email", "owner@example.com")
+	form.Set("token", UnsubscribeToken("s3cr3t", "owner@example.com"))
+
+	// Following the link does not unsubscribe
+	res, err := http.Get(ts.URL + "/unsubscribe?" + form.Encode())
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.Equal("text/html; charset=utf-8", res.Header.Get("Content-Type"))
+	body, _ := ioutil.ReadAll(res.Body)
+	assert.Contains(string(body), `<form method="post" action="/unsubscribe">`)
+	assert.Contains(string(body), `value="owner@example.com"`)
+	assert.Equal(0, len(repo.emails))
+
+	res, err = http.PostForm(ts.URL+"/unsubscribe", form)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.Equal([]string{"owner@example.com"}, repo.emails)
+
+	// One-click unsubscribe posts to the link
+	res, err = http.Post(ts.URL+"/unsubscribe?"+form.Encode(), "application/x-www-form-urlencoded", strings.NewReader("List-Unsubscribe=One-Click"))
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.Equal(2, len(repo.emails))
+
+	form.Set("token", "invalid")
+	res, err = http.PostForm(ts.URL+"/unsubscribe", form)
+	assert.Nil(err)
+	assert.Equal(http.StatusForbidden, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+	assert.Equal(2, len(repo.emails))
+}
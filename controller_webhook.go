@@ -0,0 +1,214 @@
+package hivdomainstatus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Event types a webhook may subscribe to
+var webhookEventTypes = []string{
+	EVENT_DOMAIN_BECAME_INVALID,
+	EVENT_DOMAIN_RECOVERED,
+	EVENT_DOMAIN_FIRST_CHECKED,
+	EVENT_CHECK_CHANGED,
+	EVENT_CHECK_COMPLETED,
+	EVENT_ALL,
+}
+
+type WebhookController struct {
+	webhookRepo  WebhookRepositoryInterface
+	deliveryRepo WebhookDeliveryRepositoryInterface
+}
+
+func (c *WebhookController) ListingHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
+	if r.Method == "POST" {
+		c.createItem(w, r, routeParams)
+		return
+	}
+	formErr := r.ParseForm()
+	if formErr != nil {
+		HttpProblem(w, r, http.StatusBadRequest, formErr.Error())
+		return
+	}
+
+	itemsPerPage := 100
+	offsetKey := r.Form.Get("offsetKey")
+	items, findErr := c.webhookRepo.FindPaginated(itemsPerPage, offsetKey)
+	if findErr != nil {
+		HttpError(w, r, findErr)
+		return
+	}
+
+	total, maxKey, statsErr := c.webhookRepo.Stats()
+	if statsErr != nil {
+		HttpError(w, r, statsErr)
+		return
+	}
+
+	list := new(WebhookListModel)
+	list.Total = total
+	list.JsonLDContext = "http://jsonld.click4life.hiv/List"
+	list.JsonLDType = "http://jsonld.click4life.hiv/Webhook"
+	list.JsonLDId = getHttpHost(r)
+	list.Items = make([]*WebhookModel, len(items))
+	for i, item := range items {
+		list.Items[i] = transformWebhookEntity(item, getHttpHost(r)+"/webhook/%d")
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	// Add next link
+	if len(items) > 0 {
+		last := list.Items[len(items)-1]
+		w.Header().Add("Link", fmt.Sprintf(`<%s/webhook?offsetKey=%s>; rel="next"`, getHttpHost(r), last.Id))
+	} else {
+		w.Header().Add("Link", fmt.Sprintf(`<%s/webhook?offsetKey=%s>; rel="next"`, getHttpHost(r), maxKey))
+	}
+	encoder := json.NewEncoder(w)
+	encoder.Encode(list)
+}
+
+func (c *WebhookController) createItem(w http.ResponseWriter, r *http.Request, routeParams []string) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		HttpProblem(w, r, http.StatusBadRequest, "Expected application/json got "+r.Header.Get("Content-Type"))
+		return
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		HttpProblem(w, r, http.StatusBadRequest, "Failed to read body: "+err.Error())
+		return
+	}
+
+	var m WebhookModel
+	unmarshalErr := json.Unmarshal(b, &m)
+	if unmarshalErr != nil {
+		HttpProblem(w, r, http.StatusBadRequest, "Failed to parse request: "+bytes.NewBuffer(b).String())
+		return
+	}
+	validationErr := validateWebhook(&m)
+	if validationErr != nil {
+		HttpError(w, r, validationErr)
+		return
+	}
+	webhook := new(Webhook)
+	webhook.URL = m.URL
+	webhook.Secret = m.Secret
+	webhook.Events = m.Events
+	err = c.webhookRepo.Persist(webhook)
+	if err != nil {
+		HttpError(w, r, err)
+		return
+	}
+	m = *transformWebhookEntity(webhook, getHttpHost(r)+"/webhook/%d")
+	w.Header().Add("Location", m.JsonLDId)
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+}
+
+func validateWebhook(m *WebhookModel) (err error) {
+	u, parseErr := url.Parse(m.URL)
+	if parseErr != nil || !u.IsAbs() || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		err = NewValidationError(fmt.Sprintf("Invalid url: %s", m.URL))
+		return
+	}
+	if len(m.Secret) == 0 {
+		err = NewValidationError("Secret must not be empty")
+		return
+	}
+	for _, e := range m.Events {
+		known := false
+		for _, t := range webhookEventTypes {
+			if e == t {
+				known = true
+				break
+			}
+		}
+		if !known {
+			err = NewValidationError(fmt.Sprintf("Unknown event: %s", e))
+			return
+		}
+	}
+	return
+}
+
+func (c *WebhookController) ItemHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
+	webhook, ok := c.findWebhook(w, r, routeParams)
+	if !ok {
+		return
+	}
+
+	if r.Method == "DELETE" {
+		c.webhookRepo.Remove(webhook)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.Encode(transformWebhookEntity(webhook, getHttpHost(r)+"/webhook/%d"))
+}
+
+// Lists the delivery log of a webhook, newest first
+func (c *WebhookController) DeliveriesHandler(w http.ResponseWriter, r *http.Request, routeParams []string) {
+	webhook, ok := c.findWebhook(w, r, routeParams)
+	if !ok {
+		return
+	}
+	formErr := r.ParseForm()
+	if formErr != nil {
+		HttpProblem(w, r, http.StatusBadRequest, formErr.Error())
+		return
+	}
+
+	itemsPerPage := 100
+	offsetKey := r.Form.Get("offsetKey")
+	items, findErr := c.deliveryRepo.FindByWebhook(webhook.Id, itemsPerPage, offsetKey)
+	if findErr != nil {
+		HttpError(w, r, findErr)
+		return
+	}
+	total, statsErr := c.deliveryRepo.StatsByWebhook(webhook.Id)
+	if statsErr != nil {
+		HttpError(w, r, statsErr)
+		return
+	}
+
+	route := fmt.Sprintf("%s/webhook/%d/deliveries", getHttpHost(r), webhook.Id)
+	list := new(WebhookDeliveryListModel)
+	list.Total = total
+	list.JsonLDContext = "http://jsonld.click4life.hiv/List"
+	list.JsonLDType = "http://jsonld.click4life.hiv/WebhookDelivery"
+	list.JsonLDId = route
+	list.Items = make([]*WebhookDeliveryModel, len(items))
+	for i, item := range items {
+		list.Items[i] = transformWebhookDeliveryEntity(item, route+"/%d")
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if len(items) == itemsPerPage {
+		last := list.Items[len(items)-1]
+		w.Header().Add("Link", fmt.Sprintf(`<%s?offsetKey=%s>; rel="next"`, route, last.Id))
+	}
+	encoder := json.NewEncoder(w)
+	encoder.Encode(list)
+}
+
+func (c *WebhookController) findWebhook(w http.ResponseWriter, r *http.Request, routeParams []string) (webhook *Webhook, ok bool) {
+	id, err := strconv.ParseInt(routeParams[1], 0, 64)
+	if err != nil {
+		HttpProblem(w, r, http.StatusBadRequest, "Invalid id: "+routeParams[1])
+		return
+	}
+	webhook, err = c.webhookRepo.FindById(id)
+	if err != nil {
+		HttpError(w, r, notFound(err, "Webhook not found: "+routeParams[1]))
+		return
+	}
+	ok = true
+	return
+}
@@ -0,0 +1,137 @@
+package hivdomainstatus
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func SetupWebhookTest(t *testing.T) (cntrl *WebhookController) {
+	c, configErr := NewConfig()
+	if configErr != nil {
+		t.Fatal(configErr)
+	}
+	db, _ := sql.Open("postgres", c.DSN())
+
+	cntrl = new(WebhookController)
+	cntrl.webhookRepo = NewWebhookRepository(db)
+	cntrl.deliveryRepo = NewWebhookDeliveryRepository(db)
+	db.Exec("TRUNCATE webhook RESTART IDENTITY CASCADE")
+	db.Exec("TRUNCATE webhook_delivery RESTART IDENTITY")
+	return
+}
+
+func TestThatItAddsAndListsWebhooks(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl := SetupWebhookTest(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.ListingHandler(w, r, nil)
+	}))
+	defer ts.Close()
+
+	var data = []byte(`{"url":"https://example.com/hook","secret":"s3cr3t","events":["domain.became_invalid"]}`)
+	res, err := http.Post(ts.URL+"/webhook", "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(http.StatusCreated, res.StatusCode)
+	assert.Equal(ts.URL+"/webhook/1", res.Header.Get("Location"))
+
+	res, err = http.Get(ts.URL + "/webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l WebhookListModel
+	assert.Nil(json.Unmarshal(b, &l))
+	assert.Equal(1, l.Total)
+	assert.Equal("https://example.com/hook", l.Items[0].URL)
+	assert.Equal([]string{EVENT_DOMAIN_BECAME_INVALID}, l.Items[0].Events)
+	assert.Equal(ts.URL+"/webhook/1/deliveries", l.Items[0].Deliveries)
+	// The secret is never returned
+	assert.Equal("", l.Items[0].Secret)
+}
+
+func TestThatItValidatesWebhooks(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl := SetupWebhookTest(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.ListingHandler(w, r, nil)
+	}))
+	defer ts.Close()
+
+	for _, data := range []string{
+		`{"url":"/hook","secret":"s3cr3t"}`,
+		`{"url":"ftp://example.com/hook","secret":"s3cr3t"}`,
+		`{"url":"https://example.com/hook","secret":""}`,
+		`{"url":"https://example.com/hook","secret":"s3cr3t","events":["domain.deleted"]}`,
+	} {
+		res, err := http.Post(ts.URL+"/webhook", "application/json", bytes.NewBufferString(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(http.StatusBadRequest, res.StatusCode, data)
+		assert.Equal("application/problem+json", res.Header.Get("Content-Type"), data)
+	}
+}
+
+func TestThatItListsWebhookDeliveries(t *testing.T) {
+	assert := assert.New(t)
+
+	cntrl := SetupWebhookTest(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cntrl.DeliveriesHandler(w, r, regexp.MustCompile("^/webhook/([0-9]+)/deliveries$").FindStringSubmatch(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	webhook := new(Webhook)
+	webhook.URL = "https://example.com/hook"
+	webhook.Secret = "s3cr3t"
+	assert.Nil(cntrl.webhookRepo.Persist(webhook))
+	for _, eventType := range []string{EVENT_DOMAIN_FIRST_CHECKED, EVENT_DOMAIN_BECAME_INVALID} {
+		delivery := new(WebhookDelivery)
+		delivery.WebhookId = webhook.Id
+		delivery.EventType = eventType
+		delivery.Payload = []byte("{}")
+		assert.Nil(cntrl.deliveryRepo.Persist(delivery))
+	}
+
+	res, err := http.Get(fmt.Sprintf("%s/webhook/%d/deliveries", ts.URL, webhook.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(http.StatusOK, res.StatusCode)
+	var l WebhookDeliveryListModel
+	assert.Nil(json.Unmarshal(b, &l))
+	assert.Equal(2, l.Total)
+	// Newest first
+	assert.Equal(EVENT_DOMAIN_BECAME_INVALID, l.Items[0].Event)
+	assert.Equal(DELIVERY_STATE_PENDING, l.Items[0].State)
+	assert.Equal(EVENT_DOMAIN_FIRST_CHECKED, l.Items[1].Event)
+
+	res, err = http.Get(ts.URL + "/webhook/99/deliveries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(http.StatusNotFound, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+}
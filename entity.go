@@ -10,10 +10,20 @@ type EntityInterface interface {
 
 type Domain struct {
 	EntityInterface
-	Id      int64
-	Name    string
-	Valid   bool
-	Created *time.Time
+	Id        int64
+	Name      string
+	Valid     bool
+	OwnerName string
+	// Email addresses of the registrant, notified about failures
+	Contacts     []string
+	ContactsJson []byte
+	Registrar    string
+	Notes        string
+	// Id of the domain in external systems, e.g. the CRM
+	ExternalRef string
+	// Disabled domains are not checked by the scheduler
+	Disabled bool
+	Created  *time.Time
 }
 
 type DomainCheck struct {
@@ -30,9 +40,22 @@ type DomainCheck struct {
 	IframeTarget   string
 	IframeTargetOk bool
 	Valid          bool
+	HostsJson      []byte
+	Hosts          []*DomainCheckHost
+	InMaintenance  bool
 	Created        *time.Time
 }
 
+// Result for one host (www or apex) of a checked domain
+type DomainCheckHost struct {
+	Host          string `json:"host"`
+	URL           string `json:"url"`
+	StatusCode    int    `json:"statusCode"`
+	ScriptPresent bool   `json:"scriptPresent"`
+	Valid         bool   `json:"valid"`
+	Error         string `json:"error,omitempty"`
+}
+
 func (self *DomainCheck) Equals(other *DomainCheck) bool {
 	if self.Domain != other.Domain {
 		return false
@@ -64,5 +87,235 @@ func (self *DomainCheck) Equals(other *DomainCheck) bool {
 	if self.Valid != other.Valid {
 		return false
 	}
+	if self.InMaintenance != other.InMaintenance {
+		return false
+	}
+	if !reflect.DeepEqual(self.Hosts, other.Hosts) {
+		return false
+	}
 	return true
 }
+
+// Why a check failed, the first failing step of the check
+const (
+	FAILURE_REASON_DNS    = "dns"
+	FAILURE_REASON_HTTP   = "http"
+	FAILURE_REASON_SCRIPT = "script"
+	FAILURE_REASON_IFRAME = "iframe"
+	FAILURE_REASON_OTHER  = "other"
+)
+
+// Returns why the check failed, an empty string for valid checks
+func (self *DomainCheck) FailureReason() string {
+	if self.Valid {
+		return ""
+	}
+	if !self.DnsOK {
+		return FAILURE_REASON_DNS
+	}
+	if self.StatusCode < 200 || self.StatusCode >= 400 {
+		return FAILURE_REASON_HTTP
+	}
+	if !self.ScriptPresent {
+		return FAILURE_REASON_SCRIPT
+	}
+	if self.IframePresent && !self.IframeTargetOk {
+		return FAILURE_REASON_IFRAME
+	}
+	return FAILURE_REASON_OTHER
+}
+
+// Numbers over all domains and their latest check
+type DomainStats struct {
+	Domains       int
+	Valid         int
+	Invalid       int
+	Disabled      int
+	NeverChecked  int
+	DnsOK         int
+	ScriptPresent int
+	IframePresent int
+	HttpsOK       int
+	// Invalid domains by the FailureReason of their latest check
+	FailureReasons map[string]int
+}
+
+// How many domains were valid at the end of a day
+type DailyValidity struct {
+	Day     *time.Time
+	Checked int
+	Valid   int
+}
+
+// When a domain is checked next by the scheduler
+type DomainSchedule struct {
+	EntityInterface
+	Id        int64
+	Domain    string
+	NextCheck *time.Time
+	LastCheck *time.Time
+	Created   *time.Time
+}
+
+// States of a CheckJob
+const (
+	JOB_STATE_QUEUED  = "queued"
+	JOB_STATE_RUNNING = "running"
+	JOB_STATE_DONE    = "done"
+	JOB_STATE_FAILED  = "failed"
+)
+
+// A queued check of a domain, claimed by one worker at a time
+type CheckJob struct {
+	EntityInterface
+	Id         int64
+	Domain     string
+	State      string
+	Worker     string
+	Attempts   int
+	LeaseUntil *time.Time
+	Heartbeat  *time.Time
+	CheckId    int64
+	Error      string
+	Created    *time.Time
+	Finished   *time.Time
+}
+
+// Scopes of an ApiKey
+const (
+	API_KEY_SCOPE_READ  = "read"
+	API_KEY_SCOPE_WRITE = "write"
+)
+
+// A key which grants access to the API, only its hash is stored
+type ApiKey struct {
+	EntityInterface
+	Id       int64
+	Name     string
+	Prefix   string
+	KeyHash  string
+	Scope    string
+	LastUsed *time.Time
+	Revoked  *time.Time
+	Created  *time.Time
+}
+
+// Something that happened to a domain, see event.go for the types
+type Event struct {
+	EntityInterface
+	Id              int64
+	Type            string
+	Domain          string
+	Valid           bool
+	PreviousValid   bool
+	CheckId         int64
+	PreviousCheckId int64
+	Created         *time.Time
+	// The check which triggered the event, not persisted
+	Check *DomainCheck
+}
+
+// An integrator's endpoint which receives events
+type Webhook struct {
+	EntityInterface
+	Id         int64
+	URL        string
+	Secret     string
+	Events     []string
+	EventsJson []byte
+	Created    *time.Time
+}
+
+// Checks if the event filter of the webhook matches eventType. An empty filter
+// matches the status transitions, check.completed is only sent if it is subscribed.
+func (self *Webhook) Accepts(eventType string) bool {
+	if len(self.Events) == 0 {
+		return eventType != EVENT_CHECK_COMPLETED
+	}
+	for _, e := range self.Events {
+		if e == eventType || e == EVENT_ALL {
+			return true
+		}
+	}
+	return false
+}
+
+// States of a WebhookDelivery
+const (
+	DELIVERY_STATE_PENDING   = "pending"
+	DELIVERY_STATE_DELIVERED = "delivered"
+	DELIVERY_STATE_FAILED    = "failed"
+)
+
+// One event sent (or to be sent) to a webhook
+type WebhookDelivery struct {
+	EntityInterface
+	Id          int64
+	WebhookId   int64
+	EventType   string
+	Payload     []byte
+	State       string
+	Attempts    int
+	NextAttempt *time.Time
+	StatusCode  int
+	Response    string
+	Error       string
+	Created     *time.Time
+	Delivered   *time.Time
+}
+
+// Kinds of a Notification
+const (
+	NOTIFICATION_KIND_FAILURE   = "failure"
+	NOTIFICATION_KIND_FOLLOW_UP = "followup"
+	NOTIFICATION_KIND_RECOVERY  = "recovery"
+)
+
+// States of a Notification
+const (
+	NOTIFICATION_STATE_PENDING = "pending"
+	NOTIFICATION_STATE_SENDING = "sending"
+	NOTIFICATION_STATE_SENT    = "sent"
+	// Not sent because the recipient unsubscribed or the domain recovered before
+	NOTIFICATION_STATE_SKIPPED = "skipped"
+	NOTIFICATION_STATE_FAILED  = "failed"
+)
+
+// An email to the registrant of a domain
+type Notification struct {
+	EntityInterface
+	Id      int64
+	Domain  string
+	Email   string
+	Kind    string
+	State   string
+	Error   string
+	Sent    *time.Time
+	Created *time.Time
+}
+
+// A label to group domains, e.g. a campaign, partner or tier
+type Tag struct {
+	EntityInterface
+	Id   int64
+	Name string
+	// Number of tagged domains, only set by listings
+	Domains int
+	Created *time.Time
+}
+
+// A period in which a domain is expected to fail, e.g. during a relaunch
+type MaintenanceWindow struct {
+	EntityInterface
+	Id       int64
+	DomainId int64
+	Starts   *time.Time
+	Ends     *time.Time
+	Reason   string
+	Created  *time.Time
+}
+
+// Checks if the window covers t
+func (self *MaintenanceWindow) ActiveAt(t time.Time) bool {
+	return !t.Before(*self.Starts) && t.Before(*self.Ends)
+}
@@ -81,4 +81,47 @@ func TestThatDomainCheckEquals(t *testing.T) {
 	assert.False(c1.Equals(c2))
 	c1.Addresses = c2.Addresses
 	assert.True(c1.Equals(c2))
+
+	c2.Hosts = []*DomainCheckHost{&DomainCheckHost{Host: "example.hiv", StatusCode: 200}}
+	assert.False(c1.Equals(c2))
+	c1.Hosts = []*DomainCheckHost{&DomainCheckHost{Host: "example.hiv", StatusCode: 200}}
+	assert.True(c1.Equals(c2))
+	c2.Hosts[0].StatusCode = 500
+	assert.False(c1.Equals(c2))
+}
+
+func TestThatItDeterminesTheFailureReason(t *testing.T) {
+	assert := assert.New(t)
+
+	c := new(DomainCheck)
+	c.Valid = true
+	assert.Equal("", c.FailureReason())
+	c.Valid = false
+	assert.Equal(FAILURE_REASON_DNS, c.FailureReason())
+	c.DnsOK = true
+	assert.Equal(FAILURE_REASON_HTTP, c.FailureReason())
+	c.StatusCode = 200
+	assert.Equal(FAILURE_REASON_SCRIPT, c.FailureReason())
+	c.ScriptPresent = true
+	c.IframePresent = true
+	assert.Equal(FAILURE_REASON_IFRAME, c.FailureReason())
+	c.IframeTargetOk = true
+	assert.Equal(FAILURE_REASON_OTHER, c.FailureReason())
+}
+
+func TestThatWebhooksFilterEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	webhook := new(Webhook)
+	assert.True(webhook.Accepts(EVENT_DOMAIN_BECAME_INVALID))
+	assert.True(webhook.Accepts(EVENT_CHECK_CHANGED))
+	assert.False(webhook.Accepts(EVENT_CHECK_COMPLETED))
+
+	webhook.Events = []string{EVENT_CHECK_COMPLETED}
+	assert.True(webhook.Accepts(EVENT_CHECK_COMPLETED))
+	assert.False(webhook.Accepts(EVENT_DOMAIN_RECOVERED))
+
+	webhook.Events = []string{EVENT_ALL}
+	assert.True(webhook.Accepts(EVENT_CHECK_COMPLETED))
+	assert.True(webhook.Accepts(EVENT_DOMAIN_RECOVERED))
 }
@@ -0,0 +1,47 @@
+package hivdomainstatus
+
+import "sync"
+
+// Types of events published by the Manager
+const (
+	EVENT_DOMAIN_BECAME_INVALID = "domain.became_invalid"
+	EVENT_DOMAIN_RECOVERED      = "domain.recovered"
+	EVENT_DOMAIN_FIRST_CHECKED  = "domain.first_checked"
+	EVENT_CHECK_CHANGED         = "check.changed"
+	// Published for every check result, not persisted
+	EVENT_CHECK_COMPLETED = "check.completed"
+	// Subscribes to all events
+	EVENT_ALL = "*"
+)
+
+type EventHandler func(event *Event)
+
+// In-process publish/subscribe of events
+type EventBus struct {
+	mutex    sync.RWMutex
+	handlers map[string][]EventHandler
+}
+
+func NewEventBus() (b *EventBus) {
+	b = new(EventBus)
+	b.handlers = make(map[string][]EventHandler)
+	return
+}
+
+// Registers handler for events of eventType, use EVENT_ALL for all events
+func (b *EventBus) Subscribe(eventType string, handler EventHandler) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+// Calls the handlers subscribed to the type of event.
+// Handlers are called synchronously, slow handlers should do their work in the background.
+func (b *EventBus) Publish(event *Event) {
+	b.mutex.RLock()
+	handlers := append(append([]EventHandler{}, b.handlers[event.Type]...), b.handlers[EVENT_ALL]...)
+	b.mutex.RUnlock()
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
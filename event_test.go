@@ -0,0 +1,31 @@
+package hivdomainstatus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatEventBusDeliversToSubscribers(t *testing.T) {
+	assert := assert.New(t)
+
+	b := NewEventBus()
+	invalid := 0
+	all := 0
+	b.Subscribe(EVENT_DOMAIN_BECAME_INVALID, func(event *Event) {
+		invalid++
+	})
+	b.Subscribe(EVENT_ALL, func(event *Event) {
+		all++
+	})
+
+	e := new(Event)
+	e.Type = EVENT_DOMAIN_BECAME_INVALID
+	b.Publish(e)
+	e = new(Event)
+	e.Type = EVENT_DOMAIN_RECOVERED
+	b.Publish(e)
+
+	assert.Equal(1, invalid)
+	assert.Equal(2, all)
+}
@@ -1,31 +1,159 @@
 package hivdomainstatus
 
 import (
-"encoding/json"
-"net/http"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/lib/pq"
 )
 
-// Implements the http-problem messafes
-// See http://datatracker.ietf.org/doc/draft-ietf-appsawg-http-problem/
+// Implements the problem details of RFC 7807
+// See https://tools.ietf.org/html/rfc7807
 
 type HttpProblemModel struct {
 	JsonLDTypedModel
-	Type string                  `json:"type,omitempty"`
-	Title string `json:"title,omitempty"`
-	Detail string `json:"detail,omitempty"`
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// Problem types, stable URIs clients can rely on
+const (
+	PROBLEM_TYPE_BAD_REQUEST        = "http://jsonld.click4life.hiv/problem/bad-request"
+	PROBLEM_TYPE_VALIDATION         = "http://jsonld.click4life.hiv/problem/validation-failed"
+	PROBLEM_TYPE_UNAUTHORIZED       = "http://jsonld.click4life.hiv/problem/unauthorized"
+	PROBLEM_TYPE_FORBIDDEN          = "http://jsonld.click4life.hiv/problem/forbidden"
+	PROBLEM_TYPE_NOT_FOUND          = "http://jsonld.click4life.hiv/problem/not-found"
+	PROBLEM_TYPE_METHOD_NOT_ALLOWED = "http://jsonld.click4life.hiv/problem/method-not-allowed"
+	PROBLEM_TYPE_NOT_ACCEPTABLE     = "http://jsonld.click4life.hiv/problem/not-acceptable"
+	PROBLEM_TYPE_CONFLICT           = "http://jsonld.click4life.hiv/problem/conflict"
+	PROBLEM_TYPE_TOO_LARGE          = "http://jsonld.click4life.hiv/problem/request-too-large"
+	PROBLEM_TYPE_UNSUPPORTED        = "http://jsonld.click4life.hiv/problem/unsupported-media-type"
+	PROBLEM_TYPE_INTERNAL           = "http://jsonld.click4life.hiv/problem/internal-error"
+)
+
+// The type of problems which are only described by their status code
+var problemTypes = map[int]string{
+	http.StatusBadRequest:            PROBLEM_TYPE_BAD_REQUEST,
+	http.StatusUnauthorized:          PROBLEM_TYPE_UNAUTHORIZED,
+	http.StatusForbidden:             PROBLEM_TYPE_FORBIDDEN,
+	http.StatusNotFound:              PROBLEM_TYPE_NOT_FOUND,
+	http.StatusMethodNotAllowed:      PROBLEM_TYPE_METHOD_NOT_ALLOWED,
+	http.StatusNotAcceptable:         PROBLEM_TYPE_NOT_ACCEPTABLE,
+	http.StatusConflict:              PROBLEM_TYPE_CONFLICT,
+	http.StatusRequestEntityTooLarge: PROBLEM_TYPE_TOO_LARGE,
+	http.StatusUnsupportedMediaType:  PROBLEM_TYPE_UNSUPPORTED,
+	http.StatusInternalServerError:   PROBLEM_TYPE_INTERNAL,
+}
+
+// Messages for violated unique constraints, by constraint name
+var conflictDetails = map[string]string{
+	"domain_name_key":      "A domain with this name already exists",
+	"tag_name_key":         "A tag with this name already exists",
+	"api_key_key_hash_key": "The API key already exists",
+}
+
+// An error with a detail message which is safe to show to clients
+type ApiError struct {
+	Status int
+	Type   string
+	Detail string
+	// The underlying error, it is logged but not shown
+	Cause error
+}
+
+func (e *ApiError) Error() string {
+	if e.Cause != nil {
+		return e.Detail + ": " + e.Cause.Error()
+	}
+	return e.Detail
+}
+
+func NewValidationError(detail string) *ApiError {
+	return &ApiError{http.StatusBadRequest, PROBLEM_TYPE_VALIDATION, detail, nil}
+}
+
+func NewNotFoundError(detail string) *ApiError {
+	return &ApiError{http.StatusNotFound, PROBLEM_TYPE_NOT_FOUND, detail, nil}
+}
+
+func NewConflictError(detail string) *ApiError {
+	return &ApiError{http.StatusConflict, PROBLEM_TYPE_CONFLICT, detail, nil}
+}
+
+// Returns a not found error with detail if err is nil or no rows were found, otherwise err
+func notFound(err error, detail string) error {
+	if err == nil || err == sql.ErrNoRows {
+		return NewNotFoundError(detail)
+	}
+	return err
+}
+
+// Maps errors of the repositories to an ApiError, unknown errors become internal errors
+func ToApiError(err error) *ApiError {
+	if apiErr, ok := err.(*ApiError); ok {
+		return apiErr
+	}
+	if err == sql.ErrNoRows {
+		return &ApiError{http.StatusNotFound, PROBLEM_TYPE_NOT_FOUND, "Not found", err}
+	}
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch pqErr.Code.Name() {
+		case "unique_violation":
+			detail, ok := conflictDetails[pqErr.Constraint]
+			if !ok {
+				detail = "Conflicts with an existing resource"
+			}
+			return &ApiError{http.StatusConflict, PROBLEM_TYPE_CONFLICT, detail, err}
+		case "string_data_right_truncation":
+			return &ApiError{http.StatusBadRequest, PROBLEM_TYPE_VALIDATION, "A value is too long", err}
+		case "not_null_violation", "check_violation", "invalid_text_representation", "invalid_datetime_format", "datetime_field_overflow":
+			return &ApiError{http.StatusBadRequest, PROBLEM_TYPE_VALIDATION, "A value is invalid", err}
+		}
+	}
+	return &ApiError{http.StatusInternalServerError, PROBLEM_TYPE_INTERNAL, "An internal error occurred", err}
 }
 
 func NewHttpProblem() (p *HttpProblemModel) {
 	p = new(HttpProblemModel)
 	p.JsonLDContext = "http://ietf.org/appsawg/http-problem"
+	p.Type = "about:blank"
 	return
 }
 
-func HttpProblem(w http.ResponseWriter, statusCode int, message string) {
+// Sends a problem with the type of the status code
+func HttpProblem(w http.ResponseWriter, r *http.Request, statusCode int, detail string) {
+	problemType, ok := problemTypes[statusCode]
+	if !ok {
+		problemType = "about:blank"
+	}
+	writeProblem(w, r, statusCode, problemType, detail)
+}
+
+// Sends err as problem, the cause of an internal error is logged instead of shown
+func HttpError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := ToApiError(err)
+	if apiErr.Status >= 500 {
+		log.Printf("ERROR: %s %s: %s\n", r.Method, r.URL.Path, apiErr.Error())
+	}
+	writeProblem(w, r, apiErr.Status, apiErr.Type, apiErr.Detail)
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, statusCode int, problemType string, detail string) {
 	p := NewHttpProblem()
-	p.Title = message
-	w.Header().Add("Content-Type", "application/problem+json")
+	p.Type = problemType
+	p.Title = http.StatusText(statusCode)
+	p.Status = statusCode
+	p.Detail = detail
+	if r != nil {
+		p.Instance = r.URL.RequestURI()
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(statusCode)
 	encoder := json.NewEncoder(w)
 	encoder.Encode(p)
-	w.WriteHeader(statusCode)
-}
\ No newline at end of file
+}
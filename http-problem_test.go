@@ -0,0 +1,61 @@
+package hivdomainstatus
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatItSendsProblemsWithStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	r, _ := http.NewRequest("GET", "http://localhost/domain/99?x=1", nil)
+	w := httptest.NewRecorder()
+	HttpError(w, r, notFound(sql.ErrNoRows, "Domain not found: 99"))
+	assert.Equal(http.StatusNotFound, w.Code)
+	assert.Equal("application/problem+json", w.Header().Get("Content-Type"))
+	p := new(HttpProblemModel)
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), p))
+	assert.Equal(PROBLEM_TYPE_NOT_FOUND, p.Type)
+	assert.Equal("Not Found", p.Title)
+	assert.Equal(http.StatusNotFound, p.Status)
+	assert.Equal("Domain not found: 99", p.Detail)
+	assert.Equal("/domain/99?x=1", p.Instance)
+
+	w = httptest.NewRecorder()
+	HttpProblem(w, r, http.StatusTeapot, "Short and stout")
+	assert.Equal(http.StatusTeapot, w.Code)
+	p = new(HttpProblemModel)
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), p))
+	assert.Equal("about:blank", p.Type)
+}
+
+func TestThatItMapsErrorsToProblems(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(http.StatusNotFound, ToApiError(sql.ErrNoRows).Status)
+	assert.Equal(PROBLEM_TYPE_VALIDATION, ToApiError(NewValidationError("Invalid name")).Type)
+
+	conflict := ToApiError(&pq.Error{Code: "23505", Constraint: "domain_name_key", Message: `duplicate key value violates unique constraint "domain_name_key"`})
+	assert.Equal(http.StatusConflict, conflict.Status)
+	assert.Equal("A domain with this name already exists", conflict.Detail)
+	// Postgres names unique constraints <table>_<column>_key
+	assert.Equal("The API key already exists", ToApiError(&pq.Error{Code: "23505", Constraint: "api_key_key_hash_key"}).Detail)
+
+	tooLong := ToApiError(&pq.Error{Code: "22001"})
+	assert.Equal(http.StatusBadRequest, tooLong.Status)
+	assert.Equal(PROBLEM_TYPE_VALIDATION, tooLong.Type)
+
+	// The cause of internal errors is not shown
+	internal := ToApiError(errors.New(`pq: relation "domain" does not exist`))
+	assert.Equal(http.StatusInternalServerError, internal.Status)
+	assert.Equal("An internal error occurred", internal.Detail)
+	assert.NotNil(internal.Cause)
+	assert.Equal(http.StatusInternalServerError, ToApiError(notFound(errors.New("connection refused"), "Domain not found: 1")).Status)
+}
@@ -0,0 +1,147 @@
+package hivdomainstatus
+
+import (
+	"database/sql"
+)
+
+// Outcome of an imported line
+const (
+	IMPORT_STATE_CREATED  = "created"
+	IMPORT_STATE_EXISTS   = "exists"
+	IMPORT_STATE_REJECTED = "rejected"
+)
+
+// A parsed line of an import, Err is set if the line could not be parsed
+type ImportLine struct {
+	Line   int
+	Domain *DomainModel
+	Err    error
+}
+
+type ImportResult struct {
+	Line     int
+	Name     string
+	State    string
+	Reason   string
+	DomainId int64
+}
+
+// Creates many domains at once, existing domains are left untouched
+type Importer struct {
+	db         *sql.DB
+	domainRepo DomainRepositoryInterface
+	tagRepo    TagRepositoryInterface
+}
+
+func NewImporter(db *sql.DB, domainRepo DomainRepositoryInterface, tagRepo TagRepositoryInterface) (i *Importer) {
+	i = new(Importer)
+	i.db = db
+	i.domainRepo = domainRepo
+	i.tagRepo = tagRepo
+	return
+}
+
+// Imports lines, reporting the outcome of every line.
+// If atomic is set all lines are imported in one transaction which is only
+// committed if no line is rejected, otherwise every line is stored in its own
+// transaction, so nothing of a rejected line is stored.
+// err is only set if the database fails.
+func (i *Importer) Import(lines []*ImportLine, atomic bool) (results []*ImportResult, committed bool, err error) {
+	var tx *sql.Tx
+	if atomic {
+		tx, err = i.db.Begin()
+		if err != nil {
+			return
+		}
+	}
+	rejected := 0
+	results = make([]*ImportResult, len(lines))
+	for n, line := range lines {
+		result := new(ImportResult)
+		result.Line = line.Line
+		results[n] = result
+		if line.Domain != nil {
+			result.Name = line.Domain.Name
+		}
+		importErr := line.Err
+		if importErr == nil {
+			importErr = validateDomain(line.Domain)
+		}
+		if importErr == nil && atomic {
+			result.State, result.DomainId, importErr = i.importDomain(tx, line.Domain)
+			if importErr != nil {
+				// The transaction is aborted
+				tx.Rollback()
+				err = importErr
+				return
+			}
+		} else if importErr == nil {
+			result.State, result.DomainId, importErr, err = i.importLine(line.Domain)
+			if err != nil {
+				return
+			}
+		}
+		if line.Err != nil {
+			result.State = IMPORT_STATE_REJECTED
+			result.Reason = line.Err.Error()
+			rejected++
+		} else if importErr != nil {
+			// Do not leak database errors
+			result.State = IMPORT_STATE_REJECTED
+			result.Reason = ToApiError(importErr).Detail
+			rejected++
+		}
+	}
+	if !atomic {
+		committed = true
+		return
+	}
+	if rejected > 0 {
+		err = tx.Rollback()
+		return
+	}
+	err = tx.Commit()
+	committed = err == nil
+	return
+}
+
+// Imports a domain in its own transaction, importErr rejects the line, err is set if no transaction could be started
+func (i *Importer) importLine(m *DomainModel) (state string, id int64, importErr error, err error) {
+	tx, err := i.db.Begin()
+	if err != nil {
+		return
+	}
+	state, id, importErr = i.importDomain(tx, m)
+	if importErr != nil {
+		tx.Rollback()
+		return
+	}
+	importErr = tx.Commit()
+	return
+}
+
+// Creates the domain unless it exists
+func (i *Importer) importDomain(tx *sql.Tx, m *DomainModel) (state string, id int64, err error) {
+	existing, err := i.domainRepo.FindByNameTx(tx, m.Name)
+	if err == nil {
+		state = IMPORT_STATE_EXISTS
+		id = existing.Id
+		return
+	}
+	if err != sql.ErrNoRows {
+		return
+	}
+	domain := new(Domain)
+	domain.Name = m.Name
+	applyDomainModel(domain, m)
+	err = i.domainRepo.PersistTx(tx, domain)
+	if err == nil {
+		err = i.tagRepo.SetDomainTagsTx(tx, domain.Id, m.Tags)
+	}
+	if err != nil {
+		return
+	}
+	state = IMPORT_STATE_CREATED
+	id = domain.Id
+	return
+}
@@ -5,6 +5,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	hivdomainstatus "github.com/dothiv/hiv-domain-status"
 	"github.com/wsxiaoys/terminal/color"
@@ -16,7 +21,7 @@ func error(msg string) {
 
 func Help() {
 	color.Fprintln(os.Stdout, fmt.Sprintf("Usage: %s %s\n", os.Args[0], "@{g}<command>@{|}"))
-	color.Fprintln(os.Stdout, "  @{g}command@{|} may be         help | server | check\n")
+	color.Fprintln(os.Stdout, "  @{g}command@{|} may be         help | server | check | scheduler | apikey\n")
 	color.Fprintln(os.Stdout, fmt.Sprintf("Use %s help <command> to get help for a command", os.Args[0]))
 }
 
@@ -43,11 +48,31 @@ func main() {
 		}
 		switch os.Args[2] {
 		case "check":
-			color.Fprintln(os.Stdout, fmt.Sprintf("Usage: %s %s\n", os.Args[0], " check @{g}<hiv-domain>@{|}"))
+			color.Fprintln(os.Stdout, fmt.Sprintf("Usage: %s %s\n", os.Args[0], " check [@{g}<hiv-domain>@{|} | --tag @{g}<tag>@{|}]"))
 			os.Stdout.WriteString("Check hiv domains.\n")
 			os.Stdout.WriteString("\n")
 			color.Fprintln(os.Stdout, "  @{g}hiv-domain@{|}           the .hiv domain to check")
 			color.Fprintln(os.Stdout, "                       check all registered domains if not set")
+			color.Fprintln(os.Stdout, "  @{g}tag@{|}                  only check the registered domains with this tag")
+			os.Stdout.WriteString("\n")
+			os.Stdout.WriteString("All domains are put into a job queue in the database, run the check on\n")
+			os.Stdout.WriteString("multiple machines to share the work.\n")
+			os.Stdout.WriteString("\n")
+			os.Stdout.WriteString("Exits with 1 if a single domain is invalid or results could not be stored.\n")
+		case "scheduler":
+			color.Fprintln(os.Stdout, fmt.Sprintf("Usage: %s %s\n", os.Args[0], " scheduler"))
+			os.Stdout.WriteString("Continuously recheck the registered domains when they are due.\n")
+			os.Stdout.WriteString("The scheduler can also run within the server, see [scheduler] in config.ini\n")
+		case "apikey":
+			color.Fprintln(os.Stdout, fmt.Sprintf("Usage: %s %s\n", os.Args[0], " apikey create @{g}<name>@{|} [--read-only] | list | revoke @{g}<id>@{|}"))
+			os.Stdout.WriteString("Manage the API keys of the server.\n")
+			os.Stdout.WriteString("\n")
+			color.Fprintln(os.Stdout, "  @{g}name@{|}                 who uses the key")
+			color.Fprintln(os.Stdout, "  @{g}id@{|}                   the id of the key as shown by list")
+			os.Stdout.WriteString("\n")
+			os.Stdout.WriteString("The key is only shown when it is created, send it as bearer token:\n")
+			os.Stdout.WriteString("  Authorization: Bearer <key>\n")
+			os.Stdout.WriteString("Read-only keys can only be used for GET, HEAD and OPTIONS requests.\n")
 		}
 		os.Exit(0)
 	case "server":
@@ -66,25 +91,190 @@ func main() {
 		}
 		domainRepo := hivdomainstatus.NewDomainRepository(db)
 		domainCheckRepo := hivdomainstatus.NewDomainCheckRepository(db)
-		manager := hivdomainstatus.NewManager(domainRepo, domainCheckRepo)
-
-		if len(os.Args) > 2 {
-			var result *hivdomainstatus.DomainCheckResult
-			result, err = hivdomainstatus.CheckDomain(c, os.Args[2])
-			manager.OnCheckDomainResult(result)
+		manager := hivdomainstatus.NewManager(db, domainRepo, domainCheckRepo, hivdomainstatus.NewEventRepository(db), hivdomainstatus.NewMaintenanceWindowRepository(db))
+		dispatcher, err := hivdomainstatus.NewWebhookDispatcher(c, hivdomainstatus.NewWebhookRepository(db), hivdomainstatus.NewWebhookDeliveryRepository(db))
+		if err != nil {
+			error(err.Error())
+			os.Exit(1)
+		}
+		dispatcher.Subscribe(manager.Events)
+		var notifier *hivdomainstatus.Notifier
+		if c.Smtp.Enabled {
+			notifier, err = hivdomainstatus.NewNotifier(c, domainRepo, hivdomainstatus.NewNotificationRepository(db), hivdomainstatus.NewUnsubscribeRepository(db))
 			if err != nil {
-				log.Fatalln(err.Error())
+				error(err.Error())
+				os.Exit(1)
 			}
+			notifier.Subscribe(manager.Events)
+		}
+
+		summary := hivdomainstatus.NewRunSummary()
+		tag := ""
+		if len(os.Args) > 2 && os.Args[2] == "--tag" {
+			if len(os.Args) != 4 {
+				error("--tag requires a tag")
+				os.Exit(1)
+			}
+			tag = strings.ToLower(os.Args[3])
+		}
+		invalid := false
+		if len(os.Args) > 2 && len(tag) == 0 {
+			// The problem is logged by CheckDomain, the exit status tells that the domain does not work
+			result, _ := hivdomainstatus.CheckDomain(c, os.Args[2])
+			summary.Add(os.Args[2], result.Valid, manager.OnCheckDomainResult(result))
+			invalid = !result.Valid
 		} else {
-			domains, findAllErr := domainRepo.FindAll()
-			if findAllErr != nil {
-				error(findAllErr.Error())
+			// Queue all (tagged) domains, other check processes using the same database share the jobs
+			jobRepo := hivdomainstatus.NewCheckJobRepository(db)
+			_, enqueueErr := jobRepo.EnqueueAll(tag)
+			if enqueueErr != nil {
+				error(enqueueErr.Error())
+				os.Exit(1)
+			}
+			worker, workerErr := hivdomainstatus.NewWorker(c, jobRepo, manager)
+			if workerErr != nil {
+				error(workerErr.Error())
+				os.Exit(1)
+			}
+			summary = worker.Summary
+			drainErr := worker.Drain()
+			if drainErr != nil {
+				error(drainErr.Error())
+				os.Exit(1)
+			}
+		}
+		if notifier != nil {
+			// Send the notifications of this run, unless a scheduler or server does
+			_, followUpErr := notifier.QueueFollowUps()
+			if followUpErr != nil {
+				error(followUpErr.Error())
+			}
+			_, sendErr := notifier.SendPending()
+			if sendErr != nil {
+				error(sendErr.Error())
+			}
+		}
+		log.Println(summary.String())
+		if len(summary.Errors) > 0 || invalid {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "apikey":
+		if len(os.Args) < 3 {
+			error("apikey requires a command: create, list or revoke")
+			os.Exit(1)
+		}
+		db, err := sql.Open("postgres", c.DSN())
+		if err != nil {
+			error(err.Error())
+			os.Exit(1)
+		}
+		apiKeyRepo := hivdomainstatus.NewApiKeyRepository(db)
+		switch os.Args[2] {
+		case "create":
+			if len(os.Args) < 4 || len(os.Args) > 5 || (len(os.Args) == 5 && os.Args[4] != "--read-only") {
+				error("Usage: apikey create <name> [--read-only]")
 				os.Exit(1)
 			}
-			for _, domain := range domains {
-				result, _ := hivdomainstatus.CheckDomain(c, domain.Name)
-				manager.OnCheckDomainResult(result)
+			scope := hivdomainstatus.API_KEY_SCOPE_WRITE
+			if len(os.Args) == 5 {
+				scope = hivdomainstatus.API_KEY_SCOPE_READ
+			}
+			key, secret, err := hivdomainstatus.NewApiKey(os.Args[3], scope)
+			if err == nil {
+				err = apiKeyRepo.Persist(key)
+			}
+			if err != nil {
+				error(err.Error())
+				os.Exit(1)
 			}
+			fmt.Printf("Created %s key %d for %s, it is not shown again:\n%s\n", key.Scope, key.Id, key.Name, secret)
+		case "list":
+			keys, err := apiKeyRepo.FindAll()
+			if err != nil {
+				error(err.Error())
+				os.Exit(1)
+			}
+			for _, key := range keys {
+				state := "active"
+				if key.Revoked != nil {
+					state = "revoked " + key.Revoked.Format(time.RFC3339)
+				}
+				lastUsed := "never used"
+				if key.LastUsed != nil {
+					lastUsed = "last used " + key.LastUsed.Format(time.RFC3339)
+				}
+				fmt.Printf("%d\t%s...\t%s\t%s\t%s\t%s\n", key.Id, key.Prefix, key.Scope, key.Name, state, lastUsed)
+			}
+		case "revoke":
+			if len(os.Args) != 4 {
+				error("Usage: apikey revoke <id>")
+				os.Exit(1)
+			}
+			id, err := strconv.ParseInt(os.Args[3], 10, 64)
+			if err != nil {
+				error("Invalid id: " + os.Args[3])
+				os.Exit(1)
+			}
+			key, err := apiKeyRepo.FindById(id)
+			if err == nil {
+				err = apiKeyRepo.Revoke(key)
+			}
+			if err != nil {
+				error(err.Error())
+				os.Exit(1)
+			}
+			fmt.Printf("Revoked key %d of %s\n", key.Id, key.Name)
+		default:
+			error("Unknown apikey command: " + os.Args[2])
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "scheduler":
+		db, err := sql.Open("postgres", c.DSN())
+		if err != nil {
+			error(err.Error())
+			os.Exit(1)
+		}
+		domainRepo := hivdomainstatus.NewDomainRepository(db)
+		domainCheckRepo := hivdomainstatus.NewDomainCheckRepository(db)
+		manager := hivdomainstatus.NewManager(db, domainRepo, domainCheckRepo, hivdomainstatus.NewEventRepository(db), hivdomainstatus.NewMaintenanceWindowRepository(db))
+		dispatcher, err := hivdomainstatus.NewWebhookDispatcher(c, hivdomainstatus.NewWebhookRepository(db), hivdomainstatus.NewWebhookDeliveryRepository(db))
+		if err != nil {
+			error(err.Error())
+			os.Exit(1)
+		}
+		dispatcher.Subscribe(manager.Events)
+		var notifier *hivdomainstatus.Notifier
+		if c.Smtp.Enabled {
+			notifier, err = hivdomainstatus.NewNotifier(c, domainRepo, hivdomainstatus.NewNotificationRepository(db), hivdomainstatus.NewUnsubscribeRepository(db))
+			if err != nil {
+				error(err.Error())
+				os.Exit(1)
+			}
+			notifier.Subscribe(manager.Events)
+		}
+		scheduler, err := hivdomainstatus.NewScheduler(c, hivdomainstatus.NewDomainScheduleRepository(db), hivdomainstatus.NewCheckJobRepository(db), manager)
+		if err != nil {
+			error(err.Error())
+			os.Exit(1)
+		}
+		// Finish running checks on shutdown
+		stop := make(chan bool)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			close(stop)
+		}()
+		go dispatcher.Run(stop)
+		if notifier != nil {
+			go notifier.Run(stop)
+		}
+		err = scheduler.Run(stop)
+		if err != nil {
+			error(err.Error())
+			os.Exit(1)
 		}
 		os.Exit(0)
 	}
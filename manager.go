@@ -2,23 +2,64 @@ package hivdomainstatus
 
 import (
 	"database/sql"
-	"log"
+	"fmt"
+	"time"
 )
 
 type Manager struct {
+	db              *sql.DB
 	domainRepo      DomainRepositoryInterface
 	domainCheckRepo DomainCheckRepositoryInterface
+	eventRepo       EventRepositoryInterface
+	maintenanceRepo MaintenanceWindowRepositoryInterface
+	Events          *EventBus
 }
 
-func NewManager(domainRepo DomainRepositoryInterface, domainCheckRepo DomainCheckRepositoryInterface) (m *Manager) {
+func NewManager(db *sql.DB, domainRepo DomainRepositoryInterface, domainCheckRepo DomainCheckRepositoryInterface, eventRepo EventRepositoryInterface, maintenanceRepo MaintenanceWindowRepositoryInterface) (m *Manager) {
 	m = new(Manager)
+	m.db = db
 	m.domainRepo = domainRepo
 	m.domainCheckRepo = domainCheckRepo
+	m.eventRepo = eventRepo
+	m.maintenanceRepo = maintenanceRepo
+	m.Events = NewEventBus()
 	return
 }
 
+// Stores the result of a check. The domain, the check and the events are
+// stored in one transaction, on error nothing is stored.
+// The events are published after the transaction has been committed.
 func (m *Manager) OnCheckDomainResult(r *DomainCheckResult) (err error) {
-	domain, err := m.domainRepo.FindByName(r.Domain)
+	if r == nil {
+		err = fmt.Errorf("No check result")
+		return
+	}
+	tx, err := m.db.Begin()
+	if err != nil {
+		return
+	}
+	events, completed, err := m.storeResult(tx, r)
+	if err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("Failed to store result for %s: %s", r.Domain, err.Error())
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+	for _, event := range events {
+		m.Events.Publish(event)
+	}
+	m.Events.Publish(completed)
+	return
+}
+
+// Returns the persisted events and the (not persisted) check.completed event.
+// While a maintenance window of the domain is active the check is marked as
+// in maintenance and the validity of the domain is kept, so no transitions are emitted.
+func (m *Manager) storeResult(tx *sql.Tx, r *DomainCheckResult) (events []*Event, completed *Event, err error) {
+	domain, err := m.domainRepo.FindByNameTx(tx, r.Domain)
 	if err == sql.ErrNoRows {
 		domain = new(Domain)
 		domain.Name = r.Domain
@@ -26,8 +67,23 @@ func (m *Manager) OnCheckDomainResult(r *DomainCheckResult) (err error) {
 	} else if err != nil {
 		return
 	}
-	domain.Valid = r.Valid
-	m.domainRepo.Persist(domain)
+	inMaintenance := false
+	if domain.Id > 0 {
+		windows, windowsErr := m.maintenanceRepo.FindActiveByDomainTx(tx, domain.Id, time.Now())
+		if windowsErr != nil {
+			err = windowsErr
+			return
+		}
+		inMaintenance = len(windows) > 0
+	}
+	previousValid := domain.Valid
+	if !inMaintenance {
+		domain.Valid = r.Valid
+	}
+	err = m.domainRepo.PersistTx(tx, domain)
+	if err != nil {
+		return
+	}
 
 	result := new(DomainCheck)
 	result.Domain = r.Domain
@@ -40,21 +96,56 @@ func (m *Manager) OnCheckDomainResult(r *DomainCheckResult) (err error) {
 	result.IframeTarget = r.IframeTarget
 	result.IframeTargetOk = r.IframeTargetOk
 	result.Valid = r.Valid
-	lastResult, resultErr := m.domainCheckRepo.FindLatestByDomain(domain.Name)
+	result.Hosts = r.Hosts
+	result.InMaintenance = inMaintenance
+	lastResult, resultErr := m.domainCheckRepo.FindLatestByDomainTx(tx, domain.Name)
 	if resultErr == sql.ErrNoRows {
-		m.domainCheckRepo.Persist(result)
-		err = nil
-	} else if err != nil {
-		log.Fatalln(err.Error())
+		err = m.domainCheckRepo.PersistTx(tx, result)
+		if err != nil {
+			return
+		}
+		events = append(events, newEvent(EVENT_DOMAIN_FIRST_CHECKED, domain.Name, result, previousValid, nil))
+	} else if resultErr != nil {
+		err = resultErr
 		return
+	} else {
+		if lastResult.Equals(result) {
+			result = lastResult
+		} else {
+			err = m.domainCheckRepo.PersistTx(tx, result)
+			if err != nil {
+				return
+			}
+			events = append(events, newEvent(EVENT_CHECK_CHANGED, domain.Name, result, previousValid, lastResult))
+		}
+		if previousValid && !domain.Valid {
+			events = append(events, newEvent(EVENT_DOMAIN_BECAME_INVALID, domain.Name, result, previousValid, lastResult))
+		}
+		if !previousValid && domain.Valid {
+			events = append(events, newEvent(EVENT_DOMAIN_RECOVERED, domain.Name, result, previousValid, lastResult))
+		}
 	}
 
-	if !lastResult.Equals(result) {
-		err = m.domainCheckRepo.Persist(result)
+	for _, event := range events {
+		err = m.eventRepo.PersistTx(tx, event)
 		if err != nil {
-			log.Fatalln(err.Error())
 			return
 		}
 	}
+	completed = newEvent(EVENT_CHECK_COMPLETED, domain.Name, result, previousValid, lastResult)
+	return
+}
+
+func newEvent(eventType string, domain string, check *DomainCheck, previousValid bool, previousCheck *DomainCheck) (event *Event) {
+	event = new(Event)
+	event.Type = eventType
+	event.Domain = domain
+	event.Valid = check.Valid
+	event.PreviousValid = previousValid
+	event.CheckId = check.Id
+	event.Check = check
+	if previousCheck != nil {
+		event.PreviousCheckId = previousCheck.Id
+	}
 	return
 }
@@ -2,20 +2,24 @@ package hivdomainstatus
 
 import (
 	"database/sql"
-	"testing"
 	"net/url"
+	"strings"
+	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func SetupManagerTest(t *testing.T) (domainRepo *DomainRepository, domainCheckRepo *DomainCheckRepository) {
+func SetupManagerTest(t *testing.T) (db *sql.DB, domainRepo *DomainRepository, domainCheckRepo *DomainCheckRepository) {
 	c, configErr := NewConfig()
 	if configErr != nil {
 		t.Fatal(configErr)
 	}
-	db, _ := sql.Open("postgres", c.DSN())
+	db, _ = sql.Open("postgres", c.DSN())
 	db.Exec("TRUNCATE domain RESTART IDENTITY")
 	db.Exec("TRUNCATE domain_check RESTART IDENTITY")
+	db.Exec("TRUNCATE event RESTART IDENTITY")
+	db.Exec("TRUNCATE maintenance_window RESTART IDENTITY")
 	domainRepo = NewDomainRepository(db)
 	domainCheckRepo = NewDomainCheckRepository(db)
 	return
@@ -23,13 +27,13 @@ func SetupManagerTest(t *testing.T) (domainRepo *DomainRepository, domainCheckRe
 
 func TestThatItStoresResultForNewDomain(t *testing.T) {
 	assert := assert.New(t)
-	domainRepo, domainCheckRepo := SetupManagerTest(t)
+	db, domainRepo, domainCheckRepo := SetupManagerTest(t)
 
 	// New domain
 	r := new(DomainCheckResult)
 	r.Domain = "example.hiv"
 	r.URL, _ = url.Parse("http://example.hiv")
-	m := NewManager(domainRepo, domainCheckRepo)
+	m := NewManager(db, domainRepo, domainCheckRepo, NewEventRepository(db), NewMaintenanceWindowRepository(db))
 	err := m.OnCheckDomainResult(r)
 	assert.Nil(err)
 
@@ -58,7 +62,7 @@ func TestThatItStoresResultForNewDomain(t *testing.T) {
 
 func TestThatItStoresResultForExistingDomain(t *testing.T) {
 	assert := assert.New(t)
-	domainRepo, domainCheckRepo := SetupManagerTest(t)
+	db, domainRepo, domainCheckRepo := SetupManagerTest(t)
 
 	d := new(Domain)
 	d.Name = "example.hiv"
@@ -75,7 +79,7 @@ func TestThatItStoresResultForExistingDomain(t *testing.T) {
 	r.Domain = "example.hiv"
 	r.URL, _ = url.Parse("http://example.hiv")		
 	r.Valid = true
-	m := NewManager(domainRepo, domainCheckRepo)
+	m := NewManager(db, domainRepo, domainCheckRepo, NewEventRepository(db), NewMaintenanceWindowRepository(db))
 	err := m.OnCheckDomainResult(r)
 	assert.Nil(err)
 
@@ -104,4 +108,128 @@ func TestThatItStoresResultForExistingDomain(t *testing.T) {
 	assert.Equal(2, res2.Id)
 	assert.Equal("example.hiv", res2.Domain)
 	assert.True(res2.Valid)
-}
\ No newline at end of file
+}
+
+func TestThatItStoresNothingIfResultFails(t *testing.T) {
+	assert := assert.New(t)
+	db, domainRepo, domainCheckRepo := SetupManagerTest(t)
+	m := NewManager(db, domainRepo, domainCheckRepo, NewEventRepository(db), NewMaintenanceWindowRepository(db))
+
+	assert.NotNil(m.OnCheckDomainResult(nil))
+
+	// Name exceeds the column
+	r := new(DomainCheckResult)
+	r.Domain = strings.Repeat("x", 130) + ".hiv"
+	r.URL, _ = url.Parse("http://example.hiv")
+	assert.NotNil(m.OnCheckDomainResult(r))
+
+	domains, findErr := domainRepo.FindAll()
+	assert.Nil(findErr)
+	assert.Equal(0, len(domains))
+	results, resultsErr := domainCheckRepo.FindAll()
+	assert.Nil(resultsErr)
+	assert.Equal(0, len(results))
+}
+
+func TestThatItPublishesStatusTransitions(t *testing.T) {
+	assert := assert.New(t)
+	db, domainRepo, domainCheckRepo := SetupManagerTest(t)
+	eventRepo := NewEventRepository(db)
+	m := NewManager(db, domainRepo, domainCheckRepo, eventRepo, NewMaintenanceWindowRepository(db))
+
+	published := make([]string, 0)
+	completed := 0
+	m.Events.Subscribe(EVENT_ALL, func(event *Event) {
+		if event.Type == EVENT_CHECK_COMPLETED {
+			completed++
+			return
+		}
+		published = append(published, event.Type)
+	})
+	recovered := 0
+	m.Events.Subscribe(EVENT_DOMAIN_RECOVERED, func(event *Event) {
+		recovered++
+		assert.Equal("example.hiv", event.Domain)
+		assert.True(event.Valid)
+		assert.False(event.PreviousValid)
+	})
+
+	r := new(DomainCheckResult)
+	r.Domain = "example.hiv"
+	r.URL, _ = url.Parse("http://example.hiv")
+	r.Valid = true
+	assert.Nil(m.OnCheckDomainResult(r))
+	// Unchanged
+	assert.Nil(m.OnCheckDomainResult(r))
+	r.Valid = false
+	assert.Nil(m.OnCheckDomainResult(r))
+	r.Valid = true
+	assert.Nil(m.OnCheckDomainResult(r))
+
+	assert.Equal([]string{
+		EVENT_DOMAIN_FIRST_CHECKED,
+		EVENT_CHECK_CHANGED, EVENT_DOMAIN_BECAME_INVALID,
+		EVENT_CHECK_CHANGED, EVENT_DOMAIN_RECOVERED,
+	}, published)
+	assert.Equal(1, recovered)
+	assert.Equal(4, completed)
+
+	// Persisted for replay
+	events, findErr := eventRepo.FindAll()
+	assert.Nil(findErr)
+	assert.Equal(5, len(events))
+	assert.Equal(EVENT_DOMAIN_BECAME_INVALID, events[2].Type)
+	assert.Equal(int64(2), events[2].CheckId)
+	assert.Equal(int64(1), events[2].PreviousCheckId)
+
+	replay, replayErr := eventRepo.FindSince(events[2].Id, 10)
+	assert.Nil(replayErr)
+	assert.Equal(2, len(replay))
+}
+
+func TestThatItSuppressesTransitionsDuringMaintenance(t *testing.T) {
+	assert := assert.New(t)
+	db, domainRepo, domainCheckRepo := SetupManagerTest(t)
+	maintenanceRepo := NewMaintenanceWindowRepository(db)
+	m := NewManager(db, domainRepo, domainCheckRepo, NewEventRepository(db), maintenanceRepo)
+
+	published := make([]string, 0)
+	m.Events.Subscribe(EVENT_ALL, func(event *Event) {
+		if event.Type != EVENT_CHECK_COMPLETED {
+			published = append(published, event.Type)
+		}
+	})
+
+	r := new(DomainCheckResult)
+	r.Domain = "example.hiv"
+	r.URL, _ = url.Parse("http://example.hiv")
+	r.Valid = true
+	assert.Nil(m.OnCheckDomainResult(r))
+
+	domain, _ := domainRepo.FindByName("example.hiv")
+	starts := time.Now().Add(-time.Hour)
+	ends := time.Now().Add(time.Hour)
+	window := new(MaintenanceWindow)
+	window.DomainId = domain.Id
+	window.Starts = &starts
+	window.Ends = &ends
+	assert.Nil(maintenanceRepo.Persist(window))
+
+	// The failing check is stored, but the domain stays valid
+	r.Valid = false
+	assert.Nil(m.OnCheckDomainResult(r))
+	check, _ := domainCheckRepo.FindLatestByDomain("example.hiv")
+	assert.False(check.Valid)
+	assert.True(check.InMaintenance)
+	domain, _ = domainRepo.FindByName("example.hiv")
+	assert.True(domain.Valid)
+	assert.Equal([]string{EVENT_DOMAIN_FIRST_CHECKED, EVENT_CHECK_CHANGED}, published)
+
+	// After the window the transition is published
+	assert.Nil(maintenanceRepo.Remove(window))
+	assert.Nil(m.OnCheckDomainResult(r))
+	assert.Equal([]string{
+		EVENT_DOMAIN_FIRST_CHECKED, EVENT_CHECK_CHANGED,
+		EVENT_CHECK_CHANGED, EVENT_DOMAIN_BECAME_INVALID,
+	}, published)
+}
@@ -16,25 +16,198 @@ type DomainCheckListModel struct {
 
 type DomainCheckModel struct {
 	JsonLDTypedModel
-	Id             string     `json:"-"`
-	Domain         string     `json:"domain"`
+	Id             string                  `json:"-"`
+	Domain         string                  `json:"domain"`
+	DnsOK          bool                    `json:"dnsOk"`
+	Addresses      []string                `json:"addresses"`
+	URL            string                  `json:"url"`
+	StatusCode     int                     `json:"statusCode"`
+	ScriptPresent  bool                    `json:"scriptPresent"`
+	IframePresent  bool                    `json:"iframePresent"`
+	IframeTarget   string                  `json:"iframeTarget"`
+	IframeTargetOk bool                    `json:"iframeTargetOk"`
+	Valid          bool                    `json:"valid"`
+	Hosts          []*DomainCheckHostModel `json:"hosts"`
+	InMaintenance  bool                    `json:"inMaintenance"`
+	DomainLink     string                  `json:"domainLink,omitempty"`
+	Previous       string                  `json:"previous,omitempty"`
+	Next           string                  `json:"next,omitempty"`
+	Created        *time.Time              `json:"created"`
+}
+
+type DomainCheckHostModel struct {
+	Host          string `json:"host"`
+	URL           string `json:"url"`
+	StatusCode    int    `json:"statusCode"`
+	ScriptPresent bool   `json:"scriptPresent"`
+	Valid         bool   `json:"valid"`
+	Error         string `json:"error,omitempty"`
+}
+
+type DomainModel struct {
+	JsonLDTypedModel
+	Id          string                    `json:"-"`
+	Name        string                    `json:"name"`
+	Valid       bool                      `json:"valid"`
+	OwnerName   string                    `json:"ownerName"`
+	Contacts    []string                  `json:"contacts"`
+	Registrar   string                    `json:"registrar"`
+	Notes       string                    `json:"notes"`
+	ExternalRef string                    `json:"externalRef"`
+	Enabled     *bool                     `json:"enabled"`
+	Tags        []string                  `json:"tags"`
+	Maintenance []*MaintenanceWindowModel `json:"maintenance"`
+	Check       *DomainCheckModel         `json:"check"`
+	NextCheck   *time.Time                `json:"nextCheck"`
+	Created     *time.Time                `json:"created"`
+}
+
+type WebhookListModel struct {
+	JsonLDTypedModel
+	Items []*WebhookModel `json:"items"`
+	Total int             `json:"total"`
+}
+
+type WebhookModel struct {
+	JsonLDTypedModel
+	Id         string     `json:"-"`
+	URL        string     `json:"url"`
+	Secret     string     `json:"secret,omitempty"`
+	Events     []string   `json:"events"`
+	Deliveries string     `json:"deliveries,omitempty"`
+	Created    *time.Time `json:"created"`
+}
+
+type WebhookDeliveryListModel struct {
+	JsonLDTypedModel
+	Items []*WebhookDeliveryModel `json:"items"`
+	Total int                     `json:"total"`
+}
+
+type WebhookDeliveryModel struct {
+	JsonLDTypedModel
+	Id          string     `json:"-"`
+	Event       string     `json:"event"`
+	State       string     `json:"state"`
+	Attempts    int        `json:"attempts"`
+	NextAttempt *time.Time `json:"nextAttempt"`
+	StatusCode  int        `json:"statusCode"`
+	Error       string     `json:"error,omitempty"`
+	Created     *time.Time `json:"created"`
+	Delivered   *time.Time `json:"delivered"`
+}
+
+// Payload of events sent to webhooks
+type EventModel struct {
+	JsonLDTypedModel
+	Event         string            `json:"event"`
+	Domain        string            `json:"domain"`
+	Valid         bool              `json:"valid"`
+	PreviousValid bool              `json:"previousValid"`
+	Check         *DomainCheckModel `json:"check"`
+	Created       *time.Time        `json:"created"`
+}
+
+type TagListModel struct {
+	JsonLDTypedModel
+	Items []*TagModel `json:"items"`
+	Total int         `json:"total"`
+}
+
+type TagModel struct {
+	JsonLDTypedModel
+	Name    string     `json:"name"`
+	Domains int        `json:"domains"`
+	Created *time.Time `json:"created"`
+}
+
+type MaintenanceWindowListModel struct {
+	JsonLDTypedModel
+	Items []*MaintenanceWindowModel `json:"items"`
+	Total int                       `json:"total"`
+}
+
+type MaintenanceWindowModel struct {
+	JsonLDTypedModel
+	Starts  *time.Time `json:"starts"`
+	Ends    *time.Time `json:"ends"`
+	Reason  string     `json:"reason"`
+	Active  bool       `json:"active"`
+	Created *time.Time `json:"created"`
+}
+
+type CheckJobModel struct {
+	JsonLDTypedModel
+	Domain   string     `json:"domain"`
+	State    string     `json:"state"`
+	Attempts int        `json:"attempts"`
+	Check    string     `json:"check,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Created  *time.Time `json:"created"`
+	Finished *time.Time `json:"finished"`
+}
+
+type DomainImportModel struct {
+	JsonLDTypedModel
+	Committed bool                     `json:"committed"`
+	Created   int                      `json:"created"`
+	Existed   int                      `json:"existed"`
+	Rejected  int                      `json:"rejected"`
+	Items     []*DomainImportLineModel `json:"items"`
+}
+
+type DomainImportLineModel struct {
+	Line   int    `json:"line"`
+	Name   string `json:"name"`
+	State  string `json:"state"`
+	Reason string `json:"reason,omitempty"`
+	Domain string `json:"domain,omitempty"`
+}
+
+// A domain with its latest check flattened into one record
+type DomainExportModel struct {
+	Id             int64      `json:"id"`
+	Name           string     `json:"name"`
+	Valid          bool       `json:"valid"`
+	Enabled        bool       `json:"enabled"`
+	OwnerName      string     `json:"ownerName"`
+	Contacts       []string   `json:"contacts"`
+	Registrar      string     `json:"registrar"`
+	Notes          string     `json:"notes"`
+	ExternalRef    string     `json:"externalRef"`
+	Created        *time.Time `json:"created"`
+	CheckId        int64      `json:"checkId,omitempty"`
+	Checked        *time.Time `json:"checked"`
 	DnsOK          bool       `json:"dnsOk"`
-	Addresses      []string   `json:"addresses"`
 	URL            string     `json:"url"`
 	StatusCode     int        `json:"statusCode"`
 	ScriptPresent  bool       `json:"scriptPresent"`
 	IframePresent  bool       `json:"iframePresent"`
 	IframeTarget   string     `json:"iframeTarget"`
 	IframeTargetOk bool       `json:"iframeTargetOk"`
-	Valid          bool       `json:"valid"`
-	Created        *time.Time `json:"created"`
+	FailureReason  string     `json:"failureReason"`
+	InMaintenance  bool       `json:"inMaintenance"`
 }
 
-type DomainModel struct {
+type StatsModel struct {
 	JsonLDTypedModel
-	Id      string            `json:"-"`
-	Name    string            `json:"name"`
-	Valid   bool              `json:"valid"`
-	Check   *DomainCheckModel `json:"check"`
-	Created *time.Time        `json:"created"`
+	Domains        int                   `json:"domains"`
+	Valid          int                   `json:"valid"`
+	Invalid        int                   `json:"invalid"`
+	Disabled       int                   `json:"disabled"`
+	NeverChecked   int                   `json:"neverChecked"`
+	DnsOK          int                   `json:"dnsOk"`
+	ScriptPresent  int                   `json:"scriptPresent"`
+	IframePresent  int                   `json:"iframePresent"`
+	HttpsOK        int                   `json:"httpsOk"`
+	FailureReasons map[string]int        `json:"failureReasons"`
+	Daily          []*DailyValidityModel `json:"daily"`
+}
+
+type DailyValidityModel struct {
+	Day     string `json:"day"`
+	Checked int    `json:"checked"`
+	Valid   int    `json:"valid"`
+	// Percentage of the checked domains, null if none had been checked
+	ValidPercent *float64 `json:"validPercent"`
 }
@@ -0,0 +1,337 @@
+package hivdomainstatus
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Subject and body of the mails, per notification kind
+var notificationTemplates = map[string]*template.Template{
+	NOTIFICATION_KIND_FAILURE: template.Must(template.New(NOTIFICATION_KIND_FAILURE).Parse(`Subject: Your domain {{.Domain}} is not working
+
+Hello,
+
+our monitoring found that your domain {{.Domain}} is not working correctly.
+
+Please make sure that {{.Url}} resolves, responds successfully and
+contains the click4life script.
+
+We will check the domain again and let you know when it works.
+`)),
+	NOTIFICATION_KIND_FOLLOW_UP: template.Must(template.New(NOTIFICATION_KIND_FOLLOW_UP).Parse(`Subject: Your domain {{.Domain}} is still not working
+
+Hello,
+
+your domain {{.Domain}} is still not working correctly.
+
+Please make sure that {{.Url}} resolves, responds successfully and
+contains the click4life script.
+`)),
+	NOTIFICATION_KIND_RECOVERY: template.Must(template.New(NOTIFICATION_KIND_RECOVERY).Parse(`Subject: Your domain {{.Domain}} works again
+
+Hello,
+
+your domain {{.Domain}} works correctly again. Thank you!
+`)),
+}
+
+// Values available in notification templates
+type notificationTemplateData struct {
+	Domain         string
+	Url            string
+	UnsubscribeUrl string
+}
+
+// Mails the contacts of a domain when it fails, follows up after the grace period
+// and mails again when the domain recovers.
+// Notifications are queued in the database and sent by Run.
+type Notifier struct {
+	domainRepo       DomainRepositoryInterface
+	notificationRepo NotificationRepositoryInterface
+	unsubscribeRepo  UnsubscribeRepositoryInterface
+	addr             string
+	auth             smtp.Auth
+	from             string
+	secret           string
+	baseUrl          string
+	gracePeriod      time.Duration
+	rateLimit        int
+	recipientLimit   int
+	lease            time.Duration
+	pollInterval     time.Duration
+	sendMail         func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func NewNotifier(c *Config, domainRepo DomainRepositoryInterface, notificationRepo NotificationRepositoryInterface, unsubscribeRepo UnsubscribeRepositoryInterface) (n *Notifier, err error) {
+	n = new(Notifier)
+	n.domainRepo = domainRepo
+	n.notificationRepo = notificationRepo
+	n.unsubscribeRepo = unsubscribeRepo
+	n.addr = fmt.Sprintf("%s:%d", c.Smtp.Host, c.Smtp.Port)
+	if len(c.Smtp.Username) > 0 {
+		n.auth = smtp.PlainAuth("", c.Smtp.Username, c.Smtp.Password, c.Smtp.Host)
+	}
+	n.from = c.Smtp.From
+	if len(n.from) == 0 {
+		err = fmt.Errorf("No smtp sender address configured")
+		return
+	}
+	n.secret = c.Smtp.Secret
+	if len(n.secret) == 0 {
+		err = fmt.Errorf("No smtp secret for unsubscribe links configured")
+		return
+	}
+	n.baseUrl = c.BaseUrl()
+	n.gracePeriod, err = time.ParseDuration(c.Smtp.GracePeriod)
+	if err != nil {
+		return
+	}
+	n.rateLimit = c.Smtp.RateLimit
+	n.recipientLimit = c.Smtp.RecipientLimit
+	n.lease, err = time.ParseDuration(c.Smtp.Lease)
+	if err != nil {
+		return
+	}
+	n.pollInterval, err = time.ParseDuration(c.Scheduler.PollInterval)
+	n.sendMail = smtp.SendMail
+	return
+}
+
+// Returns the token which authorizes unsubscribing email
+func UnsubscribeToken(secret string, email string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.ToLower(email)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Checks the token of an unsubscribe link
+func VerifyUnsubscribeToken(secret string, email string, token string) bool {
+	return hmac.Equal([]byte(UnsubscribeToken(secret, email)), []byte(token))
+}
+
+// Queues notifications for the events of bus
+func (n *Notifier) Subscribe(bus *EventBus) {
+	bus.Subscribe(EVENT_DOMAIN_FIRST_CHECKED, n.OnEvent)
+	bus.Subscribe(EVENT_DOMAIN_BECAME_INVALID, n.OnEvent)
+	bus.Subscribe(EVENT_DOMAIN_RECOVERED, n.OnEvent)
+}
+
+func (n *Notifier) OnEvent(event *Event) {
+	if event.Check != nil && event.Check.InMaintenance {
+		return
+	}
+	var err error
+	switch event.Type {
+	case EVENT_DOMAIN_FIRST_CHECKED:
+		if !event.Valid {
+			err = n.queueFailure(event.Domain)
+		}
+	case EVENT_DOMAIN_BECAME_INVALID:
+		err = n.queueFailure(event.Domain)
+	case EVENT_DOMAIN_RECOVERED:
+		err = n.queueRecovery(event.Domain)
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to queue notification for %s: %s\n", event.Domain, err.Error())
+	}
+}
+
+func (n *Notifier) queueFailure(domainName string) (err error) {
+	domain, err := n.domainRepo.FindByName(domainName)
+	if err != nil {
+		return
+	}
+	for _, contact := range domain.Contacts {
+		err = n.queue(domain.Name, contact, NOTIFICATION_KIND_FAILURE)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// A recovery mail is only sent to contacts which have been told about the failure
+func (n *Notifier) queueRecovery(domainName string) (err error) {
+	latest, err := n.notificationRepo.FindLatestByDomain(domainName)
+	if err != nil {
+		return
+	}
+	for _, notification := range latest {
+		if notification.Kind == NOTIFICATION_KIND_RECOVERY {
+			continue
+		}
+		if notification.State == NOTIFICATION_STATE_PENDING {
+			notification.State = NOTIFICATION_STATE_SKIPPED
+			notification.Error = "Domain recovered"
+			err = n.notificationRepo.Persist(notification)
+		} else if notification.State == NOTIFICATION_STATE_SENT || notification.State == NOTIFICATION_STATE_SENDING {
+			err = n.queue(domainName, notification.Email, NOTIFICATION_KIND_RECOVERY)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (n *Notifier) queue(domain string, email string, kind string) (err error) {
+	notification := new(Notification)
+	notification.Domain = domain
+	notification.Email = strings.ToLower(email)
+	notification.Kind = kind
+	err = n.notificationRepo.Persist(notification)
+	return
+}
+
+// Queues and sends notifications until stop is closed
+func (n *Notifier) Run(stop <-chan bool) {
+	for {
+		_, err := n.QueueFollowUps()
+		if err != nil {
+			log.Printf("ERROR: Failed to queue follow-ups: %s\n", err.Error())
+		}
+		_, err = n.SendPending()
+		if err != nil {
+			log.Printf("ERROR: Failed to send notifications: %s\n", err.Error())
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(n.pollInterval):
+		}
+	}
+}
+
+// Queues a follow-up for domains which still fail after the grace period
+func (n *Notifier) QueueFollowUps() (count int, err error) {
+	due, err := n.notificationRepo.FindDueFollowUps(n.gracePeriod)
+	if err != nil {
+		return
+	}
+	for _, failure := range due {
+		err = n.queue(failure.Domain, failure.Email, NOTIFICATION_KIND_FOLLOW_UP)
+		if err != nil {
+			return
+		}
+		count++
+	}
+	return
+}
+
+// Sends pending notifications until none is left or the hourly rate limit is reached
+func (n *Notifier) SendPending() (sent int, err error) {
+	requeued, err := n.notificationRepo.RequeueExpired()
+	if err != nil {
+		return
+	}
+	if requeued > 0 {
+		log.Printf("Requeued %d notifications with expired lease\n", requeued)
+	}
+	for {
+		var sentLastHour int
+		sentLastHour, err = n.notificationRepo.CountSentSince(time.Now().Add(-time.Hour))
+		if err != nil {
+			return
+		}
+		if sentLastHour >= n.rateLimit {
+			return
+		}
+		var notification *Notification
+		notification, err = n.notificationRepo.ClaimPending(n.lease)
+		if err == sql.ErrNoRows {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		err = n.send(notification)
+		if err != nil {
+			return
+		}
+		if notification.State == NOTIFICATION_STATE_SENT {
+			sent++
+		}
+	}
+}
+
+// Sends a claimed notification, unless the recipient unsubscribed or got too many mails today
+func (n *Notifier) send(notification *Notification) (err error) {
+	unsubscribed, err := n.unsubscribeRepo.IsUnsubscribed(notification.Email)
+	if err != nil {
+		return
+	}
+	sentToday, err := n.notificationRepo.CountSentToSince(notification.Email, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		return
+	}
+	if unsubscribed {
+		notification.State = NOTIFICATION_STATE_SKIPPED
+		notification.Error = "Unsubscribed"
+	} else if sentToday >= n.recipientLimit {
+		notification.State = NOTIFICATION_STATE_SKIPPED
+		notification.Error = "Recipient limit reached"
+	} else {
+		msg, msgErr := n.Message(notification)
+		if msgErr == nil {
+			msgErr = n.sendMail(n.addr, n.auth, n.from, []string{notification.Email}, msg)
+		}
+		if msgErr != nil {
+			notification.State = NOTIFICATION_STATE_FAILED
+			notification.Error = msgErr.Error()
+			log.Printf("ERROR: Failed to notify %s about %s: %s\n", notification.Email, notification.Domain, msgErr.Error())
+		} else {
+			now := time.Now()
+			notification.State = NOTIFICATION_STATE_SENT
+			notification.Sent = &now
+		}
+	}
+	err = n.notificationRepo.Persist(notification)
+	return
+}
+
+// Renders the mail for notification, including headers
+func (n *Notifier) Message(notification *Notification) (msg []byte, err error) {
+	tpl, ok := notificationTemplates[notification.Kind]
+	if !ok {
+		err = fmt.Errorf("Unknown notification kind: %s", notification.Kind)
+		return
+	}
+	data := new(notificationTemplateData)
+	data.Domain = notification.Domain
+	data.Url = "http://" + notification.Domain + "/"
+	data.UnsubscribeUrl = fmt.Sprintf("%s/unsubscribe?email=%s&token=%s", n.baseUrl, url.QueryEscape(notification.Email), UnsubscribeToken(n.secret, notification.Email))
+	var body bytes.Buffer
+	err = tpl.Execute(&body, data)
+	if err != nil {
+		return
+	}
+	// The first line of the template is the subject header
+	parts := strings.SplitN(body.String(), "\n\n", 2)
+	var b bytes.Buffer
+	b.WriteString("From: " + n.from + "\r\n")
+	b.WriteString("To: " + notification.Email + "\r\n")
+	b.WriteString(parts[0] + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("List-Unsubscribe: <" + data.UnsubscribeUrl + ">\r\n")
+	b.WriteString("List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
+	b.WriteString("\r\n")
+	if len(parts) > 1 {
+		b.WriteString(strings.Replace(parts[1], "\n", "\r\n", -1))
+	}
+	b.WriteString("\r\n-- \r\nTo stop receiving these mails visit " + data.UnsubscribeUrl + "\r\n")
+	msg = b.Bytes()
+	return
+}
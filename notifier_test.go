@@ -0,0 +1,322 @@
+package hivdomainstatus
+
+import (
+	"bufio"
+	"database/sql"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Minimal SMTP server which records the received mails
+type fakeSmtpServer struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	Mails    []*fakeMail
+}
+
+type fakeMail struct {
+	From string
+	To   []string
+	Data string
+}
+
+func newFakeSmtpServer(t *testing.T) (s *fakeSmtpServer) {
+	s = new(fakeSmtpServer)
+	var err error
+	s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return
+}
+
+func (s *fakeSmtpServer) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSmtpServer) Close() {
+	s.listener.Close()
+}
+
+func (s *fakeSmtpServer) Received() []*fakeMail {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*fakeMail{}, s.Mails...)
+}
+
+func (s *fakeSmtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+	reply("220 localhost ESMTP")
+	mail := new(fakeMail)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			mail.From = strings.Trim(strings.TrimSpace(line)[10:], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			mail.To = append(mail.To, strings.Trim(strings.TrimSpace(line)[8:], "<>"))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data []string
+			for {
+				dataLine, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data = append(data, dataLine)
+			}
+			mail.Data = strings.Join(data, "")
+			s.mutex.Lock()
+			s.Mails = append(s.Mails, mail)
+			s.mutex.Unlock()
+			mail = new(fakeMail)
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func newNotifierTestConfig(t *testing.T, smtpServer *fakeSmtpServer) (c *Config) {
+	c, configErr := NewConfig()
+	if configErr != nil {
+		t.Fatal(configErr)
+	}
+	c.Server.Url = "https://status.example.com"
+	c.Smtp.Enabled = true
+	c.Smtp.Host = "127.0.0.1"
+	c.Smtp.Port = smtpServer.Port()
+	c.Smtp.From = "status@click4life.hiv"
+	c.Smtp.Secret = "s3cr3t"
+	return
+}
+
+func SetupNotifierTest(t *testing.T, smtpServer *fakeSmtpServer) (db *sql.DB, domainRepo *DomainRepository, notificationRepo *NotificationRepository, unsubscribeRepo *UnsubscribeRepository, notifier *Notifier) {
+	c := newNotifierTestConfig(t, smtpServer)
+	db, _ = sql.Open("postgres", c.DSN())
+	db.Exec("TRUNCATE domain RESTART IDENTITY")
+	db.Exec("TRUNCATE notification RESTART IDENTITY")
+	db.Exec("TRUNCATE unsubscribe RESTART IDENTITY")
+	domainRepo = NewDomainRepository(db)
+	notificationRepo = NewNotificationRepository(db)
+	unsubscribeRepo = NewUnsubscribeRepository(db)
+	notifier, err := NewNotifier(c, domainRepo, notificationRepo, unsubscribeRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := new(Domain)
+	d.Name = "example.hiv"
+	d.Contacts = []string{"Owner@example.com"}
+	domainRepo.Persist(d)
+	return
+}
+
+func TestThatItVerifiesUnsubscribeTokens(t *testing.T) {
+	assert := assert.New(t)
+	token := UnsubscribeToken("s3cr3t", "owner@example.com")
+	assert.True(VerifyUnsubscribeToken("s3cr3t", "Owner@Example.com", token))
+	assert.False(VerifyUnsubscribeToken("other", "owner@example.com", token))
+	assert.False(VerifyUnsubscribeToken("s3cr3t", "someone@example.com", token))
+}
+
+func TestThatItSendsTemplatedMailsViaSmtp(t *testing.T) {
+	assert := assert.New(t)
+	smtpServer := newFakeSmtpServer(t)
+	defer smtpServer.Close()
+
+	notifier, err := NewNotifier(newNotifierTestConfig(t, smtpServer), nil, nil, nil)
+	assert.Nil(err)
+	notification := new(Notification)
+	notification.Domain = "example.hiv"
+	notification.Email = "owner@example.com"
+	notification.Kind = NOTIFICATION_KIND_FAILURE
+	msg, err := notifier.Message(notification)
+	assert.Nil(err)
+	assert.Nil(notifier.sendMail(notifier.addr, notifier.auth, notifier.from, []string{notification.Email}, msg))
+
+	mails := smtpServer.Received()
+	assert.Equal(1, len(mails))
+	assert.Equal("status@click4life.hiv", mails[0].From)
+	assert.Equal([]string{"owner@example.com"}, mails[0].To)
+	assert.Contains(mails[0].Data, "Subject: Your domain example.hiv is not working\r\n")
+	assert.Contains(mails[0].Data, "http://example.hiv/")
+	assert.Contains(mails[0].Data, "List-Unsubscribe: <https://status.example.com/unsubscribe?email=owner%40example.com&token="+UnsubscribeToken("s3cr3t", "owner@example.com")+">")
+}
+
+func TestThatItNotifiesAboutFailureAndRecovery(t *testing.T) {
+	assert := assert.New(t)
+	smtpServer := newFakeSmtpServer(t)
+	defer smtpServer.Close()
+	_, domainRepo, notificationRepo, _, notifier := SetupNotifierTest(t, smtpServer)
+	d, _ := domainRepo.FindByName("example.hiv")
+	d.Contacts = append(d.Contacts, "admin@example.com")
+	assert.Nil(domainRepo.Persist(d))
+
+	check := new(DomainCheck)
+	notifier.OnEvent(newEvent(EVENT_DOMAIN_BECAME_INVALID, "example.hiv", check, true, nil))
+	sent, err := notifier.SendPending()
+	assert.Nil(err)
+	assert.Equal(2, sent)
+
+	check.Valid = true
+	notifier.OnEvent(newEvent(EVENT_DOMAIN_RECOVERED, "example.hiv", check, false, nil))
+	sent, err = notifier.SendPending()
+	assert.Nil(err)
+	assert.Equal(2, sent)
+
+	mails := smtpServer.Received()
+	assert.Equal(4, len(mails))
+	assert.Equal([]string{"owner@example.com"}, mails[0].To)
+	assert.Contains(mails[0].Data, "is not working")
+	assert.Equal([]string{"admin@example.com"}, mails[1].To)
+	assert.Contains(mails[2].Data, "works again")
+	assert.Contains(mails[3].Data, "works again")
+
+	notifications, _ := notificationRepo.FindAll()
+	assert.Equal(NOTIFICATION_KIND_FAILURE, notifications[0].Kind)
+	assert.Equal(NOTIFICATION_STATE_SENT, notifications[0].State)
+	assert.NotNil(notifications[0].Sent)
+	assert.Equal(NOTIFICATION_KIND_RECOVERY, notifications[2].Kind)
+	assert.Equal(NOTIFICATION_KIND_RECOVERY, notifications[3].Kind)
+}
+
+func TestThatItResendsNotificationsWithExpiredLease(t *testing.T) {
+	assert := assert.New(t)
+	smtpServer := newFakeSmtpServer(t)
+	defer smtpServer.Close()
+	db, _, notificationRepo, _, notifier := SetupNotifierTest(t, smtpServer)
+
+	notifier.OnEvent(newEvent(EVENT_DOMAIN_BECAME_INVALID, "example.hiv", new(DomainCheck), true, nil))
+	// A notifier which died while sending
+	claimed, err := notificationRepo.ClaimPending(time.Minute)
+	assert.Nil(err)
+	assert.Equal(NOTIFICATION_STATE_SENDING, claimed.State)
+
+	sent, err := notifier.SendPending()
+	assert.Nil(err)
+	assert.Equal(0, sent)
+
+	db.Exec("UPDATE notification SET lease_until = now() - interval '1 second'")
+	sent, err = notifier.SendPending()
+	assert.Nil(err)
+	assert.Equal(1, sent)
+	notifications, _ := notificationRepo.FindAll()
+	assert.Equal(NOTIFICATION_STATE_SENT, notifications[0].State)
+	assert.Equal(1, len(smtpServer.Received()))
+}
+
+func TestThatItFollowsUpAfterGracePeriod(t *testing.T) {
+	assert := assert.New(t)
+	smtpServer := newFakeSmtpServer(t)
+	defer smtpServer.Close()
+	db, _, notificationRepo, _, notifier := SetupNotifierTest(t, smtpServer)
+
+	notifier.OnEvent(newEvent(EVENT_DOMAIN_BECAME_INVALID, "example.hiv", new(DomainCheck), true, nil))
+	notifier.SendPending()
+
+	// Not yet due
+	count, err := notifier.QueueFollowUps()
+	assert.Nil(err)
+	assert.Equal(0, count)
+
+	db.Exec("UPDATE notification SET sent = now() - interval '73 hours'")
+	count, err = notifier.QueueFollowUps()
+	assert.Nil(err)
+	assert.Equal(1, count)
+	// Only one follow-up
+	count, _ = notifier.QueueFollowUps()
+	assert.Equal(0, count)
+
+	notifier.SendPending()
+	mails := smtpServer.Received()
+	assert.Equal(2, len(mails))
+	assert.Contains(mails[1].Data, "is still not working")
+	notifications, _ := notificationRepo.FindAll()
+	assert.Equal(NOTIFICATION_KIND_FOLLOW_UP, notifications[1].Kind)
+}
+
+func TestThatItRespectsUnsubscribeAndRateLimits(t *testing.T) {
+	assert := assert.New(t)
+	smtpServer := newFakeSmtpServer(t)
+	defer smtpServer.Close()
+	_, domainRepo, notificationRepo, unsubscribeRepo, notifier := SetupNotifierTest(t, smtpServer)
+
+	d := new(Domain)
+	d.Name = "acme.hiv"
+	d.Contacts = []string{"acme@example.com"}
+	domainRepo.Persist(d)
+	assert.Nil(unsubscribeRepo.Unsubscribe("Owner@example.com"))
+
+	notifier.OnEvent(newEvent(EVENT_DOMAIN_BECAME_INVALID, "example.hiv", new(DomainCheck), true, nil))
+	sent, err := notifier.SendPending()
+	assert.Nil(err)
+	assert.Equal(0, sent)
+	notifications, _ := notificationRepo.FindAll()
+	assert.Equal(NOTIFICATION_STATE_SKIPPED, notifications[0].State)
+
+	// Hourly limit keeps further notifications pending
+	notifier.rateLimit = 1
+	notifier.OnEvent(newEvent(EVENT_DOMAIN_FIRST_CHECKED, "acme.hiv", new(DomainCheck), false, nil))
+	notifier.OnEvent(newEvent(EVENT_DOMAIN_BECAME_INVALID, "acme.hiv", new(DomainCheck), true, nil))
+	sent, _ = notifier.SendPending()
+	assert.Equal(1, sent)
+	notifications, _ = notificationRepo.FindAll()
+	assert.Equal(NOTIFICATION_STATE_SENT, notifications[1].State)
+	assert.Equal(NOTIFICATION_STATE_PENDING, notifications[2].State)
+
+	// Recipient limit skips the notification
+	notifier.rateLimit = 100
+	notifier.recipientLimit = 1
+	sent, _ = notifier.SendPending()
+	assert.Equal(0, sent)
+	notifications, _ = notificationRepo.FindAll()
+	assert.Equal(NOTIFICATION_STATE_SKIPPED, notifications[2].State)
+	assert.Equal(1, len(smtpServer.Received()))
+}
+
+func TestThatItIgnoresChecksDuringMaintenance(t *testing.T) {
+	assert := assert.New(t)
+	smtpServer := newFakeSmtpServer(t)
+	defer smtpServer.Close()
+	_, _, notificationRepo, _, notifier := SetupNotifierTest(t, smtpServer)
+
+	check := new(DomainCheck)
+	check.InMaintenance = true
+	notifier.OnEvent(newEvent(EVENT_DOMAIN_FIRST_CHECKED, "example.hiv", check, false, nil))
+	notifications, err := notificationRepo.FindAll()
+	assert.Nil(err)
+	assert.Equal(0, len(notifications))
+}
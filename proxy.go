@@ -0,0 +1,109 @@
+package hivdomainstatus
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Selects the outbound proxy for crawler requests
+type ProxySelector struct {
+	proxyURL *url.URL
+	noProxy  []string
+}
+
+// Creates a ProxySelector from the [proxy] section of the config.
+// Without a configured proxy url all requests go out directly.
+func NewProxySelector(c *Config) (s *ProxySelector, err error) {
+	s = new(ProxySelector)
+	if len(c.Proxy.Url) > 0 {
+		s.proxyURL, err = url.Parse(c.Proxy.Url)
+		if err != nil {
+			return
+		}
+		switch s.proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			err = fmt.Errorf("Unsupported proxy scheme: %s", s.proxyURL.Scheme)
+			return
+		}
+		if len(c.Proxy.Username) > 0 {
+			if len(c.Proxy.Password) > 0 {
+				s.proxyURL.User = url.UserPassword(c.Proxy.Username, c.Proxy.Password)
+			} else {
+				s.proxyURL.User = url.User(c.Proxy.Username)
+			}
+		}
+	}
+	for _, entry := range strings.Split(c.Proxy.NoProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if len(entry) > 0 {
+			s.noProxy = append(s.noProxy, entry)
+		}
+	}
+	return
+}
+
+// Returns the proxy to use for the given url, nil means direct
+func (s *ProxySelector) ProxyFor(u *url.URL) *url.URL {
+	if s == nil || s.proxyURL == nil {
+		return nil
+	}
+	if s.bypass(u.Host) {
+		return nil
+	}
+	return s.proxyURL
+}
+
+// Implements the Proxy func of http.Transport
+func (s *ProxySelector) Proxy(req *http.Request) (*url.URL, error) {
+	return s.ProxyFor(req.URL), nil
+}
+
+// Describes the egress path for the given url, credentials are not included
+func (s *ProxySelector) Via(u *url.URL) string {
+	proxyURL := s.ProxyFor(u)
+	if proxyURL == nil {
+		return "direct"
+	}
+	via := *proxyURL
+	if via.User != nil {
+		via.User = url.User(via.User.Username())
+	}
+	return via.String()
+}
+
+// Checks if host matches an entry of the no-proxy list
+func (s *ProxySelector) bypass(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	ip := net.ParseIP(host)
+	for _, entry := range s.noProxy {
+		if entry == "*" {
+			return true
+		}
+		if ip != nil {
+			if _, network, cidrErr := net.ParseCIDR(entry); cidrErr == nil {
+				if network.Contains(ip) {
+					return true
+				}
+				continue
+			}
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.TrimPrefix(strings.Trim(entry, "[]"), "*")
+		if host == strings.TrimPrefix(entry, ".") {
+			return true
+		}
+		if strings.HasSuffix(host, "."+strings.TrimPrefix(entry, ".")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package hivdomainstatus
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test for the crawler proxy selection
+
+func TestThatItSelectsProxy(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewDefaultConfig()
+	c.Proxy.Url = "socks5://proxy.example.com:1080"
+	c.Proxy.Username = "crawler"
+	c.Proxy.Password = "secret"
+	c.Proxy.NoProxy = "localhost, .internal.example.com,10.0.0.0/8"
+	s, err := NewProxySelector(c)
+	assert.Nil(err)
+
+	target, _ := url.Parse("http://www.example.hiv/")
+	proxyURL := s.ProxyFor(target)
+	assert.NotNil(proxyURL)
+	assert.Equal("socks5", proxyURL.Scheme)
+	assert.Equal("proxy.example.com:1080", proxyURL.Host)
+	password, _ := proxyURL.User.Password()
+	assert.Equal("crawler", proxyURL.User.Username())
+	assert.Equal("secret", password)
+	assert.Equal("socks5://crawler@proxy.example.com:1080", s.Via(target))
+
+	for _, direct := range []string{"http://localhost:8080/", "http://app.internal.example.com/", "http://internal.example.com/", "http://10.1.2.3/"} {
+		u, _ := url.Parse(direct)
+		assert.Nil(s.ProxyFor(u), direct)
+		assert.Equal("direct", s.Via(u), direct)
+	}
+}
+
+func TestThatItGoesDirectWithoutProxy(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := NewProxySelector(NewDefaultConfig())
+	assert.Nil(err)
+	target, _ := url.Parse("http://www.example.hiv/")
+	assert.Nil(s.ProxyFor(target))
+	assert.Equal("direct", s.Via(target))
+
+	var none *ProxySelector
+	assert.Equal("direct", none.Via(target))
+}
+
+func TestThatItRejectsUnsupportedProxyScheme(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewDefaultConfig()
+	c.Proxy.Url = "ftp://proxy.example.com"
+	_, err := NewProxySelector(c)
+	assert.NotNil(err)
+}
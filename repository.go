@@ -0,0 +1,42 @@
+package hivdomainstatus
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// Implemented by *sql.DB and *sql.Tx, so repositories can run the same
+// queries inside and outside of a transaction
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Scans the columns selected after the ones of an entity, so its scan can be reused
+type extraColumns struct {
+	row  rowScanner
+	dest []interface{}
+}
+
+func (r *extraColumns) Scan(dest ...interface{}) error {
+	return r.row.Scan(append(dest, r.dest...)...)
+}
+
+// Stores unset ids (0) as NULL
+func nullId(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id > 0}
+}
+
+// Joins conditions to a WHERE clause, returns an empty string for no conditions
+func whereClause(where []string) string {
+	if len(where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(where, " AND ")
+}
@@ -0,0 +1,96 @@
+package hivdomainstatus
+
+import (
+	"database/sql"
+
+	_ "github.com/lib/pq"
+)
+
+type ApiKeyRepositoryInterface interface {
+	Persist(key *ApiKey) (err error)
+	Revoke(key *ApiKey) (err error)
+	Touch(key *ApiKey) (err error)
+	FindAll() (keys []*ApiKey, err error)
+	FindById(id int64) (key *ApiKey, err error)
+	FindByHash(hash string) (key *ApiKey, err error)
+}
+
+type ApiKeyRepository struct {
+	ApiKeyRepositoryInterface
+	db            *sql.DB
+	TABLE_NAME    string
+	ID_FIELD      string
+	FIELDS        string
+	CREATED_FIELD string
+}
+
+func NewApiKeyRepository(db *sql.DB) (repo *ApiKeyRepository) {
+	repo = new(ApiKeyRepository)
+	repo.db = db
+	repo.TABLE_NAME = "api_key"
+	repo.FIELDS = "name, prefix, key_hash, scope, last_used, revoked"
+	repo.ID_FIELD = "id"
+	repo.CREATED_FIELD = "created"
+	return
+}
+
+func (repo *ApiKeyRepository) Persist(key *ApiKey) (err error) {
+	err = repo.db.QueryRow("INSERT INTO "+repo.TABLE_NAME+" "+
+		"(name, prefix, key_hash, scope) "+
+		"VALUES($1, $2, $3, $4) RETURNING id, created",
+		key.Name, key.Prefix, key.KeyHash, key.Scope).Scan(&key.Id, &key.Created)
+	return
+}
+
+// Marks the key as revoked, it can no longer be used
+func (repo *ApiKeyRepository) Revoke(key *ApiKey) (err error) {
+	err = repo.db.QueryRow("UPDATE "+repo.TABLE_NAME+" "+
+		"SET revoked = COALESCE(revoked, now()) WHERE "+repo.ID_FIELD+" = $1 RETURNING revoked",
+		key.Id).Scan(&key.Revoked)
+	return
+}
+
+// Records the use of the key
+func (repo *ApiKeyRepository) Touch(key *ApiKey) (err error) {
+	err = repo.db.QueryRow("UPDATE "+repo.TABLE_NAME+" "+
+		"SET last_used = now() WHERE "+repo.ID_FIELD+" = $1 RETURNING last_used",
+		key.Id).Scan(&key.LastUsed)
+	return
+}
+
+func (repo *ApiKeyRepository) scan(row rowScanner, key *ApiKey) (err error) {
+	err = row.Scan(&key.Id, &key.Name, &key.Prefix, &key.KeyHash, &key.Scope, &key.LastUsed, &key.Revoked, &key.Created)
+	return
+}
+
+func (repo *ApiKeyRepository) FindAll() (keys []*ApiKey, err error) {
+	rows, err := repo.db.Query("SELECT " + repo.ID_FIELD + "," + repo.FIELDS + "," + repo.CREATED_FIELD + " FROM " + repo.TABLE_NAME + " ORDER BY " + repo.ID_FIELD)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	keys = make([]*ApiKey, 0)
+	for rows.Next() {
+		key := new(ApiKey)
+		err = repo.scan(rows, key)
+		if err != nil {
+			return
+		}
+		keys = append(keys, key)
+	}
+	err = rows.Err()
+	return
+}
+
+func (repo *ApiKeyRepository) FindById(id int64) (key *ApiKey, err error) {
+	key = new(ApiKey)
+	err = repo.scan(repo.db.QueryRow("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" WHERE "+repo.ID_FIELD+" = $1", id), key)
+	return
+}
+
+// Finds a key by its hash, revoked keys are returned, too
+func (repo *ApiKeyRepository) FindByHash(hash string) (key *ApiKey, err error) {
+	key = new(ApiKey)
+	err = repo.scan(repo.db.QueryRow("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" WHERE key_hash = $1", hash), key)
+	return
+}
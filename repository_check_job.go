@@ -0,0 +1,177 @@
+package hivdomainstatus
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type CheckJobRepositoryInterface interface {
+	Enqueue(domain string) (job *CheckJob, err error)
+	EnqueueAll(tag string) (count int, err error)
+	Claim(worker string, lease time.Duration) (job *CheckJob, err error)
+	Heartbeat(job *CheckJob, lease time.Duration) (err error)
+	Complete(job *CheckJob) (err error)
+	RequeueExpired(maxAttempts int) (count int, err error)
+	FindById(id int64) (job *CheckJob, err error)
+	FindAll() (jobs []*CheckJob, err error)
+}
+
+type CheckJobRepository struct {
+	CheckJobRepositoryInterface
+	db            *sql.DB
+	TABLE_NAME    string
+	ID_FIELD      string
+	FIELDS        string
+	CREATED_FIELD string
+}
+
+func NewCheckJobRepository(db *sql.DB) (repo *CheckJobRepository) {
+	repo = new(CheckJobRepository)
+	repo.db = db
+	repo.TABLE_NAME = "check_job"
+	repo.FIELDS = "domain, state, worker, attempts, lease_until, heartbeat, check_id, error, finished"
+	repo.ID_FIELD = "id"
+	repo.CREATED_FIELD = "created"
+	return
+}
+
+// Returned by Heartbeat and Complete if the lease of the job has been taken over by someone else
+var ErrLeaseLost = fmt.Errorf("Lease of job has been lost")
+
+func (repo *CheckJobRepository) scan(row rowScanner, job *CheckJob) (err error) {
+	var checkId sql.NullInt64
+	err = row.Scan(&job.Id, &job.Domain, &job.State, &job.Worker, &job.Attempts, &job.LeaseUntil, &job.Heartbeat, &checkId, &job.Error, &job.Finished, &job.Created)
+	job.CheckId = checkId.Int64
+	return
+}
+
+// Returns true if err is a violated unique constraint
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code.Name() == "unique_violation"
+}
+
+// Queues a check of domain, if the domain already has an open job that one is returned
+func (repo *CheckJobRepository) Enqueue(domain string) (job *CheckJob, err error) {
+	job = new(CheckJob)
+	row := repo.db.QueryRow("INSERT INTO "+repo.TABLE_NAME+" (domain) "+
+		"SELECT $1 WHERE NOT EXISTS (SELECT 1 FROM "+repo.TABLE_NAME+" WHERE domain = $1 AND state IN ($2, $3)) "+
+		"RETURNING "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD, domain, JOB_STATE_QUEUED, JOB_STATE_RUNNING)
+	err = repo.scan(row, job)
+	if err != sql.ErrNoRows && !isUniqueViolation(err) {
+		return
+	}
+	// No job was added or a concurrent Enqueue added one first
+	row = repo.db.QueryRow("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" "+
+		"WHERE domain = $1 AND state IN ($2, $3)", domain, JOB_STATE_QUEUED, JOB_STATE_RUNNING)
+	err = repo.scan(row, job)
+	return
+}
+
+// Queues a check for every enabled domain which has no open job, if tag is set only for the domains with that tag
+func (repo *CheckJobRepository) EnqueueAll(tag string) (count int, err error) {
+	args := []interface{}{JOB_STATE_QUEUED, JOB_STATE_RUNNING}
+	tagged := ""
+	if len(tag) > 0 {
+		args = append(args, tag)
+		tagged = "AND id IN (SELECT dt.domain_id FROM domain_tag dt JOIN tag t ON t.id = dt.tag_id WHERE t.name = $3) "
+	}
+	res, err := repo.db.Exec("INSERT INTO "+repo.TABLE_NAME+" (domain) "+
+		"SELECT name FROM domain WHERE NOT disabled AND name NOT IN (SELECT domain FROM "+repo.TABLE_NAME+" WHERE state IN ($1, $2)) "+tagged+"ORDER BY id",
+		args...)
+	if err != nil {
+		return
+	}
+	affected, err := res.RowsAffected()
+	count = int(affected)
+	return
+}
+
+// Claims the oldest queued job for worker, jobs locked by other workers are skipped.
+// Returns sql.ErrNoRows if no job is queued.
+func (repo *CheckJobRepository) Claim(worker string, lease time.Duration) (job *CheckJob, err error) {
+	job = new(CheckJob)
+	row := repo.db.QueryRow("UPDATE "+repo.TABLE_NAME+" "+
+		"SET state = $1, worker = $2, attempts = attempts + 1, heartbeat = now(), lease_until = now() + $3 * interval '1 second' "+
+		"WHERE "+repo.ID_FIELD+" = ("+
+		"SELECT "+repo.ID_FIELD+" FROM "+repo.TABLE_NAME+" WHERE state = $4 ORDER BY "+repo.ID_FIELD+" ASC LIMIT 1 FOR UPDATE SKIP LOCKED"+
+		") RETURNING "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD,
+		JOB_STATE_RUNNING, worker, lease.Seconds(), JOB_STATE_QUEUED)
+	err = repo.scan(row, job)
+	return
+}
+
+// Extends the lease of a running job
+func (repo *CheckJobRepository) Heartbeat(job *CheckJob, lease time.Duration) (err error) {
+	err = repo.db.QueryRow("UPDATE "+repo.TABLE_NAME+" "+
+		"SET heartbeat = now(), lease_until = now() + $1 * interval '1 second' "+
+		"WHERE "+repo.ID_FIELD+" = $2 AND worker = $3 AND state = $4 RETURNING heartbeat, lease_until",
+		lease.Seconds(), job.Id, job.Worker, JOB_STATE_RUNNING).Scan(&job.Heartbeat, &job.LeaseUntil)
+	if err == sql.ErrNoRows {
+		err = ErrLeaseLost
+	}
+	return
+}
+
+// Stores the final state, check and error of a job
+func (repo *CheckJobRepository) Complete(job *CheckJob) (err error) {
+	err = repo.db.QueryRow("UPDATE "+repo.TABLE_NAME+" "+
+		"SET state = $1, check_id = $2, error = $3, finished = now() "+
+		"WHERE "+repo.ID_FIELD+" = $4 AND worker = $5 AND state = $6 RETURNING finished",
+		job.State, nullId(job.CheckId), job.Error, job.Id, job.Worker, JOB_STATE_RUNNING).Scan(&job.Finished)
+	if err == sql.ErrNoRows {
+		err = ErrLeaseLost
+	}
+	return
+}
+
+// Puts running jobs whose lease expired back into the queue,
+// jobs which ran out of attempts are failed.
+func (repo *CheckJobRepository) RequeueExpired(maxAttempts int) (count int, err error) {
+	_, err = repo.db.Exec("UPDATE "+repo.TABLE_NAME+" "+
+		"SET state = $1, error = 'Lease expired', finished = now() "+
+		"WHERE state = $2 AND lease_until < now() AND attempts >= $3",
+		JOB_STATE_FAILED, JOB_STATE_RUNNING, maxAttempts)
+	if err != nil {
+		return
+	}
+	res, err := repo.db.Exec("UPDATE "+repo.TABLE_NAME+" "+
+		"SET state = $1, worker = '', lease_until = NULL "+
+		"WHERE state = $2 AND lease_until < now()",
+		JOB_STATE_QUEUED, JOB_STATE_RUNNING)
+	if err != nil {
+		return
+	}
+	affected, err := res.RowsAffected()
+	count = int(affected)
+	return
+}
+
+func (repo *CheckJobRepository) FindById(id int64) (job *CheckJob, err error) {
+	job = new(CheckJob)
+	row := repo.db.QueryRow("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" WHERE "+repo.ID_FIELD+" = $1", id)
+	err = repo.scan(row, job)
+	return
+}
+
+func (repo *CheckJobRepository) FindAll() (jobs []*CheckJob, err error) {
+	rows, err := repo.db.Query("SELECT " + repo.ID_FIELD + "," + repo.FIELDS + "," + repo.CREATED_FIELD + " FROM " + repo.TABLE_NAME + " ORDER BY " + repo.ID_FIELD + " ASC")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	jobs = make([]*CheckJob, 0)
+	for rows.Next() {
+		var job = new(CheckJob)
+		err = repo.scan(rows, job)
+		if err != nil {
+			return
+		}
+		jobs = append(jobs, job)
+	}
+	err = rows.Err()
+	return
+}
@@ -0,0 +1,152 @@
+package hivdomainstatus
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"code.google.com/p/gcfg"
+	assert "github.com/stretchr/testify/assert"
+)
+
+// Test for the check job queue
+
+func SetupCheckJobTest(t *testing.T) (db *sql.DB, repo *CheckJobRepository) {
+	c := NewDefaultConfig()
+	configErr := gcfg.ReadFileInto(c, "config.ini")
+	if configErr != nil {
+		t.Fatal(configErr)
+	}
+	db, _ = sql.Open("postgres", c.DSN())
+	db.Exec("TRUNCATE check_job RESTART IDENTITY")
+	repo = NewCheckJobRepository(db)
+	return
+}
+
+func TestThatItQueuesOneJobPerDomain(t *testing.T) {
+	assert := assert.New(t)
+	_, repo := SetupCheckJobTest(t)
+
+	job1, err1 := repo.Enqueue("example.hiv")
+	assert.Nil(err1)
+	assert.Equal(JOB_STATE_QUEUED, job1.State)
+	job2, err2 := repo.Enqueue("example.hiv")
+	assert.Nil(err2)
+	assert.Equal(job1.Id, job2.Id)
+
+	jobs, findErr := repo.FindAll()
+	assert.Nil(findErr)
+	assert.Equal(1, len(jobs))
+}
+
+func TestThatWorkersClaimDifferentJobs(t *testing.T) {
+	assert := assert.New(t)
+	_, repo := SetupCheckJobTest(t)
+
+	repo.Enqueue("example.hiv")
+	repo.Enqueue("acme.hiv")
+
+	job1, err1 := repo.Claim("worker-1", time.Minute)
+	assert.Nil(err1)
+	assert.Equal("example.hiv", job1.Domain)
+	assert.Equal(JOB_STATE_RUNNING, job1.State)
+	assert.Equal("worker-1", job1.Worker)
+	assert.Equal(1, job1.Attempts)
+
+	job2, err2 := repo.Claim("worker-2", time.Minute)
+	assert.Nil(err2)
+	assert.Equal("acme.hiv", job2.Domain)
+
+	_, err3 := repo.Claim("worker-3", time.Minute)
+	assert.Equal(sql.ErrNoRows, err3)
+
+	assert.Nil(repo.Heartbeat(job1, time.Minute))
+	job1.State = JOB_STATE_DONE
+	job1.CheckId = 17
+	assert.Nil(repo.Complete(job1))
+
+	done, findErr := repo.FindById(job1.Id)
+	assert.Nil(findErr)
+	assert.Equal(JOB_STATE_DONE, done.State)
+	assert.Equal(int64(17), done.CheckId)
+	assert.NotNil(done.Finished)
+}
+
+func TestThatItRequeuesExpiredJobs(t *testing.T) {
+	assert := assert.New(t)
+	db, repo := SetupCheckJobTest(t)
+
+	repo.Enqueue("example.hiv")
+	job, claimErr := repo.Claim("worker-1", time.Minute)
+	assert.Nil(claimErr)
+	db.Exec("UPDATE check_job SET lease_until = now() - interval '1 second'")
+
+	count, requeueErr := repo.RequeueExpired(2)
+	assert.Nil(requeueErr)
+	assert.Equal(1, count)
+	assert.Equal(ErrLeaseLost, repo.Heartbeat(job, time.Minute))
+
+	// Second attempt expires, too
+	job, claimErr = repo.Claim("worker-2", time.Minute)
+	assert.Nil(claimErr)
+	assert.Equal(2, job.Attempts)
+	db.Exec("UPDATE check_job SET lease_until = now() - interval '1 second'")
+	count, requeueErr = repo.RequeueExpired(2)
+	assert.Nil(requeueErr)
+	assert.Equal(0, count)
+
+	failed, findErr := repo.FindById(job.Id)
+	assert.Nil(findErr)
+	assert.Equal(JOB_STATE_FAILED, failed.State)
+}
+
+func TestThatItQueuesTaggedDomains(t *testing.T) {
+	assert := assert.New(t)
+	db, repo := SetupCheckJobTest(t)
+	db.Exec("TRUNCATE domain RESTART IDENTITY")
+	db.Exec("TRUNCATE tag RESTART IDENTITY CASCADE")
+	db.Exec("TRUNCATE domain_tag")
+	domainRepo := NewDomainRepository(db)
+	tagRepo := NewTagRepository(db)
+	for _, name := range []string{"example.hiv", "acme.hiv"} {
+		d := new(Domain)
+		d.Name = name
+		assert.Nil(domainRepo.Persist(d))
+	}
+	assert.Nil(tagRepo.AddDomainTag(2, "partner"))
+
+	count, err := repo.EnqueueAll("partner")
+	assert.Nil(err)
+	assert.Equal(1, count)
+	jobs, _ := repo.FindAll()
+	assert.Equal("acme.hiv", jobs[0].Domain)
+
+	count, err = repo.EnqueueAll("")
+	assert.Nil(err)
+	assert.Equal(1, count)
+}
+
+func TestThatItSkipsDisabledDomains(t *testing.T) {
+	assert := assert.New(t)
+	db, repo := SetupCheckJobTest(t)
+	db.Exec("TRUNCATE domain RESTART IDENTITY")
+	db.Exec("TRUNCATE domain_schedule RESTART IDENTITY")
+	domainRepo := NewDomainRepository(db)
+	for _, name := range []string{"example.hiv", "acme.hiv"} {
+		d := new(Domain)
+		d.Name = name
+		d.Disabled = name == "acme.hiv"
+		assert.Nil(domainRepo.Persist(d))
+	}
+
+	count, err := repo.EnqueueAll("")
+	assert.Nil(err)
+	assert.Equal(1, count)
+	jobs, _ := repo.FindAll()
+	assert.Equal("example.hiv", jobs[0].Domain)
+
+	scheduleRepo := NewDomainScheduleRepository(db)
+	assert.Nil(scheduleRepo.Sync())
+	_, err = scheduleRepo.FindByDomain("acme.hiv")
+	assert.Equal(sql.ErrNoRows, err)
+}
@@ -2,18 +2,177 @@ package hivdomainstatus
 
 import (
 	"database/sql"
+	"encoding/json"
 	_ "github.com/lib/pq"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type DomainRepositoryInterface interface {
 	Persist(domain *Domain) (err error)
+	PersistTx(tx *sql.Tx, domain *Domain) (err error)
+	CreateIfMissingTx(tx *sql.Tx, name string) (created bool, err error)
 	Remove(domain *Domain) (err error)
 	FindAll() (domains []*Domain, err error)
-	FindPaginated(numitems int, offsetKey string) (domains []*Domain, err error)
-	Stats() (count int, maxKey string, err error)
+	FindPaginated(numitems int, offsetKey string, filter *DomainFilter) (domains []*Domain, err error)
+	Stats(filter *DomainFilter) (count int, maxKey string, err error)
 	FindById(id int64) (domain *Domain, err error)
 	FindByName(name string) (domain *Domain, err error)
+	FindByNameTx(tx *sql.Tx, name string) (domain *Domain, err error)
+	Export(filter *DomainFilter, fn func(domain *Domain, check *DomainCheck) error) (err error)
+	Aggregate(filter *DomainFilter) (stats *DomainStats, err error)
+	ValidityHistory(filter *DomainFilter, since time.Time, until time.Time) (days []*DailyValidity, err error)
+}
+
+// Restricts domain listings, empty fields are ignored
+type DomainFilter struct {
+	// Part of the owner name
+	Owner       string
+	Contact     string
+	Registrar   string
+	ExternalRef string
+	Tag         string
+	Valid       *bool
+	Enabled     *bool
+	// Part of the domain name
+	Name       string
+	NamePrefix string
+	// Created at or after CreatedAfter and before CreatedBefore
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// Not checked since, including never checked domains
+	LastCheckedBefore *time.Time
+	// See FAILURE_REASON_*, matches the latest check
+	FailureReason string
+	// Order of the listing, a field of DomainSortFields, descending if prefixed with -
+	Sort string
+}
+
+// The fields domain listings can be sorted by
+var DomainSortFields = map[string]string{
+	"id":      "id",
+	"name":    "name",
+	"created": "created",
+	"valid":   "valid",
+}
+
+// Conditions on the latest check of a domain for every failure reason, see DomainCheck.FailureReason
+var failureReasonConditions = map[string]string{
+	FAILURE_REASON_DNS:    "NOT c.dns_ok",
+	FAILURE_REASON_HTTP:   "c.dns_ok AND (c.status_code < 200 OR c.status_code >= 400)",
+	FAILURE_REASON_SCRIPT: "c.dns_ok AND c.status_code >= 200 AND c.status_code < 400 AND NOT c.script_present",
+	FAILURE_REASON_IFRAME: "c.dns_ok AND c.status_code >= 200 AND c.status_code < 400 AND c.script_present AND c.iframe_present AND NOT c.iframe_target_ok",
+	FAILURE_REASON_OTHER:  "c.dns_ok AND c.status_code >= 200 AND c.status_code < 400 AND c.script_present AND NOT (c.iframe_present AND NOT c.iframe_target_ok)",
+}
+
+// Returns the column and direction of the sort order, ok is false for unknown fields
+func (filter *DomainFilter) order() (column string, desc bool, ok bool) {
+	sort := "id"
+	if filter != nil && len(filter.Sort) > 0 {
+		sort = filter.Sort
+	}
+	if strings.HasPrefix(sort, "-") {
+		desc = true
+		sort = sort[1:]
+	}
+	column, ok = DomainSortFields[sort]
+	return
+}
+
+// Returns the offsetKey of the page after domain. Unless sorted by id the sort value
+// is added to the id, so the page is found even if domain has been removed meanwhile.
+func (filter *DomainFilter) offsetKey(domain *Domain) string {
+	id := strconv.FormatInt(domain.Id, 10)
+	column, _, ok := filter.order()
+	if !ok {
+		return id
+	}
+	switch column {
+	case "name":
+		return id + ":" + domain.Name
+	case "valid":
+		return id + ":" + strconv.FormatBool(domain.Valid)
+	case "created":
+		if domain.Created != nil {
+			return id + ":" + domain.Created.Format(time.RFC3339Nano)
+		}
+	}
+	return id
+}
+
+// Returns the conditions of the filter, parameters are numbered from $1
+func (filter *DomainFilter) where() (where []string, args []interface{}) {
+	if filter == nil {
+		return
+	}
+	if len(filter.Owner) > 0 {
+		args = append(args, "%"+escapeLike(filter.Owner)+"%")
+		where = append(where, fmt.Sprintf("owner_name ILIKE $%d", len(args)))
+	}
+	if len(filter.Contact) > 0 {
+		args = append(args, filter.Contact)
+		where = append(where, fmt.Sprintf("EXISTS (SELECT 1 FROM json_array_elements_text(contacts) contact WHERE lower(contact) = lower($%d))", len(args)))
+	}
+	if len(filter.Registrar) > 0 {
+		args = append(args, filter.Registrar)
+		where = append(where, fmt.Sprintf("lower(registrar) = lower($%d)", len(args)))
+	}
+	if len(filter.ExternalRef) > 0 {
+		args = append(args, filter.ExternalRef)
+		where = append(where, fmt.Sprintf("external_ref = $%d", len(args)))
+	}
+	if len(filter.Tag) > 0 {
+		args = append(args, filter.Tag)
+		where = append(where, fmt.Sprintf("id IN (SELECT dt.domain_id FROM domain_tag dt JOIN tag t ON t.id = dt.tag_id WHERE t.name = $%d)", len(args)))
+	}
+	if filter.Valid != nil {
+		args = append(args, *filter.Valid)
+		where = append(where, fmt.Sprintf("valid = $%d", len(args)))
+	}
+	if filter.Enabled != nil {
+		args = append(args, !*filter.Enabled)
+		where = append(where, fmt.Sprintf("disabled = $%d", len(args)))
+	}
+	if len(filter.Name) > 0 {
+		args = append(args, "%"+escapeLike(filter.Name)+"%")
+		where = append(where, fmt.Sprintf("name ILIKE $%d", len(args)))
+	}
+	if len(filter.NamePrefix) > 0 {
+		args = append(args, escapeLike(filter.NamePrefix)+"%")
+		where = append(where, fmt.Sprintf("name ILIKE $%d", len(args)))
+	}
+	if filter.CreatedAfter != nil {
+		args = append(args, *filter.CreatedAfter)
+		where = append(where, fmt.Sprintf("created >= $%d", len(args)))
+	}
+	if filter.CreatedBefore != nil {
+		args = append(args, *filter.CreatedBefore)
+		where = append(where, fmt.Sprintf("created < $%d", len(args)))
+	}
+	if filter.LastCheckedBefore != nil {
+		// Checks are only stored on change, so the schedule knows about later checks
+		args = append(args, *filter.LastCheckedBefore)
+		where = append(where, fmt.Sprintf("COALESCE(GREATEST("+
+			"(SELECT s.last_check FROM domain_schedule s WHERE s.domain = domain.name), "+
+			"(SELECT MAX(c.created) FROM domain_check c WHERE c.domain = domain.name)"+
+			"), '-infinity') < $%d", len(args)))
+	}
+	if len(filter.FailureReason) > 0 {
+		condition, ok := failureReasonConditions[filter.FailureReason]
+		if !ok {
+			condition = "false"
+		}
+		where = append(where, "name IN (SELECT c.domain FROM domain_check c "+
+			"WHERE c.id = (SELECT MAX(l.id) FROM domain_check l WHERE l.domain = c.domain) AND NOT c.valid AND "+condition+")")
+	}
+	return
+}
+
+// Escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(s)
 }
 
 type DomainRepository struct {
@@ -29,29 +188,139 @@ func NewDomainRepository(db *sql.DB) (repo *DomainRepository) {
 	repo = new(DomainRepository)
 	repo.db = db
 	repo.TABLE_NAME = "domain"
-	repo.FIELDS = "name, valid"
+	repo.FIELDS = "name, valid, owner_name, contacts, registrar, notes, external_ref, disabled"
 	repo.OFFSET_FIELD = "id"
 	repo.CREATED_FIELD = "created"
 	return
 }
 
+// Tables which reference a domain by its name
+var domainNameReferences = []string{"domain_check", "domain_schedule", "check_job", "event", "notification"}
+
 func (repo *DomainRepository) Persist(domain *Domain) (err error) {
+	if domain.Id == 0 {
+		return repo.persist(repo.db, domain)
+	}
+	// A renamed domain is also renamed in the referencing tables
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return
+	}
+	err = repo.persist(tx, domain)
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+func (repo *DomainRepository) PersistTx(tx *sql.Tx, domain *Domain) (err error) {
+	return repo.persist(tx, domain)
+}
+
+func (repo *DomainRepository) persist(q queryer, domain *Domain) (err error) {
+	if domain.Contacts == nil {
+		domain.Contacts = []string{}
+	}
+	domain.ContactsJson, err = json.Marshal(domain.Contacts)
+	if err != nil {
+		return
+	}
 	if domain.Id > 0 {
-		_, err = repo.db.Exec("UPDATE "+repo.TABLE_NAME+" "+
-			"SET valid = $1 WHERE id = $2",domain.Valid, domain.Id)
+		err = repo.rename(q, domain)
+		if err != nil {
+			return
+		}
+		_, err = q.Exec("UPDATE "+repo.TABLE_NAME+" "+
+			"SET name = $1, valid = $2, owner_name = $3, contacts = $4, registrar = $5, notes = $6, external_ref = $7, disabled = $8 WHERE id = $9",
+			domain.Name, domain.Valid, domain.OwnerName, domain.ContactsJson, domain.Registrar, domain.Notes, domain.ExternalRef, domain.Disabled, domain.Id)
 	} else {
-		err = repo.db.QueryRow("INSERT INTO "+repo.TABLE_NAME+" "+
+		err = q.QueryRow("INSERT INTO "+repo.TABLE_NAME+" "+
 			"("+repo.FIELDS+") "+
-			"VALUES($1, $2) RETURNING id, created",
-			domain.Name, domain.Valid).Scan(&domain.Id, &domain.Created)
+			"VALUES($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created",
+			domain.Name, domain.Valid, domain.OwnerName, domain.ContactsJson, domain.Registrar, domain.Notes, domain.ExternalRef, domain.Disabled).Scan(&domain.Id, &domain.Created)
+	}
+	return
+}
+
+// Renames the rows referencing domain if its name changed, must run in the transaction which updates the domain
+func (repo *DomainRepository) rename(q queryer, domain *Domain) (err error) {
+	var name string
+	err = q.QueryRow("SELECT name FROM "+repo.TABLE_NAME+" WHERE "+repo.OFFSET_FIELD+" = $1 FOR UPDATE", domain.Id).Scan(&name)
+	if err == sql.ErrNoRows {
+		err = nil
+		return
+	}
+	if err != nil || name == domain.Name {
+		return
+	}
+	// Left over by a removed domain with the new name
+	_, err = q.Exec("DELETE FROM domain_schedule WHERE domain = $1", domain.Name)
+	if err != nil {
+		return
+	}
+	// Only one job per domain may be open, the one of the renamed domain is kept
+	_, err = q.Exec("UPDATE check_job SET state = $1, error = 'Domain renamed', finished = now() "+
+		"WHERE domain = $2 AND state IN ($3, $4) "+
+		"AND EXISTS (SELECT 1 FROM check_job WHERE domain = $5 AND state IN ($3, $4))",
+		JOB_STATE_FAILED, domain.Name, JOB_STATE_QUEUED, JOB_STATE_RUNNING, name)
+	if err != nil {
+		return
+	}
+	for _, table := range domainNameReferences {
+		_, err = q.Exec("UPDATE "+table+" SET domain = $1 WHERE domain = $2", domain.Name, name)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Adds a domain with name unless it exists, also if it is added concurrently.
+// Lock it with FindByNameTx to update it.
+func (repo *DomainRepository) CreateIfMissingTx(tx *sql.Tx, name string) (created bool, err error) {
+	res, err := tx.Exec("INSERT INTO "+repo.TABLE_NAME+" (name) VALUES ($1) ON CONFLICT (name) DO NOTHING", name)
+	if err != nil {
+		return
 	}
+	affected, err := res.RowsAffected()
+	created = affected > 0
 	return
 }
 
+// Removes domain with its tags and maintenance windows
 func (repo *DomainRepository) Remove(domain *Domain) (err error) {
-	_, err = repo.db.Exec("DELETE FROM "+repo.TABLE_NAME+" "+
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec("DELETE FROM "+repo.TABLE_NAME+" "+
 		"WHERE " + repo.OFFSET_FIELD + " = $1",
 		domain.Id)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM domain_tag WHERE domain_id = $1", domain.Id)
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM maintenance_window WHERE domain_id = $1", domain.Id)
+	}
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+func (repo *DomainRepository) scan(row rowScanner, domain *Domain) (err error) {
+	err = row.Scan(&domain.Id, &domain.Name, &domain.Valid, &domain.OwnerName, &domain.ContactsJson, &domain.Registrar, &domain.Notes, &domain.ExternalRef, &domain.Disabled, &domain.Created)
+	if err != nil {
+		return
+	}
+	domain.Contacts = []string{}
+	if len(domain.ContactsJson) > 0 {
+		err = json.Unmarshal(domain.ContactsJson, &domain.Contacts)
+	}
 	return
 }
 
@@ -59,7 +328,7 @@ func (repo *DomainRepository) rowsToResult(rows *sql.Rows) (domains []*Domain, e
 	domains = make([]*Domain, 0)
 	for rows.Next() {
 		var domain = new(Domain)
-		err = rows.Scan(&domain.Id, &domain.Name, &domain.Valid, &domain.Created)
+		err = repo.scan(rows, domain)
 		if err != nil {
 			return
 		}
@@ -79,13 +348,39 @@ func (repo *DomainRepository) FindAll() (domains []*Domain, err error) {
 	return
 }
 
-func (repo *DomainRepository) FindPaginated(numitems int, offsetKey string) (domains []*Domain, err error) {
-	var rows *sql.Rows
+// Returns a page of domains in the order of filter.Sort, offsetKey is the id of the
+// last domain of the previous page, followed by its sort value (see DomainFilter.offsetKey)
+func (repo *DomainRepository) FindPaginated(numitems int, offsetKey string, filter *DomainFilter) (domains []*Domain, err error) {
+	column, desc, ok := filter.order()
+	if !ok {
+		err = fmt.Errorf("Invalid sort: %s", filter.Sort)
+		return
+	}
+	direction, comparison := "ASC", ">"
+	if desc {
+		direction, comparison = "DESC", "<"
+	}
+	where, args := filter.where()
 	if len(offsetKey) > 0 {
-		rows, err = repo.db.Query("SELECT "+repo.OFFSET_FIELD + "," + repo.FIELDS+","+repo.CREATED_FIELD+" "+"FROM "+repo.TABLE_NAME+" WHERE "+repo.OFFSET_FIELD+" > $1 ORDER BY "+repo.OFFSET_FIELD+" ASC LIMIT $2", offsetKey, numitems)
-	} else {
-		rows, err = repo.db.Query("SELECT "+repo.OFFSET_FIELD + "," + repo.FIELDS+","+repo.CREATED_FIELD+" "+"FROM "+repo.TABLE_NAME+" ORDER BY "+repo.OFFSET_FIELD+" ASC LIMIT $1", numitems)
+		parts := strings.SplitN(offsetKey, ":", 2)
+		args = append(args, parts[0])
+		if column == repo.OFFSET_FIELD {
+			where = append(where, fmt.Sprintf("%s %s $%d", repo.OFFSET_FIELD, comparison, len(args)))
+		} else if len(parts) == 2 {
+			// Keyset on the sort value, the id breaks ties
+			args = append(args, parts[1])
+			where = append(where, fmt.Sprintf("(%s, %s) %s ($%d, $%d)", column, repo.OFFSET_FIELD, comparison, len(args), len(args)-1))
+		} else {
+			// Keyset on the sort column, the id breaks ties
+			where = append(where, fmt.Sprintf("(%s, %s) %s (SELECT %s, %s FROM %s WHERE %s = $%d)", column, repo.OFFSET_FIELD, comparison, column, repo.OFFSET_FIELD, repo.TABLE_NAME, repo.OFFSET_FIELD, len(args)))
+		}
 	}
+	order := column + " " + direction
+	if column != repo.OFFSET_FIELD {
+		order += ", " + repo.OFFSET_FIELD + " " + direction
+	}
+	args = append(args, numitems)
+	rows, err := repo.db.Query("SELECT "+repo.OFFSET_FIELD + "," + repo.FIELDS+","+repo.CREATED_FIELD+" "+"FROM "+repo.TABLE_NAME+whereClause(where)+" ORDER BY "+order+fmt.Sprintf(" LIMIT $%d", len(args)), args...)
 	if err != nil {
 		return
 	}
@@ -94,9 +389,10 @@ func (repo *DomainRepository) FindPaginated(numitems int, offsetKey string) (dom
 	return
 }
 
-func (repo *DomainRepository) Stats() (count int, maxKey string, err error) {
+func (repo *DomainRepository) Stats(filter *DomainFilter) (count int, maxKey string, err error) {
 	var maxKeyInt sql.NullInt64
-	err = repo.db.QueryRow("SELECT COUNT("+repo.OFFSET_FIELD+"), MAX("+repo.OFFSET_FIELD+") FROM "+repo.TABLE_NAME).Scan(&count, &maxKeyInt)
+	where, args := filter.where()
+	err = repo.db.QueryRow("SELECT COUNT("+repo.OFFSET_FIELD+"), MAX("+repo.OFFSET_FIELD+") FROM "+repo.TABLE_NAME+whereClause(where), args...).Scan(&count, &maxKeyInt)
 	if maxKeyInt.Valid {
 		// If table is empty MAX(id) is null
 		maxKey = fmt.Sprintf("%d", maxKeyInt.Int64)
@@ -106,12 +402,132 @@ func (repo *DomainRepository) Stats() (count int, maxKey string, err error) {
 
 func (repo *DomainRepository) FindById(id int64) (domain *Domain, err error) {
 	domain = new(Domain)
-	err = repo.db.QueryRow("SELECT " + repo.OFFSET_FIELD + "," + repo.FIELDS+","+repo.CREATED_FIELD + " FROM " + repo.TABLE_NAME + " WHERE " + repo.OFFSET_FIELD + " = $1", id).Scan(&domain.Id, &domain.Name, &domain.Valid, &domain.Created)
+	err = repo.scan(repo.db.QueryRow("SELECT " + repo.OFFSET_FIELD + "," + repo.FIELDS+","+repo.CREATED_FIELD + " FROM " + repo.TABLE_NAME + " WHERE " + repo.OFFSET_FIELD + " = $1", id), domain)
 	return
 }
 
 func (repo *DomainRepository) FindByName(name string) (domain *Domain, err error) {
 	domain = new(Domain)
-	err = repo.db.QueryRow("SELECT " + repo.OFFSET_FIELD + "," + repo.FIELDS+","+repo.CREATED_FIELD + " FROM " + repo.TABLE_NAME + " WHERE name = $1", name).Scan(&domain.Id, &domain.Name, &domain.Valid, &domain.Created)
+	err = repo.scan(repo.db.QueryRow("SELECT " + repo.OFFSET_FIELD + "," + repo.FIELDS+","+repo.CREATED_FIELD + " FROM " + repo.TABLE_NAME + " WHERE name = $1", name), domain)
+	return
+}
+
+// Finds the domain and locks it until the transaction ends
+func (repo *DomainRepository) FindByNameTx(tx *sql.Tx, name string) (domain *Domain, err error) {
+	domain = new(Domain)
+	err = repo.scan(tx.QueryRow("SELECT "+repo.OFFSET_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" WHERE name = $1 FOR UPDATE", name), domain)
+	return
+}
+
+// Calls fn for every domain matching filter with its latest check, check is nil
+// for domains which have never been checked. The domains are streamed from one query,
+// an error returned by fn stops the export.
+func (repo *DomainRepository) Export(filter *DomainFilter, fn func(domain *Domain, check *DomainCheck) error) (err error) {
+	column, desc, ok := filter.order()
+	if !ok {
+		err = fmt.Errorf("Invalid sort: %s", filter.Sort)
+		return
+	}
+	direction := "ASC"
+	if desc {
+		direction = "DESC"
+	}
+	where, args := filter.where()
+	checkRepo := NewDomainCheckRepository(repo.db)
+	rows, err := repo.db.Query("SELECT d.*, c.* "+
+		"FROM (SELECT "+repo.OFFSET_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+whereClause(where)+") d "+
+		"LEFT JOIN LATERAL (SELECT "+checkRepo.ID_FIELD+","+checkRepo.FIELDS+","+checkRepo.CREATED_FIELD+" FROM "+checkRepo.TABLE_NAME+" WHERE domain = d.name ORDER BY "+checkRepo.ID_FIELD+" DESC LIMIT 1) c ON true "+
+		"ORDER BY d."+column+" "+direction+", d."+repo.OFFSET_FIELD+" "+direction, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		domain := new(Domain)
+		latest := new(nullableDomainCheck)
+		err = repo.scan(&extraColumns{rows, latest.dest()}, domain)
+		if err != nil {
+			return
+		}
+		var check *DomainCheck
+		check, err = checkRepo.fromNullable(latest)
+		if err != nil {
+			return
+		}
+		err = fn(domain, check)
+		if err != nil {
+			return
+		}
+	}
+	err = rows.Err()
+	return
+}
+
+// Returns the numbers of the domains matching filter, based on their latest check
+func (repo *DomainRepository) Aggregate(filter *DomainFilter) (stats *DomainStats, err error) {
+	stats = new(DomainStats)
+	stats.FailureReasons = make(map[string]int)
+	reasons := []string{FAILURE_REASON_DNS, FAILURE_REASON_HTTP, FAILURE_REASON_SCRIPT, FAILURE_REASON_IFRAME, FAILURE_REASON_OTHER}
+	columns := []string{
+		"COUNT(*)",
+		"SUM(CASE WHEN domain.valid THEN 1 ELSE 0 END)",
+		"SUM(CASE WHEN domain.disabled THEN 1 ELSE 0 END)",
+		"SUM(CASE WHEN c.id IS NULL THEN 1 ELSE 0 END)",
+		"SUM(CASE WHEN c.dns_ok THEN 1 ELSE 0 END)",
+		"SUM(CASE WHEN c.script_present THEN 1 ELSE 0 END)",
+		"SUM(CASE WHEN c.iframe_present THEN 1 ELSE 0 END)",
+		"SUM(CASE WHEN c.url LIKE 'https://%' AND c.status_code >= 200 AND c.status_code < 400 THEN 1 ELSE 0 END)",
+	}
+	for _, reason := range reasons {
+		columns = append(columns, "SUM(CASE WHEN NOT c.valid AND "+failureReasonConditions[reason]+" THEN 1 ELSE 0 END)")
+	}
+	for i, column := range columns {
+		columns[i] = "COALESCE(" + column + ", 0)"
+	}
+	counts := make([]int, len(reasons))
+	dest := []interface{}{&stats.Domains, &stats.Valid, &stats.Disabled, &stats.NeverChecked, &stats.DnsOK, &stats.ScriptPresent, &stats.IframePresent, &stats.HttpsOK}
+	for i := range counts {
+		dest = append(dest, &counts[i])
+	}
+	where, args := filter.where()
+	err = repo.db.QueryRow("SELECT "+strings.Join(columns, ", ")+" "+
+		"FROM (SELECT * FROM "+repo.TABLE_NAME+whereClause(where)+") domain "+
+		"LEFT JOIN domain_check c ON c.id = (SELECT MAX(l.id) FROM domain_check l WHERE l.domain = domain.name)", args...).Scan(dest...)
+	if err != nil {
+		return
+	}
+	stats.Invalid = stats.Domains - stats.Valid
+	for i, reason := range reasons {
+		stats.FailureReasons[reason] = counts[i]
+	}
 	return
-}
\ No newline at end of file
+}
+
+// Returns for every day from since until until how many of the domains matching filter
+// had been checked by the end of the day and how many of them were valid then.
+// Checks are only stored on change, so the latest check before the end of a day is used.
+func (repo *DomainRepository) ValidityHistory(filter *DomainFilter, since time.Time, until time.Time) (days []*DailyValidity, err error) {
+	where, args := filter.where()
+	args = append(args, since, until)
+	rows, err := repo.db.Query(fmt.Sprintf("SELECT day, COUNT(c.id), COALESCE(SUM(CASE WHEN c.valid THEN 1 ELSE 0 END), 0) "+
+		"FROM generate_series($%d::date, $%d::date, interval '1 day') day "+
+		"LEFT JOIN LATERAL (SELECT DISTINCT ON (l.domain) l.id, l.valid FROM domain_check l "+
+		"WHERE l.created < day + interval '1 day' AND l.domain IN (SELECT name FROM "+repo.TABLE_NAME+whereClause(where)+") "+
+		"ORDER BY l.domain, l.id DESC) c ON true "+
+		"GROUP BY day ORDER BY day", len(args)-1, len(args)), args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	days = make([]*DailyValidity, 0)
+	for rows.Next() {
+		d := new(DailyValidity)
+		err = rows.Scan(&d.Day, &d.Checked, &d.Valid)
+		if err != nil {
+			return
+		}
+		days = append(days, d)
+	}
+	err = rows.Err()
+	return
+}
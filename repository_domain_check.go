@@ -4,19 +4,61 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
+	"time"
 
 	_ "github.com/lib/pq"
 )
 
 type DomainCheckRepositoryInterface interface {
 	Persist(result *DomainCheck) (err error)
+	PersistTx(tx *sql.Tx, result *DomainCheck) (err error)
 	Remove(result *DomainCheck) (err error)
 	FindAll() (results []*DomainCheck, err error)
+	FindById(id int64) (result *DomainCheck, err error)
+	FindPreviousByDomain(check *DomainCheck) (result *DomainCheck, err error)
+	FindNextByDomain(check *DomainCheck) (result *DomainCheck, err error)
 	FindByDomain(domain string) (result []*DomainCheck, err error)
 	FindLatestByDomain(domain string) (result *DomainCheck, err error)
-	FindPaginated(numitems int, offsetKey string) (results []*DomainCheck, err error)
-	Stats() (count int, maxKey string, err error)
+	FindLatestByDomainTx(tx *sql.Tx, domain string) (result *DomainCheck, err error)
+	FindPaginated(numitems int, offsetKey string, filter *DomainCheckFilter) (results []*DomainCheck, err error)
+	FindHistory(numitems int, offsetKey string, filter *DomainCheckFilter) (results []*DomainCheck, err error)
+	Stats(filter *DomainCheckFilter) (count int, maxKey string, err error)
+}
+
+// Restricts check listings, empty fields are ignored
+type DomainCheckFilter struct {
+	// Only checks of domains with this tag
+	Tag string
+	// Only checks of this domain
+	Domain string
+	// Only checks created at or after Since
+	Since *time.Time
+	// Only checks created before Until
+	Until *time.Time
+}
+
+// Returns the conditions of the filter, parameters are numbered from $1
+func (filter *DomainCheckFilter) where() (where []string, args []interface{}) {
+	if filter == nil {
+		return
+	}
+	if len(filter.Tag) > 0 {
+		args = append(args, filter.Tag)
+		where = append(where, fmt.Sprintf("domain IN (SELECT d.name FROM domain d JOIN domain_tag dt ON dt.domain_id = d.id JOIN tag t ON t.id = dt.tag_id WHERE t.name = $%d)", len(args)))
+	}
+	if len(filter.Domain) > 0 {
+		args = append(args, filter.Domain)
+		where = append(where, fmt.Sprintf("domain = $%d", len(args)))
+	}
+	if filter.Since != nil {
+		args = append(args, *filter.Since)
+		where = append(where, fmt.Sprintf("created >= $%d", len(args)))
+	}
+	if filter.Until != nil {
+		args = append(args, *filter.Until)
+		where = append(where, fmt.Sprintf("created < $%d", len(args)))
+	}
+	return
 }
 
 type DomainCheckRepository struct {
@@ -32,31 +74,38 @@ func NewDomainCheckRepository(db *sql.DB) (repo *DomainCheckRepository) {
 	repo = new(DomainCheckRepository)
 	repo.db = db
 	repo.TABLE_NAME = "domain_check"
-	repo.FIELDS = "domain, dns_ok, addresses, url, status_code, script_present, iframe_present, iframe_target, iframe_target_ok, valid"
+	repo.FIELDS = "domain, dns_ok, addresses, url, status_code, script_present, iframe_present, iframe_target, iframe_target_ok, valid, hosts, in_maintenance"
 	repo.ID_FIELD = "id"
 	repo.CREATED_FIELD = "created"
 	return
 }
 
 func (repo *DomainCheckRepository) Persist(result *DomainCheck) (err error) {
+	return repo.persist(repo.db, result)
+}
+
+func (repo *DomainCheckRepository) PersistTx(tx *sql.Tx, result *DomainCheck) (err error) {
+	return repo.persist(tx, result)
+}
+
+func (repo *DomainCheckRepository) persist(q queryer, result *DomainCheck) (err error) {
 	result.AddressesJson, err = json.Marshal(result.Addresses)
 	if err != nil {
-		log.Fatalln(err.Error())
+		return
+	}
+	result.HostsJson, err = json.Marshal(result.Hosts)
+	if err != nil {
 		return
 	}
 	if result.Id > 0 {
-		_, err = repo.db.Exec("UPDATE "+repo.TABLE_NAME+" "+
-			"SET domain = $1, dns_ok = $2, addresses = $3, url = $4, status_code = $5, script_present = $6, iframe_present = $7, iframe_target = $8, iframe_target_ok = $9, valid = $10 WHERE id = $11",
-			result.Domain, result.DnsOK, result.AddressesJson, result.URL, result.StatusCode, result.ScriptPresent, result.IframePresent, result.IframeTarget, result.IframeTargetOk, result.Valid, result.Id)
+		_, err = q.Exec("UPDATE "+repo.TABLE_NAME+" "+
+			"SET domain = $1, dns_ok = $2, addresses = $3, url = $4, status_code = $5, script_present = $6, iframe_present = $7, iframe_target = $8, iframe_target_ok = $9, valid = $10, hosts = $11, in_maintenance = $12 WHERE id = $13",
+			result.Domain, result.DnsOK, result.AddressesJson, result.URL, result.StatusCode, result.ScriptPresent, result.IframePresent, result.IframeTarget, result.IframeTargetOk, result.Valid, result.HostsJson, result.InMaintenance, result.Id)
 	} else {
-		err = repo.db.QueryRow("INSERT INTO "+repo.TABLE_NAME+" "+
+		err = q.QueryRow("INSERT INTO "+repo.TABLE_NAME+" "+
 			"("+repo.FIELDS+") "+
-			"VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id, created",
-			result.Domain, result.DnsOK, result.AddressesJson, result.URL, result.StatusCode, result.ScriptPresent, result.IframePresent, result.IframeTarget, result.IframeTargetOk, result.Valid).Scan(&result.Id, &result.Created)
-	}
-	if err != nil {
-		log.Fatalln(err.Error())
-		return
+			"VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id, created",
+			result.Domain, result.DnsOK, result.AddressesJson, result.URL, result.StatusCode, result.ScriptPresent, result.IframePresent, result.IframeTarget, result.IframeTargetOk, result.Valid, result.HostsJson, result.InMaintenance).Scan(&result.Id, &result.Created)
 	}
 	return
 }
@@ -68,15 +117,70 @@ func (repo *DomainCheckRepository) Remove(result *DomainCheck) (err error) {
 	return
 }
 
+func (repo *DomainCheckRepository) scan(row rowScanner, result *DomainCheck) (err error) {
+	err = row.Scan(&result.Id, &result.Domain, &result.DnsOK, &result.AddressesJson, &result.URL, &result.StatusCode, &result.ScriptPresent, &result.IframePresent, &result.IframeTarget, &result.IframeTargetOk, &result.Valid, &result.HostsJson, &result.InMaintenance, &result.Created)
+	if err != nil {
+		return
+	}
+	err = repo.unmarshal(result)
+	return
+}
+
+func (repo *DomainCheckRepository) unmarshal(result *DomainCheck) (err error) {
+	err = json.Unmarshal(result.AddressesJson, &result.Addresses)
+	if err != nil {
+		return
+	}
+	// Checks stored before hosts were recorded have no hosts
+	if len(result.HostsJson) > 0 {
+		err = json.Unmarshal(result.HostsJson, &result.Hosts)
+	}
+	return
+}
+
+// The columns of a check, which are NULL if a LEFT JOIN found none
+type nullableDomainCheck struct {
+	id, statusCode                                                            sql.NullInt64
+	domain, url, iframeTarget                                                 sql.NullString
+	dnsOk, scriptPresent, iframePresent, iframeTargetOk, valid, inMaintenance sql.NullBool
+	addresses, hosts                                                          []byte
+	created                                                                   *time.Time
+}
+
+// Returns the destinations of ID_FIELD, FIELDS and CREATED_FIELD
+func (c *nullableDomainCheck) dest() []interface{} {
+	return []interface{}{&c.id, &c.domain, &c.dnsOk, &c.addresses, &c.url, &c.statusCode, &c.scriptPresent, &c.iframePresent, &c.iframeTarget, &c.iframeTargetOk, &c.valid, &c.hosts, &c.inMaintenance, &c.created}
+}
+
+// Returns the scanned check, nil if there is none
+func (repo *DomainCheckRepository) fromNullable(c *nullableDomainCheck) (result *DomainCheck, err error) {
+	if !c.id.Valid {
+		return
+	}
+	result = new(DomainCheck)
+	result.Id = c.id.Int64
+	result.Domain = c.domain.String
+	result.DnsOK = c.dnsOk.Bool
+	result.AddressesJson = c.addresses
+	result.URL = c.url.String
+	result.StatusCode = int(c.statusCode.Int64)
+	result.ScriptPresent = c.scriptPresent.Bool
+	result.IframePresent = c.iframePresent.Bool
+	result.IframeTarget = c.iframeTarget.String
+	result.IframeTargetOk = c.iframeTargetOk.Bool
+	result.Valid = c.valid.Bool
+	result.HostsJson = c.hosts
+	result.InMaintenance = c.inMaintenance.Bool
+	result.Created = c.created
+	err = repo.unmarshal(result)
+	return
+}
+
 func (repo *DomainCheckRepository) rowsToResult(rows *sql.Rows) (results []*DomainCheck, err error) {
 	results = make([]*DomainCheck, 0)
 	for rows.Next() {
 		var result = new(DomainCheck)
-		err = rows.Scan(&result.Id, &result.Domain, &result.DnsOK, &result.AddressesJson, &result.URL, &result.StatusCode, &result.ScriptPresent, &result.IframePresent, &result.IframeTarget, &result.IframeTargetOk, &result.Valid, &result.Created)
-		if err != nil {
-			return
-		}
-		err = json.Unmarshal(result.AddressesJson, &result.Addresses)
+		err = repo.scan(rows, result)
 		if err != nil {
 			return
 		}
@@ -96,13 +200,31 @@ func (repo *DomainCheckRepository) FindAll() (results []*DomainCheck, err error)
 	return
 }
 
-func (repo *DomainCheckRepository) FindPaginated(numitems int, offsetKey string) (results []*DomainCheck, err error) {
-	var rows *sql.Rows
+func (repo *DomainCheckRepository) FindPaginated(numitems int, offsetKey string, filter *DomainCheckFilter) (results []*DomainCheck, err error) {
+	where, args := filter.where()
 	if len(offsetKey) > 0 {
-		rows, err = repo.db.Query("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" "+"FROM "+repo.TABLE_NAME+" WHERE "+repo.ID_FIELD+" > $1 ORDER BY "+repo.ID_FIELD+" ASC LIMIT $2", offsetKey, numitems)
-	} else {
-		rows, err = repo.db.Query("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" "+"FROM "+repo.TABLE_NAME+" ORDER BY "+repo.ID_FIELD+" ASC LIMIT $1", numitems)
+		args = append(args, offsetKey)
+		where = append(where, fmt.Sprintf("%s > $%d", repo.ID_FIELD, len(args)))
+	}
+	args = append(args, numitems)
+	rows, err := repo.db.Query("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" "+"FROM "+repo.TABLE_NAME+whereClause(where)+" ORDER BY "+repo.ID_FIELD+fmt.Sprintf(" ASC LIMIT $%d", len(args)), args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	results, err = repo.rowsToResult(rows)
+	return
+}
+
+// Returns checks newest first, offsetKey is the id of the last check of the previous page
+func (repo *DomainCheckRepository) FindHistory(numitems int, offsetKey string, filter *DomainCheckFilter) (results []*DomainCheck, err error) {
+	where, args := filter.where()
+	if len(offsetKey) > 0 {
+		args = append(args, offsetKey)
+		where = append(where, fmt.Sprintf("%s < $%d", repo.ID_FIELD, len(args)))
 	}
+	args = append(args, numitems)
+	rows, err := repo.db.Query("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" "+"FROM "+repo.TABLE_NAME+whereClause(where)+" ORDER BY "+repo.ID_FIELD+fmt.Sprintf(" DESC LIMIT $%d", len(args)), args...)
 	if err != nil {
 		return
 	}
@@ -111,9 +233,10 @@ func (repo *DomainCheckRepository) FindPaginated(numitems int, offsetKey string)
 	return
 }
 
-func (repo *DomainCheckRepository) Stats() (count int, maxKey string, err error) {
+func (repo *DomainCheckRepository) Stats(filter *DomainCheckFilter) (count int, maxKey string, err error) {
 	var maxKeyInt sql.NullInt64
-	err = repo.db.QueryRow("SELECT COUNT("+repo.ID_FIELD+"), MAX("+repo.ID_FIELD+") FROM "+repo.TABLE_NAME).Scan(&count, &maxKeyInt)
+	where, args := filter.where()
+	err = repo.db.QueryRow("SELECT COUNT("+repo.ID_FIELD+"), MAX("+repo.ID_FIELD+") FROM "+repo.TABLE_NAME+whereClause(where), args...).Scan(&count, &maxKeyInt)
 	if maxKeyInt.Valid {
 		// If table is empty MAX(id) is null
 		maxKey = fmt.Sprintf("%d", maxKeyInt.Int64)
@@ -123,11 +246,24 @@ func (repo *DomainCheckRepository) Stats() (count int, maxKey string, err error)
 
 func (repo *DomainCheckRepository) FindById(id int64) (result *DomainCheck, err error) {
 	result = new(DomainCheck)
-	err = repo.db.QueryRow("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" WHERE "+repo.ID_FIELD+" = $1", id).Scan(&result.Id, &result.Domain, &result.DnsOK, &result.AddressesJson, &result.URL, &result.StatusCode, &result.ScriptPresent, &result.IframePresent, &result.IframeTarget, &result.IframeTargetOk, &result.Valid, &result.Created)
-	if err != nil {
-		return
-	}
-	err = json.Unmarshal(result.AddressesJson, &result.Addresses)
+	row := repo.db.QueryRow("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" WHERE "+repo.ID_FIELD+" = $1", id)
+	err = repo.scan(row, result)
+	return
+}
+
+// Returns the check of the same domain stored before check, sql.ErrNoRows if there is none
+func (repo *DomainCheckRepository) FindPreviousByDomain(check *DomainCheck) (result *DomainCheck, err error) {
+	result = new(DomainCheck)
+	row := repo.db.QueryRow("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" WHERE domain = $1 AND "+repo.ID_FIELD+" < $2 ORDER BY "+repo.ID_FIELD+" DESC LIMIT 1", check.Domain, check.Id)
+	err = repo.scan(row, result)
+	return
+}
+
+// Returns the check of the same domain stored after check, sql.ErrNoRows if there is none
+func (repo *DomainCheckRepository) FindNextByDomain(check *DomainCheck) (result *DomainCheck, err error) {
+	result = new(DomainCheck)
+	row := repo.db.QueryRow("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" WHERE domain = $1 AND "+repo.ID_FIELD+" > $2 ORDER BY "+repo.ID_FIELD+" ASC LIMIT 1", check.Domain, check.Id)
+	err = repo.scan(row, result)
 	return
 }
 
@@ -143,11 +279,16 @@ func (repo *DomainCheckRepository) FindByDomain(domain string) (results []*Domai
 }
 
 func (repo *DomainCheckRepository) FindLatestByDomain(domain string) (result *DomainCheck, err error) {
+	return repo.findLatestByDomain(repo.db, domain)
+}
+
+func (repo *DomainCheckRepository) FindLatestByDomainTx(tx *sql.Tx, domain string) (result *DomainCheck, err error) {
+	return repo.findLatestByDomain(tx, domain)
+}
+
+func (repo *DomainCheckRepository) findLatestByDomain(q queryer, domain string) (result *DomainCheck, err error) {
 	result = new(DomainCheck)
-	err = repo.db.QueryRow("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" WHERE domain = $1 ORDER BY "+repo.CREATED_FIELD+" DESC LIMIT 1", domain).Scan(&result.Id, &result.Domain, &result.DnsOK, &result.AddressesJson, &result.URL, &result.StatusCode, &result.ScriptPresent, &result.IframePresent, &result.IframeTarget, &result.IframeTargetOk, &result.Valid, &result.Created)
-	if err != nil {
-		return
-	}
-	err = json.Unmarshal(result.AddressesJson, &result.Addresses)
+	row := q.QueryRow("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" WHERE domain = $1 ORDER BY "+repo.CREATED_FIELD+" DESC LIMIT 1", domain)
+	err = repo.scan(row, result)
 	return
 }
@@ -33,6 +33,7 @@ func TestThatItPersistsDomainCheck(t *testing.T) {
 	result.IframeTarget = "http://example.com/"
 	result.IframeTargetOk = true
 	result.Valid = true
+	result.Hosts = []*DomainCheckHost{&DomainCheckHost{Host: "www.example.hiv", URL: "http://www.example.hiv/", StatusCode: 200, ScriptPresent: true, Valid: true}}
 	repo := NewDomainCheckRepository(db)
 	persistErr := repo.Persist(result)
 	assert.Nil(persistErr)
@@ -56,6 +57,10 @@ func TestThatItPersistsDomainCheck(t *testing.T) {
 	assert.Equal("http://example.com/", r.IframeTarget)
 	assert.True(r.IframeTargetOk)
 	assert.True(r.Valid)
+	assert.Equal(1, len(r.Hosts))
+	assert.Equal("www.example.hiv", r.Hosts[0].Host)
+	assert.Equal(200, r.Hosts[0].StatusCode)
+	assert.True(r.Hosts[0].ScriptPresent)
 
 	// Verify By Domain
 	resultsByName, findNameErr := repo.FindByDomain("example.hiv")
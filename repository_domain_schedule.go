@@ -0,0 +1,106 @@
+package hivdomainstatus
+
+import (
+	"database/sql"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+type DomainScheduleRepositoryInterface interface {
+	Persist(schedule *DomainSchedule) (err error)
+	Sync() (err error)
+	ClaimDue(now time.Time, next time.Time) (schedule *DomainSchedule, err error)
+	FindAll() (schedules []*DomainSchedule, err error)
+	FindByDomain(domain string) (schedule *DomainSchedule, err error)
+}
+
+type DomainScheduleRepository struct {
+	DomainScheduleRepositoryInterface
+	db            *sql.DB
+	TABLE_NAME    string
+	ID_FIELD      string
+	FIELDS        string
+	CREATED_FIELD string
+}
+
+func NewDomainScheduleRepository(db *sql.DB) (repo *DomainScheduleRepository) {
+	repo = new(DomainScheduleRepository)
+	repo.db = db
+	repo.TABLE_NAME = "domain_schedule"
+	repo.FIELDS = "domain, next_check, last_check"
+	repo.ID_FIELD = "id"
+	repo.CREATED_FIELD = "created"
+	return
+}
+
+func (repo *DomainScheduleRepository) Persist(schedule *DomainSchedule) (err error) {
+	if schedule.Id > 0 {
+		_, err = repo.db.Exec("UPDATE "+repo.TABLE_NAME+" "+
+			"SET next_check = $1, last_check = $2 WHERE id = $3",
+			schedule.NextCheck, schedule.LastCheck, schedule.Id)
+	} else {
+		if schedule.NextCheck == nil {
+			now := time.Now()
+			schedule.NextCheck = &now
+		}
+		err = repo.db.QueryRow("INSERT INTO "+repo.TABLE_NAME+" "+
+			"("+repo.FIELDS+") "+
+			"VALUES($1, $2, $3) RETURNING id, created",
+			schedule.Domain, schedule.NextCheck, schedule.LastCheck).Scan(&schedule.Id, &schedule.Created)
+	}
+	return
+}
+
+// Schedules new and re-enabled domains for an immediate check and drops the schedules of removed and disabled domains
+func (repo *DomainScheduleRepository) Sync() (err error) {
+	_, err = repo.db.Exec("INSERT INTO " + repo.TABLE_NAME + " (domain) " +
+		"SELECT name FROM domain WHERE NOT disabled AND name NOT IN (SELECT domain FROM " + repo.TABLE_NAME + ")")
+	if err != nil {
+		return
+	}
+	_, err = repo.db.Exec("DELETE FROM " + repo.TABLE_NAME + " " +
+		"WHERE domain NOT IN (SELECT name FROM domain WHERE NOT disabled)")
+	return
+}
+
+// Takes the schedule which is due the longest and moves its next check to next,
+// so it is not picked up again while the check is queued or running.
+// Returns sql.ErrNoRows if no domain is due.
+func (repo *DomainScheduleRepository) ClaimDue(now time.Time, next time.Time) (schedule *DomainSchedule, err error) {
+	schedule = new(DomainSchedule)
+	err = repo.db.QueryRow("UPDATE "+repo.TABLE_NAME+" SET next_check = $2 "+
+		"WHERE "+repo.ID_FIELD+" = (SELECT "+repo.ID_FIELD+" FROM "+repo.TABLE_NAME+" WHERE next_check <= $1 ORDER BY next_check ASC LIMIT 1 FOR UPDATE SKIP LOCKED) "+
+		"RETURNING "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD, now, next).Scan(&schedule.Id, &schedule.Domain, &schedule.NextCheck, &schedule.LastCheck, &schedule.Created)
+	return
+}
+
+func (repo *DomainScheduleRepository) rowsToResult(rows *sql.Rows) (schedules []*DomainSchedule, err error) {
+	schedules = make([]*DomainSchedule, 0)
+	for rows.Next() {
+		var schedule = new(DomainSchedule)
+		err = rows.Scan(&schedule.Id, &schedule.Domain, &schedule.NextCheck, &schedule.LastCheck, &schedule.Created)
+		if err != nil {
+			return
+		}
+		schedules = append(schedules, schedule)
+	}
+	err = rows.Err()
+	return
+}
+
+func (repo *DomainScheduleRepository) FindAll() (schedules []*DomainSchedule, err error) {
+	rows, err := repo.db.Query("SELECT " + repo.ID_FIELD + "," + repo.FIELDS + "," + repo.CREATED_FIELD + " FROM " + repo.TABLE_NAME + " ORDER BY " + repo.ID_FIELD + " ASC")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	schedules, err = repo.rowsToResult(rows)
+	return
+}
+
+func (repo *DomainScheduleRepository) FindByDomain(domain string) (schedule *DomainSchedule, err error) {
+	schedule = new(DomainSchedule)
+	err = repo.db.QueryRow("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" WHERE domain = $1", domain).Scan(&schedule.Id, &schedule.Domain, &schedule.NextCheck, &schedule.LastCheck, &schedule.Created)
+	return
+}
@@ -3,7 +3,9 @@ package hivdomainstatus
 import (
 	"code.google.com/p/gcfg"
 	"database/sql"
+	"fmt"
 	"testing"
+	"time"
 	assert "github.com/stretchr/testify/assert"
 )
 
@@ -37,3 +39,188 @@ func TestThatItPersistsADomain(t *testing.T) {
 	assert.Equal(1, d.Id)
 	assert.Equal("example.hiv", d.Name)
 }
+
+func TestThatItPersistsAndFiltersDomainMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewDefaultConfig()
+	configErr := gcfg.ReadFileInto(c, "config.ini")
+	if configErr != nil {
+		t.Fatal(configErr)
+	}
+	db, _ := sql.Open("postgres", c.DSN())
+	db.Exec("TRUNCATE domain RESTART IDENTITY")
+	repo := NewDomainRepository(db)
+
+	domain := new(Domain)
+	domain.Name = "example.hiv"
+	domain.OwnerName = "Example Inc."
+	domain.Contacts = []string{"owner@example.com", "admin@example.com"}
+	domain.Registrar = "ACME Registrar"
+	domain.Notes = "Account manager: Jane"
+	domain.ExternalRef = "CRM-1"
+	assert.Nil(repo.Persist(domain))
+	other := new(Domain)
+	other.Name = "acme.hiv"
+	other.Registrar = "Other Registrar"
+	assert.Nil(repo.Persist(other))
+
+	d, findErr := repo.FindByName("example.hiv")
+	assert.Nil(findErr)
+	assert.Equal("Example Inc.", d.OwnerName)
+	assert.Equal([]string{"owner@example.com", "admin@example.com"}, d.Contacts)
+	assert.Equal("ACME Registrar", d.Registrar)
+	assert.Equal("Account manager: Jane", d.Notes)
+	assert.Equal("CRM-1", d.ExternalRef)
+
+	for _, filter := range []*DomainFilter{
+		&DomainFilter{Owner: "example"},
+		&DomainFilter{Contact: "Admin@example.com"},
+		&DomainFilter{Registrar: "acme registrar"},
+		&DomainFilter{ExternalRef: "CRM-1", Registrar: "ACME Registrar"},
+	} {
+		domains, err := repo.FindPaginated(10, "", filter)
+		assert.Nil(err)
+		assert.Equal(1, len(domains))
+		assert.Equal("example.hiv", domains[0].Name)
+		count, _, statsErr := repo.Stats(filter)
+		assert.Nil(statsErr)
+		assert.Equal(1, count)
+	}
+	domains, _ := repo.FindPaginated(10, "", &DomainFilter{Contact: "nobody@example.com"})
+	assert.Equal(0, len(domains))
+	// Wildcards are matched literally
+	domains, _ = repo.FindPaginated(10, "", &DomainFilter{Owner: "%"})
+	assert.Equal(0, len(domains))
+	domains, _ = repo.FindPaginated(10, "", nil)
+	assert.Equal(2, len(domains))
+}
+
+func TestThatItFiltersAndSortsDomains(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewDefaultConfig()
+	configErr := gcfg.ReadFileInto(c, "config.ini")
+	if configErr != nil {
+		t.Fatal(configErr)
+	}
+	db, _ := sql.Open("postgres", c.DSN())
+	db.Exec("TRUNCATE domain RESTART IDENTITY")
+	db.Exec("TRUNCATE domain_check RESTART IDENTITY")
+	db.Exec("TRUNCATE domain_schedule RESTART IDENTITY")
+	repo := NewDomainRepository(db)
+	checkRepo := NewDomainCheckRepository(db)
+
+	// example.hiv works, acme.hiv has no DNS, beta.hiv returns 500, gamma.hiv was never checked
+	for _, name := range []string{"example.hiv", "acme.hiv", "beta.hiv", "gamma.hiv"} {
+		domain := new(Domain)
+		domain.Name = name
+		domain.Valid = name == "example.hiv"
+		assert.Nil(repo.Persist(domain))
+	}
+	for _, check := range []*DomainCheck{
+		&DomainCheck{Domain: "example.hiv", DnsOK: true, StatusCode: 200, ScriptPresent: true, Valid: true},
+		&DomainCheck{Domain: "acme.hiv"},
+		&DomainCheck{Domain: "beta.hiv", DnsOK: true, StatusCode: 500},
+	} {
+		assert.Nil(checkRepo.Persist(check))
+	}
+
+	valid := false
+	hourAgo := time.Now().Add(-time.Hour)
+	names := func(filter *DomainFilter) (names []string) {
+		domains, err := repo.FindPaginated(10, "", filter)
+		assert.Nil(err)
+		names = make([]string, len(domains))
+		for i, domain := range domains {
+			names[i] = domain.Name
+		}
+		return
+	}
+	assert.Equal([]string{"acme.hiv", "beta.hiv", "gamma.hiv"}, names(&DomainFilter{Valid: &valid}))
+	assert.Equal([]string{"acme.hiv"}, names(&DomainFilter{Name: "CM"}))
+	assert.Equal([]string{"beta.hiv"}, names(&DomainFilter{NamePrefix: "b"}))
+	assert.Equal([]string{}, names(&DomainFilter{NamePrefix: "%"}))
+	assert.Equal([]string{"acme.hiv"}, names(&DomainFilter{FailureReason: FAILURE_REASON_DNS}))
+	assert.Equal([]string{"beta.hiv"}, names(&DomainFilter{FailureReason: FAILURE_REASON_HTTP}))
+	assert.Equal([]string{"gamma.hiv"}, names(&DomainFilter{LastCheckedBefore: &hourAgo}))
+	assert.Equal([]string{}, names(&DomainFilter{CreatedBefore: &hourAgo}))
+	assert.Equal([]string{"example.hiv", "acme.hiv", "beta.hiv", "gamma.hiv"}, names(&DomainFilter{CreatedAfter: &hourAgo}))
+	assert.Equal([]string{"gamma.hiv", "example.hiv", "beta.hiv", "acme.hiv"}, names(&DomainFilter{Sort: "-name"}))
+	count, _, _ := repo.Stats(&DomainFilter{Valid: &valid})
+	assert.Equal(3, count)
+
+	// Pages continue after the last domain in sort order
+	page, err := repo.FindPaginated(2, "", &DomainFilter{Sort: "name"})
+	assert.Nil(err)
+	assert.Equal("acme.hiv", page[0].Name)
+	assert.Equal("beta.hiv", page[1].Name)
+	page, err = repo.FindPaginated(2, fmt.Sprintf("%d", page[1].Id), &DomainFilter{Sort: "name"})
+	assert.Nil(err)
+	assert.Equal("example.hiv", page[0].Name)
+	assert.Equal("gamma.hiv", page[1].Name)
+
+	// Also if the last domain has been removed meanwhile
+	filter := &DomainFilter{Sort: "-created"}
+	page, err = repo.FindPaginated(2, "", filter)
+	assert.Nil(err)
+	offsetKey := filter.offsetKey(page[1])
+	assert.Nil(repo.Remove(page[1]))
+	next, err := repo.FindPaginated(2, offsetKey, filter)
+	assert.Nil(err)
+	assert.Equal(2, len(next))
+	assert.NotEqual(page[0].Id, next[0].Id)
+
+	_, err = repo.FindPaginated(2, "", &DomainFilter{Sort: "owner"})
+	assert.NotNil(err)
+}
+
+func TestThatItRenamesTheChecksOfADomain(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewDefaultConfig()
+	configErr := gcfg.ReadFileInto(c, "config.ini")
+	if configErr != nil {
+		t.Fatal(configErr)
+	}
+	db, _ := sql.Open("postgres", c.DSN())
+	db.Exec("TRUNCATE domain RESTART IDENTITY")
+	db.Exec("TRUNCATE domain_check RESTART IDENTITY")
+	db.Exec("TRUNCATE domain_schedule RESTART IDENTITY")
+	db.Exec("TRUNCATE check_job RESTART IDENTITY")
+	db.Exec("TRUNCATE event RESTART IDENTITY")
+	db.Exec("TRUNCATE notification RESTART IDENTITY")
+	repo := NewDomainRepository(db)
+	checkRepo := NewDomainCheckRepository(db)
+
+	domain := new(Domain)
+	domain.Name = "example.hiv"
+	assert.Nil(repo.Persist(domain))
+	check := new(DomainCheck)
+	check.Domain = "example.hiv"
+	assert.Nil(checkRepo.Persist(check))
+	_, enqueueErr := NewCheckJobRepository(db).Enqueue("example.hiv")
+	assert.Nil(enqueueErr)
+
+	domain.Name = "renamed.hiv"
+	assert.Nil(repo.Persist(domain))
+
+	latest, findErr := checkRepo.FindLatestByDomain("renamed.hiv")
+	assert.Nil(findErr)
+	assert.Equal(check.Id, latest.Id)
+	_, findErr = checkRepo.FindLatestByDomain("example.hiv")
+	assert.Equal(sql.ErrNoRows, findErr)
+	jobs, _ := NewCheckJobRepository(db).FindAll()
+	assert.Equal("renamed.hiv", jobs[0].Domain)
+
+	// An open job left over for the new name is closed
+	jobRepo := NewCheckJobRepository(db)
+	stale, enqueueErr := jobRepo.Enqueue("other.hiv")
+	assert.Nil(enqueueErr)
+	domain.Name = "other.hiv"
+	assert.Nil(repo.Persist(domain))
+	stale, _ = jobRepo.FindById(stale.Id)
+	assert.Equal(JOB_STATE_FAILED, stale.State)
+	open, _ := jobRepo.Enqueue("other.hiv")
+	assert.Equal(jobs[0].Id, open.Id)
+}
@@ -0,0 +1,87 @@
+package hivdomainstatus
+
+import (
+	"database/sql"
+
+	_ "github.com/lib/pq"
+)
+
+type EventRepositoryInterface interface {
+	Persist(event *Event) (err error)
+	PersistTx(tx *sql.Tx, event *Event) (err error)
+	FindAll() (events []*Event, err error)
+	FindSince(id int64, numitems int) (events []*Event, err error)
+}
+
+type EventRepository struct {
+	EventRepositoryInterface
+	db            *sql.DB
+	TABLE_NAME    string
+	ID_FIELD      string
+	FIELDS        string
+	CREATED_FIELD string
+}
+
+func NewEventRepository(db *sql.DB) (repo *EventRepository) {
+	repo = new(EventRepository)
+	repo.db = db
+	repo.TABLE_NAME = "event"
+	repo.FIELDS = "type, domain, valid, previous_valid, check_id, previous_check_id"
+	repo.ID_FIELD = "id"
+	repo.CREATED_FIELD = "created"
+	return
+}
+
+func (repo *EventRepository) Persist(event *Event) (err error) {
+	return repo.persist(repo.db, event)
+}
+
+func (repo *EventRepository) PersistTx(tx *sql.Tx, event *Event) (err error) {
+	return repo.persist(tx, event)
+}
+
+func (repo *EventRepository) persist(q queryer, event *Event) (err error) {
+	err = q.QueryRow("INSERT INTO "+repo.TABLE_NAME+" "+
+		"("+repo.FIELDS+") "+
+		"VALUES($1, $2, $3, $4, $5, $6) RETURNING id, created",
+		event.Type, event.Domain, event.Valid, event.PreviousValid, nullId(event.CheckId), nullId(event.PreviousCheckId)).Scan(&event.Id, &event.Created)
+	return
+}
+
+func (repo *EventRepository) rowsToResult(rows *sql.Rows) (events []*Event, err error) {
+	events = make([]*Event, 0)
+	for rows.Next() {
+		var event = new(Event)
+		var checkId, previousCheckId sql.NullInt64
+		err = rows.Scan(&event.Id, &event.Type, &event.Domain, &event.Valid, &event.PreviousValid, &checkId, &previousCheckId, &event.Created)
+		if err != nil {
+			return
+		}
+		event.CheckId = checkId.Int64
+		event.PreviousCheckId = previousCheckId.Int64
+		events = append(events, event)
+	}
+	err = rows.Err()
+	return
+}
+
+func (repo *EventRepository) FindAll() (events []*Event, err error) {
+	rows, err := repo.db.Query("SELECT " + repo.ID_FIELD + "," + repo.FIELDS + "," + repo.CREATED_FIELD + " FROM " + repo.TABLE_NAME + " ORDER BY " + repo.ID_FIELD + " ASC")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	events, err = repo.rowsToResult(rows)
+	return
+}
+
+// Returns the events after the event with the given id, for replaying them
+func (repo *EventRepository) FindSince(id int64, numitems int) (events []*Event, err error) {
+	rows, err := repo.db.Query("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" WHERE "+repo.ID_FIELD+" > $1 ORDER BY "+repo.ID_FIELD+" ASC LIMIT $2", id, numitems)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	events, err = repo.rowsToResult(rows)
+	return
+}
@@ -0,0 +1,113 @@
+package hivdomainstatus
+
+import (
+	"database/sql"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+type MaintenanceWindowRepositoryInterface interface {
+	Persist(window *MaintenanceWindow) (err error)
+	Remove(window *MaintenanceWindow) (err error)
+	FindById(id int64) (window *MaintenanceWindow, err error)
+	FindByDomain(domainId int64) (windows []*MaintenanceWindow, err error)
+	FindActiveByDomain(domainId int64, at time.Time) (windows []*MaintenanceWindow, err error)
+	FindActiveByDomainTx(tx *sql.Tx, domainId int64, at time.Time) (windows []*MaintenanceWindow, err error)
+}
+
+type MaintenanceWindowRepository struct {
+	MaintenanceWindowRepositoryInterface
+	db            *sql.DB
+	TABLE_NAME    string
+	ID_FIELD      string
+	FIELDS        string
+	CREATED_FIELD string
+}
+
+func NewMaintenanceWindowRepository(db *sql.DB) (repo *MaintenanceWindowRepository) {
+	repo = new(MaintenanceWindowRepository)
+	repo.db = db
+	repo.TABLE_NAME = "maintenance_window"
+	repo.FIELDS = "domain_id, starts, ends, reason"
+	repo.ID_FIELD = "id"
+	repo.CREATED_FIELD = "created"
+	return
+}
+
+func (repo *MaintenanceWindowRepository) Persist(window *MaintenanceWindow) (err error) {
+	if window.Id > 0 {
+		_, err = repo.db.Exec("UPDATE "+repo.TABLE_NAME+" "+
+			"SET starts = $1, ends = $2, reason = $3 WHERE id = $4",
+			window.Starts, window.Ends, window.Reason, window.Id)
+	} else {
+		err = repo.db.QueryRow("INSERT INTO "+repo.TABLE_NAME+" "+
+			"("+repo.FIELDS+") "+
+			"VALUES($1, $2, $3, $4) RETURNING id, created",
+			window.DomainId, window.Starts, window.Ends, window.Reason).Scan(&window.Id, &window.Created)
+	}
+	return
+}
+
+func (repo *MaintenanceWindowRepository) Remove(window *MaintenanceWindow) (err error) {
+	_, err = repo.db.Exec("DELETE FROM "+repo.TABLE_NAME+" "+
+		"WHERE "+repo.ID_FIELD+" = $1",
+		window.Id)
+	return
+}
+
+func (repo *MaintenanceWindowRepository) scan(row rowScanner, window *MaintenanceWindow) (err error) {
+	err = row.Scan(&window.Id, &window.DomainId, &window.Starts, &window.Ends, &window.Reason, &window.Created)
+	return
+}
+
+func (repo *MaintenanceWindowRepository) rowsToResult(rows *sql.Rows) (windows []*MaintenanceWindow, err error) {
+	windows = make([]*MaintenanceWindow, 0)
+	for rows.Next() {
+		var window = new(MaintenanceWindow)
+		err = repo.scan(rows, window)
+		if err != nil {
+			return
+		}
+		windows = append(windows, window)
+	}
+	err = rows.Err()
+	return
+}
+
+func (repo *MaintenanceWindowRepository) FindById(id int64) (window *MaintenanceWindow, err error) {
+	window = new(MaintenanceWindow)
+	row := repo.db.QueryRow("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" WHERE "+repo.ID_FIELD+" = $1", id)
+	err = repo.scan(row, window)
+	return
+}
+
+// Returns the windows of a domain, latest start first
+func (repo *MaintenanceWindowRepository) FindByDomain(domainId int64) (windows []*MaintenanceWindow, err error) {
+	rows, err := repo.db.Query("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" WHERE domain_id = $1 ORDER BY starts DESC, "+repo.ID_FIELD+" DESC", domainId)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	windows, err = repo.rowsToResult(rows)
+	return
+}
+
+// Returns the windows of a domain which cover at
+func (repo *MaintenanceWindowRepository) FindActiveByDomain(domainId int64, at time.Time) (windows []*MaintenanceWindow, err error) {
+	return repo.findActiveByDomain(repo.db, domainId, at)
+}
+
+func (repo *MaintenanceWindowRepository) FindActiveByDomainTx(tx *sql.Tx, domainId int64, at time.Time) (windows []*MaintenanceWindow, err error) {
+	return repo.findActiveByDomain(tx, domainId, at)
+}
+
+func (repo *MaintenanceWindowRepository) findActiveByDomain(q queryer, domainId int64, at time.Time) (windows []*MaintenanceWindow, err error) {
+	rows, err := q.Query("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" WHERE domain_id = $1 AND starts <= $2 AND ends > $2 ORDER BY starts ASC", domainId, at)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	windows, err = repo.rowsToResult(rows)
+	return
+}
@@ -0,0 +1,145 @@
+package hivdomainstatus
+
+import (
+	"database/sql"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+type NotificationRepositoryInterface interface {
+	Persist(notification *Notification) (err error)
+	ClaimPending(lease time.Duration) (notification *Notification, err error)
+	RequeueExpired() (count int, err error)
+	FindLatestByDomain(domain string) (notifications []*Notification, err error)
+	FindDueFollowUps(grace time.Duration) (notifications []*Notification, err error)
+	CountSentSince(since time.Time) (count int, err error)
+	CountSentToSince(email string, since time.Time) (count int, err error)
+	FindAll() (notifications []*Notification, err error)
+}
+
+type NotificationRepository struct {
+	NotificationRepositoryInterface
+	db            *sql.DB
+	TABLE_NAME    string
+	ID_FIELD      string
+	FIELDS        string
+	CREATED_FIELD string
+}
+
+func NewNotificationRepository(db *sql.DB) (repo *NotificationRepository) {
+	repo = new(NotificationRepository)
+	repo.db = db
+	repo.TABLE_NAME = "notification"
+	repo.FIELDS = "domain, email, kind, state, error, sent"
+	repo.ID_FIELD = "id"
+	repo.CREATED_FIELD = "created"
+	return
+}
+
+func (repo *NotificationRepository) Persist(notification *Notification) (err error) {
+	if notification.Id > 0 {
+		_, err = repo.db.Exec("UPDATE "+repo.TABLE_NAME+" "+
+			"SET state = $1, error = $2, sent = $3, lease_until = NULL WHERE id = $4",
+			notification.State, notification.Error, notification.Sent, notification.Id)
+	} else {
+		if len(notification.State) == 0 {
+			notification.State = NOTIFICATION_STATE_PENDING
+		}
+		err = repo.db.QueryRow("INSERT INTO "+repo.TABLE_NAME+" "+
+			"("+repo.FIELDS+") "+
+			"VALUES($1, $2, $3, $4, $5, $6) RETURNING id, created",
+			notification.Domain, notification.Email, notification.Kind, notification.State, notification.Error, notification.Sent).Scan(&notification.Id, &notification.Created)
+	}
+	return
+}
+
+func (repo *NotificationRepository) scan(row rowScanner, notification *Notification) (err error) {
+	err = row.Scan(&notification.Id, &notification.Domain, &notification.Email, &notification.Kind, &notification.State, &notification.Error, &notification.Sent, &notification.Created)
+	return
+}
+
+func (repo *NotificationRepository) rowsToResult(rows *sql.Rows) (notifications []*Notification, err error) {
+	notifications = make([]*Notification, 0)
+	for rows.Next() {
+		var notification = new(Notification)
+		err = repo.scan(rows, notification)
+		if err != nil {
+			return
+		}
+		notifications = append(notifications, notification)
+	}
+	err = rows.Err()
+	return
+}
+
+// Takes the oldest pending notification for lease, so no other notifier sends it.
+// Returns sql.ErrNoRows if nothing is pending.
+func (repo *NotificationRepository) ClaimPending(lease time.Duration) (notification *Notification, err error) {
+	notification = new(Notification)
+	row := repo.db.QueryRow("UPDATE "+repo.TABLE_NAME+" SET state = $1, lease_until = now() + $2 * interval '1 second' "+
+		"WHERE "+repo.ID_FIELD+" = (SELECT "+repo.ID_FIELD+" FROM "+repo.TABLE_NAME+" WHERE state = $3 ORDER BY "+repo.ID_FIELD+" ASC LIMIT 1 FOR UPDATE SKIP LOCKED) "+
+		"RETURNING "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD, NOTIFICATION_STATE_SENDING, lease.Seconds(), NOTIFICATION_STATE_PENDING)
+	err = repo.scan(row, notification)
+	return
+}
+
+// Puts notifications back into the queue whose notifier did not finish sending before the lease expired
+func (repo *NotificationRepository) RequeueExpired() (count int, err error) {
+	res, err := repo.db.Exec("UPDATE "+repo.TABLE_NAME+" SET state = $1, lease_until = NULL "+
+		"WHERE state = $2 AND lease_until < now()",
+		NOTIFICATION_STATE_PENDING, NOTIFICATION_STATE_SENDING)
+	if err != nil {
+		return
+	}
+	affected, err := res.RowsAffected()
+	count = int(affected)
+	return
+}
+
+// Returns the latest notification about domain for every recipient
+func (repo *NotificationRepository) FindLatestByDomain(domain string) (notifications []*Notification, err error) {
+	rows, err := repo.db.Query("SELECT DISTINCT ON (email) "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" WHERE domain = $1 ORDER BY email, "+repo.ID_FIELD+" DESC", domain)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	notifications, err = repo.rowsToResult(rows)
+	return
+}
+
+// Returns the failure notifications of still invalid domains which have been sent
+// longer than grace ago and have not been followed up yet, per recipient
+func (repo *NotificationRepository) FindDueFollowUps(grace time.Duration) (notifications []*Notification, err error) {
+	rows, err := repo.db.Query("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM ("+
+		"SELECT DISTINCT ON (domain, email) "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" ORDER BY domain, email, "+repo.ID_FIELD+" DESC"+
+		") latest WHERE kind = $1 AND state = $2 AND sent < now() - $3 * interval '1 second' "+
+		"AND domain IN (SELECT name FROM domain WHERE valid = false) ORDER BY "+repo.ID_FIELD+" ASC",
+		NOTIFICATION_KIND_FAILURE, NOTIFICATION_STATE_SENT, grace.Seconds())
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	notifications, err = repo.rowsToResult(rows)
+	return
+}
+
+func (repo *NotificationRepository) CountSentSince(since time.Time) (count int, err error) {
+	err = repo.db.QueryRow("SELECT COUNT("+repo.ID_FIELD+") FROM "+repo.TABLE_NAME+" WHERE sent >= $1", since).Scan(&count)
+	return
+}
+
+func (repo *NotificationRepository) CountSentToSince(email string, since time.Time) (count int, err error) {
+	err = repo.db.QueryRow("SELECT COUNT("+repo.ID_FIELD+") FROM "+repo.TABLE_NAME+" WHERE email = $1 AND sent >= $2", email, since).Scan(&count)
+	return
+}
+
+func (repo *NotificationRepository) FindAll() (notifications []*Notification, err error) {
+	rows, err := repo.db.Query("SELECT " + repo.ID_FIELD + "," + repo.FIELDS + "," + repo.CREATED_FIELD + " FROM " + repo.TABLE_NAME + " ORDER BY " + repo.ID_FIELD + " ASC")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	notifications, err = repo.rowsToResult(rows)
+	return
+}
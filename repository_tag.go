@@ -0,0 +1,125 @@
+package hivdomainstatus
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+type TagRepositoryInterface interface {
+	FindAll() (tags []*Tag, err error)
+	FindByDomain(domainId int64) (tags []string, err error)
+	SetDomainTags(domainId int64, tags []string) (err error)
+	SetDomainTagsTx(tx *sql.Tx, domainId int64, tags []string) (err error)
+	AddDomainTag(domainId int64, tag string) (err error)
+	RemoveDomainTag(domainId int64, tag string) (err error)
+}
+
+type TagRepository struct {
+	TagRepositoryInterface
+	db            *sql.DB
+	TABLE_NAME    string
+	ID_FIELD      string
+	FIELDS        string
+	CREATED_FIELD string
+}
+
+func NewTagRepository(db *sql.DB) (repo *TagRepository) {
+	repo = new(TagRepository)
+	repo.db = db
+	repo.TABLE_NAME = "tag"
+	repo.FIELDS = "name"
+	repo.ID_FIELD = "id"
+	repo.CREATED_FIELD = "created"
+	return
+}
+
+// Returns all tags with the number of tagged domains, ordered by name
+func (repo *TagRepository) FindAll() (tags []*Tag, err error) {
+	rows, err := repo.db.Query("SELECT t." + repo.ID_FIELD + ", t.name, COUNT(dt.domain_id), t." + repo.CREATED_FIELD + " FROM " + repo.TABLE_NAME + " t " +
+		"LEFT JOIN domain_tag dt ON dt.tag_id = t." + repo.ID_FIELD + " GROUP BY t." + repo.ID_FIELD + " ORDER BY t.name ASC")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	tags = make([]*Tag, 0)
+	for rows.Next() {
+		var tag = new(Tag)
+		err = rows.Scan(&tag.Id, &tag.Name, &tag.Domains, &tag.Created)
+		if err != nil {
+			return
+		}
+		tags = append(tags, tag)
+	}
+	err = rows.Err()
+	return
+}
+
+// Returns the names of the tags of a domain, ordered by name
+func (repo *TagRepository) FindByDomain(domainId int64) (tags []string, err error) {
+	rows, err := repo.db.Query("SELECT t.name FROM "+repo.TABLE_NAME+" t JOIN domain_tag dt ON dt.tag_id = t."+repo.ID_FIELD+" WHERE dt.domain_id = $1 ORDER BY t.name ASC", domainId)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	tags = make([]string, 0)
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return
+		}
+		tags = append(tags, name)
+	}
+	err = rows.Err()
+	return
+}
+
+// Replaces the tags of a domain, missing tags are created
+func (repo *TagRepository) SetDomainTags(domainId int64, tags []string) (err error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return
+	}
+	err = repo.SetDomainTagsTx(tx, domainId, tags)
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+func (repo *TagRepository) SetDomainTagsTx(tx *sql.Tx, domainId int64, tags []string) (err error) {
+	_, err = tx.Exec("DELETE FROM domain_tag WHERE domain_id = $1", domainId)
+	if err != nil {
+		return
+	}
+	err = repo.addDomainTags(tx, domainId, tags)
+	return
+}
+
+func (repo *TagRepository) AddDomainTag(domainId int64, tag string) (err error) {
+	return repo.addDomainTags(repo.db, domainId, []string{tag})
+}
+
+func (repo *TagRepository) addDomainTags(q queryer, domainId int64, tags []string) (err error) {
+	if len(tags) == 0 {
+		return
+	}
+	_, err = q.Exec("INSERT INTO "+repo.TABLE_NAME+" (name) "+
+		"SELECT DISTINCT n FROM unnest($1::varchar[]) n "+
+		"ON CONFLICT (name) DO NOTHING", pq.Array(tags))
+	if err != nil {
+		return
+	}
+	_, err = q.Exec("INSERT INTO domain_tag (domain_id, tag_id) "+
+		"SELECT $1, "+repo.ID_FIELD+" FROM "+repo.TABLE_NAME+" WHERE name = ANY($2::varchar[]) "+
+		"ON CONFLICT DO NOTHING", domainId, pq.Array(tags))
+	return
+}
+
+func (repo *TagRepository) RemoveDomainTag(domainId int64, tag string) (err error) {
+	_, err = repo.db.Exec("DELETE FROM domain_tag WHERE domain_id = $1 AND tag_id IN (SELECT "+repo.ID_FIELD+" FROM "+repo.TABLE_NAME+" WHERE name = $2)", domainId, tag)
+	return
+}
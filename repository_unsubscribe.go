@@ -0,0 +1,40 @@
+package hivdomainstatus
+
+import (
+	"database/sql"
+	"strings"
+
+	_ "github.com/lib/pq"
+)
+
+// Stores the email addresses which do not want to receive notifications
+type UnsubscribeRepositoryInterface interface {
+	Unsubscribe(email string) (err error)
+	IsUnsubscribed(email string) (unsubscribed bool, err error)
+}
+
+type UnsubscribeRepository struct {
+	UnsubscribeRepositoryInterface
+	db         *sql.DB
+	TABLE_NAME string
+}
+
+func NewUnsubscribeRepository(db *sql.DB) (repo *UnsubscribeRepository) {
+	repo = new(UnsubscribeRepository)
+	repo.db = db
+	repo.TABLE_NAME = "unsubscribe"
+	return
+}
+
+func (repo *UnsubscribeRepository) Unsubscribe(email string) (err error) {
+	email = strings.ToLower(email)
+	_, err = repo.db.Exec("INSERT INTO "+repo.TABLE_NAME+" (email) "+
+		"SELECT $1::varchar WHERE NOT EXISTS (SELECT 1 FROM "+repo.TABLE_NAME+" WHERE email = $1)", email)
+	return
+}
+
+func (repo *UnsubscribeRepository) IsUnsubscribed(email string) (unsubscribed bool, err error) {
+	email = strings.ToLower(email)
+	err = repo.db.QueryRow("SELECT EXISTS (SELECT 1 FROM "+repo.TABLE_NAME+" WHERE email = $1)", email).Scan(&unsubscribed)
+	return
+}
@@ -0,0 +1,129 @@
+package hivdomainstatus
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	_ "github.com/lib/pq"
+)
+
+type WebhookRepositoryInterface interface {
+	Persist(webhook *Webhook) (err error)
+	Remove(webhook *Webhook) (err error)
+	FindAll() (webhooks []*Webhook, err error)
+	FindPaginated(numitems int, offsetKey string) (webhooks []*Webhook, err error)
+	Stats() (count int, maxKey string, err error)
+	FindById(id int64) (webhook *Webhook, err error)
+}
+
+type WebhookRepository struct {
+	WebhookRepositoryInterface
+	db            *sql.DB
+	TABLE_NAME    string
+	ID_FIELD      string
+	FIELDS        string
+	CREATED_FIELD string
+}
+
+func NewWebhookRepository(db *sql.DB) (repo *WebhookRepository) {
+	repo = new(WebhookRepository)
+	repo.db = db
+	repo.TABLE_NAME = "webhook"
+	repo.FIELDS = "url, secret, events"
+	repo.ID_FIELD = "id"
+	repo.CREATED_FIELD = "created"
+	return
+}
+
+func (repo *WebhookRepository) Persist(webhook *Webhook) (err error) {
+	webhook.EventsJson, err = json.Marshal(webhook.Events)
+	if err != nil {
+		return
+	}
+	if webhook.Id > 0 {
+		_, err = repo.db.Exec("UPDATE "+repo.TABLE_NAME+" "+
+			"SET url = $1, secret = $2, events = $3 WHERE id = $4",
+			webhook.URL, webhook.Secret, webhook.EventsJson, webhook.Id)
+	} else {
+		err = repo.db.QueryRow("INSERT INTO "+repo.TABLE_NAME+" "+
+			"("+repo.FIELDS+") "+
+			"VALUES($1, $2, $3) RETURNING id, created",
+			webhook.URL, webhook.Secret, webhook.EventsJson).Scan(&webhook.Id, &webhook.Created)
+	}
+	return
+}
+
+func (repo *WebhookRepository) Remove(webhook *Webhook) (err error) {
+	_, err = repo.db.Exec("DELETE FROM "+repo.TABLE_NAME+" "+
+		"WHERE "+repo.ID_FIELD+" = $1",
+		webhook.Id)
+	return
+}
+
+func (repo *WebhookRepository) scan(row rowScanner, webhook *Webhook) (err error) {
+	err = row.Scan(&webhook.Id, &webhook.URL, &webhook.Secret, &webhook.EventsJson, &webhook.Created)
+	if err != nil {
+		return
+	}
+	if len(webhook.EventsJson) > 0 {
+		err = json.Unmarshal(webhook.EventsJson, &webhook.Events)
+	}
+	return
+}
+
+func (repo *WebhookRepository) rowsToResult(rows *sql.Rows) (webhooks []*Webhook, err error) {
+	webhooks = make([]*Webhook, 0)
+	for rows.Next() {
+		var webhook = new(Webhook)
+		err = repo.scan(rows, webhook)
+		if err != nil {
+			return
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	err = rows.Err()
+	return
+}
+
+func (repo *WebhookRepository) FindAll() (webhooks []*Webhook, err error) {
+	rows, err := repo.db.Query("SELECT " + repo.ID_FIELD + "," + repo.FIELDS + "," + repo.CREATED_FIELD + " FROM " + repo.TABLE_NAME + " ORDER BY " + repo.ID_FIELD + " ASC")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	webhooks, err = repo.rowsToResult(rows)
+	return
+}
+
+func (repo *WebhookRepository) FindPaginated(numitems int, offsetKey string) (webhooks []*Webhook, err error) {
+	var rows *sql.Rows
+	if len(offsetKey) > 0 {
+		rows, err = repo.db.Query("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" "+"FROM "+repo.TABLE_NAME+" WHERE "+repo.ID_FIELD+" > $1 ORDER BY "+repo.ID_FIELD+" ASC LIMIT $2", offsetKey, numitems)
+	} else {
+		rows, err = repo.db.Query("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" "+"FROM "+repo.TABLE_NAME+" ORDER BY "+repo.ID_FIELD+" ASC LIMIT $1", numitems)
+	}
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	webhooks, err = repo.rowsToResult(rows)
+	return
+}
+
+func (repo *WebhookRepository) Stats() (count int, maxKey string, err error) {
+	var maxKeyInt sql.NullInt64
+	err = repo.db.QueryRow("SELECT COUNT("+repo.ID_FIELD+"), MAX("+repo.ID_FIELD+") FROM "+repo.TABLE_NAME).Scan(&count, &maxKeyInt)
+	if maxKeyInt.Valid {
+		// If table is empty MAX(id) is null
+		maxKey = fmt.Sprintf("%d", maxKeyInt.Int64)
+	}
+	return
+}
+
+func (repo *WebhookRepository) FindById(id int64) (webhook *Webhook, err error) {
+	webhook = new(Webhook)
+	row := repo.db.QueryRow("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" WHERE "+repo.ID_FIELD+" = $1", id)
+	err = repo.scan(row, webhook)
+	return
+}
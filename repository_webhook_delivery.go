@@ -0,0 +1,100 @@
+package hivdomainstatus
+
+import (
+	"database/sql"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+type WebhookDeliveryRepositoryInterface interface {
+	Persist(delivery *WebhookDelivery) (err error)
+	ClaimDue(lease time.Duration) (delivery *WebhookDelivery, err error)
+	FindByWebhook(webhookId int64, numitems int, offsetKey string) (deliveries []*WebhookDelivery, err error)
+	StatsByWebhook(webhookId int64) (count int, err error)
+}
+
+type WebhookDeliveryRepository struct {
+	WebhookDeliveryRepositoryInterface
+	db            *sql.DB
+	TABLE_NAME    string
+	ID_FIELD      string
+	FIELDS        string
+	CREATED_FIELD string
+}
+
+func NewWebhookDeliveryRepository(db *sql.DB) (repo *WebhookDeliveryRepository) {
+	repo = new(WebhookDeliveryRepository)
+	repo.db = db
+	repo.TABLE_NAME = "webhook_delivery"
+	repo.FIELDS = "webhook_id, event_type, payload, state, attempts, next_attempt, status_code, response, error, delivered"
+	repo.ID_FIELD = "id"
+	repo.CREATED_FIELD = "created"
+	return
+}
+
+func (repo *WebhookDeliveryRepository) Persist(delivery *WebhookDelivery) (err error) {
+	if delivery.Id > 0 {
+		_, err = repo.db.Exec("UPDATE "+repo.TABLE_NAME+" "+
+			"SET state = $1, attempts = $2, next_attempt = $3, status_code = $4, response = $5, error = $6, delivered = $7 WHERE id = $8",
+			delivery.State, delivery.Attempts, delivery.NextAttempt, delivery.StatusCode, delivery.Response, delivery.Error, delivery.Delivered, delivery.Id)
+	} else {
+		if len(delivery.State) == 0 {
+			delivery.State = DELIVERY_STATE_PENDING
+		}
+		err = repo.db.QueryRow("INSERT INTO "+repo.TABLE_NAME+" "+
+			"(webhook_id, event_type, payload, state) "+
+			"VALUES($1, $2, $3, $4) RETURNING id, next_attempt, created",
+			delivery.WebhookId, delivery.EventType, string(delivery.Payload), delivery.State).Scan(&delivery.Id, &delivery.NextAttempt, &delivery.Created)
+	}
+	return
+}
+
+func (repo *WebhookDeliveryRepository) scan(row rowScanner, delivery *WebhookDelivery) (err error) {
+	var payload string
+	err = row.Scan(&delivery.Id, &delivery.WebhookId, &delivery.EventType, &payload, &delivery.State, &delivery.Attempts, &delivery.NextAttempt, &delivery.StatusCode, &delivery.Response, &delivery.Error, &delivery.Delivered, &delivery.Created)
+	delivery.Payload = []byte(payload)
+	return
+}
+
+// Takes the pending delivery which is due the longest and moves its next attempt
+// by lease, so no other dispatcher sends it at the same time.
+// Returns sql.ErrNoRows if no delivery is due.
+func (repo *WebhookDeliveryRepository) ClaimDue(lease time.Duration) (delivery *WebhookDelivery, err error) {
+	delivery = new(WebhookDelivery)
+	row := repo.db.QueryRow("UPDATE "+repo.TABLE_NAME+" SET next_attempt = now() + $1 * interval '1 second' "+
+		"WHERE "+repo.ID_FIELD+" = (SELECT "+repo.ID_FIELD+" FROM "+repo.TABLE_NAME+" WHERE state = $2 AND next_attempt <= now() ORDER BY next_attempt ASC LIMIT 1 FOR UPDATE SKIP LOCKED) "+
+		"RETURNING "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD, lease.Seconds(), DELIVERY_STATE_PENDING)
+	err = repo.scan(row, delivery)
+	return
+}
+
+// Returns the deliveries of a webhook, newest first
+func (repo *WebhookDeliveryRepository) FindByWebhook(webhookId int64, numitems int, offsetKey string) (deliveries []*WebhookDelivery, err error) {
+	var rows *sql.Rows
+	if len(offsetKey) > 0 {
+		rows, err = repo.db.Query("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" WHERE webhook_id = $1 AND "+repo.ID_FIELD+" < $2 ORDER BY "+repo.ID_FIELD+" DESC LIMIT $3", webhookId, offsetKey, numitems)
+	} else {
+		rows, err = repo.db.Query("SELECT "+repo.ID_FIELD+","+repo.FIELDS+","+repo.CREATED_FIELD+" FROM "+repo.TABLE_NAME+" WHERE webhook_id = $1 ORDER BY "+repo.ID_FIELD+" DESC LIMIT $2", webhookId, numitems)
+	}
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	deliveries = make([]*WebhookDelivery, 0)
+	for rows.Next() {
+		var delivery = new(WebhookDelivery)
+		err = repo.scan(rows, delivery)
+		if err != nil {
+			return
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	err = rows.Err()
+	return
+}
+
+func (repo *WebhookDeliveryRepository) StatsByWebhook(webhookId int64) (count int, err error) {
+	err = repo.db.QueryRow("SELECT COUNT("+repo.ID_FIELD+") FROM "+repo.TABLE_NAME+" WHERE webhook_id = $1", webhookId).Scan(&count)
+	return
+}
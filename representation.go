@@ -0,0 +1,239 @@
+package hivdomainstatus
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Media types served by the API
+const (
+	MEDIA_TYPE_JSON   = "application/json"
+	MEDIA_TYPE_JSONLD = "application/ld+json"
+	MEDIA_TYPE_HAL    = "application/hal+json"
+	MEDIA_TYPE_CSV    = "text/csv"
+	MEDIA_TYPE_NDJSON = "application/x-ndjson"
+)
+
+// Representations of a single resource, the first one is the default
+var itemMediaTypes = []string{MEDIA_TYPE_JSONLD, MEDIA_TYPE_JSON, MEDIA_TYPE_HAL}
+
+// The tags of a domain are a plain JSON array
+var tagsMediaTypes = []string{MEDIA_TYPE_JSON}
+
+// Lists can also be fetched as CSV
+var listMediaTypes = []string{MEDIA_TYPE_JSONLD, MEDIA_TYPE_JSON, MEDIA_TYPE_HAL, MEDIA_TYPE_CSV}
+
+// Properties holding the URL of a related resource and their HAL link relation
+var halLinkRelations = map[string]string{
+	"domainLink": "domain",
+	"previous":   "prev",
+	"next":       "next",
+	"deliveries": "deliveries",
+}
+
+// Returns the media type in offered which matches the Accept header best.
+// ok is false if none of them is acceptable.
+func negotiate(r *http.Request, offered []string) (mediaType string, ok bool) {
+	accept := r.Header.Get("Accept")
+	if len(strings.TrimSpace(accept)) == 0 {
+		return offered[0], true
+	}
+	best := 0.0
+	for _, candidate := range offered {
+		q := acceptQuality(accept, candidate)
+		if q > best {
+			best = q
+			mediaType = candidate
+			ok = true
+		}
+	}
+	return
+}
+
+// Returns the quality of the most specific range in accept which matches mediaType
+func acceptQuality(accept string, mediaType string) (q float64) {
+	specificity := -1
+	for _, accepted := range strings.Split(accept, ",") {
+		params := strings.Split(accepted, ";")
+		acceptedType := strings.ToLower(strings.TrimSpace(params[0]))
+		s := 0
+		switch {
+		case acceptedType == mediaType:
+			s = 2
+		case acceptedType == "*/*":
+			s = 0
+		case strings.HasSuffix(acceptedType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(acceptedType, "*")):
+			s = 1
+		default:
+			continue
+		}
+		if s < specificity {
+			continue
+		}
+		specificity = s
+		q = 1.0
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && kv[0] == "q" {
+				parsed, err := strconv.ParseFloat(kv[1], 64)
+				if err == nil {
+					q = parsed
+				}
+			}
+		}
+	}
+	return
+}
+
+// Negotiates the representation of a response, answers with 406 if none of offered is acceptable
+func negotiateResponse(w http.ResponseWriter, r *http.Request, offered []string) (mediaType string, ok bool) {
+	w.Header().Set("Vary", "Accept")
+	mediaType, ok = negotiate(r, offered)
+	if !ok {
+		HttpProblem(w, r, http.StatusNotAcceptable, "Not acceptable: "+r.Header.Get("Accept")+", available: "+strings.Join(offered, ", "))
+	}
+	return
+}
+
+// Writes the JSON-LD model m as mediaType, links are added to HAL documents
+func writeModel(w http.ResponseWriter, mediaType string, m interface{}, links map[string]string) {
+	writeModelStatus(w, http.StatusOK, mediaType, m, links)
+}
+
+// Like writeModel, but answers with status
+func writeModelStatus(w http.ResponseWriter, status int, mediaType string, m interface{}, links map[string]string) {
+	w.Header().Set("Content-Type", mediaType)
+	encoder := json.NewEncoder(w)
+	if mediaType != MEDIA_TYPE_HAL {
+		w.WriteHeader(status)
+		encoder.Encode(m)
+		return
+	}
+	hal, err := toHal(m)
+	if err != nil {
+		return
+	}
+	halLinks := hal["_links"].(map[string]interface{})
+	for rel, href := range links {
+		halLinks[rel] = map[string]string{"href": href}
+	}
+	w.WriteHeader(status)
+	encoder.Encode(hal)
+}
+
+// Writes a CSV document with a header row
+func writeCsv(w http.ResponseWriter, header []string, records [][]string) {
+	w.Header().Set("Content-Type", MEDIA_TYPE_CSV+"; charset=utf-8")
+	csvWriter := csv.NewWriter(w)
+	csvWriter.Write(header)
+	for _, record := range records {
+		csvWriter.Write(escapeCsvFormulas(record))
+	}
+	csvWriter.Flush()
+}
+
+// Prefixes cells which spreadsheets would run as formula with a quote
+func escapeCsvFormulas(record []string) []string {
+	escaped := make([]string, len(record))
+	for i, cell := range record {
+		if len(cell) > 0 && strings.ContainsAny(cell[:1], "=+-@") {
+			cell = "'" + cell
+		}
+		escaped[i] = cell
+	}
+	return escaped
+}
+
+// Converts a JSON-LD model to HAL: @id becomes the self link,
+// nested resources and the items of lists are embedded
+func toHal(m interface{}) (hal map[string]interface{}, err error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	hal = make(map[string]interface{})
+	err = json.Unmarshal(b, &hal)
+	if err != nil {
+		return
+	}
+	hal = halResource(hal)
+	return
+}
+
+func halResource(doc map[string]interface{}) map[string]interface{} {
+	links := make(map[string]interface{})
+	embedded := make(map[string]interface{})
+	if id, ok := doc["@id"].(string); ok && len(id) > 0 {
+		links["self"] = map[string]string{"href": id}
+	}
+	delete(doc, "@context")
+	delete(doc, "@id")
+	delete(doc, "@type")
+	for property, rel := range halLinkRelations {
+		if href, ok := doc[property].(string); ok {
+			links[rel] = map[string]string{"href": href}
+			delete(doc, property)
+		}
+	}
+	for key, value := range doc {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			id, ok := value["@id"].(string)
+			if !ok {
+				continue
+			}
+			if isReference(value) {
+				links[key] = map[string]string{"href": id}
+			} else {
+				embedded[key] = halResource(value)
+			}
+			delete(doc, key)
+		case []interface{}:
+			if key != "items" && !areResources(value) {
+				continue
+			}
+			items := make([]interface{}, len(value))
+			for i, item := range value {
+				if resource, ok := item.(map[string]interface{}); ok {
+					items[i] = halResource(resource)
+				} else {
+					items[i] = item
+				}
+			}
+			embedded[key] = items
+			delete(doc, key)
+		}
+	}
+	doc["_links"] = links
+	if len(embedded) > 0 {
+		doc["_embedded"] = embedded
+	}
+	return doc
+}
+
+// Returns true if values is a non-empty list of JSON-LD resources
+func areResources(values []interface{}) bool {
+	for _, value := range values {
+		resource, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if _, ok := resource["@id"]; !ok {
+			return false
+		}
+	}
+	return len(values) > 0
+}
+
+// Returns true if resource only consists of JSON-LD keywords and thus is a link
+func isReference(resource map[string]interface{}) bool {
+	for key := range resource {
+		if !strings.HasPrefix(key, "@") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package hivdomainstatus
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatItNegotiatesMediaTypes(t *testing.T) {
+	assert := assert.New(t)
+
+	for accept, expected := range map[string]string{
+		"":                                    MEDIA_TYPE_JSONLD,
+		"*/*":                                 MEDIA_TYPE_JSONLD,
+		"application/json":                    MEDIA_TYPE_JSON,
+		"application/hal+json, */*;q=0.1":     MEDIA_TYPE_HAL,
+		"text/*":                              MEDIA_TYPE_CSV,
+		"application/ld+json;q=0.5, text/csv": MEDIA_TYPE_CSV,
+		"text/html, application/*;q=0.9":      MEDIA_TYPE_JSONLD,
+		"application/json, application/*;q=0": MEDIA_TYPE_JSON,
+	} {
+		r, _ := http.NewRequest("GET", "http://localhost/", nil)
+		r.Header.Set("Accept", accept)
+		mediaType, ok := negotiate(r, listMediaTypes)
+		assert.True(ok, accept)
+		assert.Equal(expected, mediaType, accept)
+	}
+
+	for _, accept := range []string{"text/html", "text/csv", "application/json;q=0"} {
+		r, _ := http.NewRequest("GET", "http://localhost/", nil)
+		r.Header.Set("Accept", accept)
+		_, ok := negotiate(r, itemMediaTypes)
+		assert.False(ok, accept)
+	}
+}
+
+func TestThatItConvertsModelsToHal(t *testing.T) {
+	assert := assert.New(t)
+
+	list := new(DomainListModel)
+	list.JsonLDContext = "http://jsonld.click4life.hiv/List"
+	list.JsonLDId = "http://localhost/domain"
+	list.Total = 1
+	m := new(DomainModel)
+	m.JsonLDContext = "http://jsonld.click4life.hiv/Domain"
+	m.JsonLDId = "http://localhost/domain/1"
+	m.Name = "example.hiv"
+	m.Check = new(DomainCheckModel)
+	m.Check.JsonLDId = "http://localhost/check/2"
+	m.Check.Previous = "http://localhost/check/1"
+	list.Items = []*DomainModel{m}
+
+	hal, err := toHal(list)
+	assert.Nil(err)
+	assert.Nil(hal["@context"])
+	assert.Equal(float64(1), hal["total"])
+	assert.Equal(map[string]string{"href": "http://localhost/domain"}, hal["_links"].(map[string]interface{})["self"])
+
+	items := hal["_embedded"].(map[string]interface{})["items"].([]interface{})
+	assert.Equal(1, len(items))
+	domain := items[0].(map[string]interface{})
+	assert.Equal("example.hiv", domain["name"])
+	assert.Equal(map[string]string{"href": "http://localhost/domain/1"}, domain["_links"].(map[string]interface{})["self"])
+	check := domain["_embedded"].(map[string]interface{})["check"].(map[string]interface{})
+	assert.Equal(map[string]string{"href": "http://localhost/check/1"}, check["_links"].(map[string]interface{})["prev"])
+	assert.Nil(check["previous"])
+
+	// References become links
+	entryPoint := new(EntryPoint)
+	entryPoint.Domains = new(JsonLDTypedModel)
+	entryPoint.Domains.JsonLDId = "/domain"
+	hal, err = toHal(entryPoint)
+	assert.Nil(err)
+	assert.Equal(map[string]string{"href": "/domain"}, hal["_links"].(map[string]interface{})["domains"])
+}
+
+func TestThatItEscapesCsvFormulas(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(
+		[]string{"'=HYPERLINK(\"http://evil\")", "'+1", "'-1", "'@SUM(A1)", "example.hiv", "", "a=b"},
+		escapeCsvFormulas([]string{"=HYPERLINK(\"http://evil\")", "+1", "-1", "@SUM(A1)", "example.hiv", "", "a=b"}))
+}
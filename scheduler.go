@@ -0,0 +1,156 @@
+package hivdomainstatus
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Continuously checks the domains which are due, at most concurrency checks run at the same time.
+// The schedule is stored in the database so it survives restarts.
+// Several schedulers can share the same database.
+type Scheduler struct {
+	config       *Config
+	scheduleRepo DomainScheduleRepositoryInterface
+	jobRepo      CheckJobRepositoryInterface
+	manager      *Manager
+	concurrency  int
+	minInterval  time.Duration
+	maxInterval  time.Duration
+	stableAfter  time.Duration
+	pollInterval time.Duration
+	checkDomain  func(config *Config, domain string) (*DomainCheckResult, error)
+}
+
+func NewScheduler(c *Config, scheduleRepo DomainScheduleRepositoryInterface, jobRepo CheckJobRepositoryInterface, manager *Manager) (s *Scheduler, err error) {
+	s = new(Scheduler)
+	s.config = c
+	s.scheduleRepo = scheduleRepo
+	s.jobRepo = jobRepo
+	s.manager = manager
+	s.checkDomain = CheckDomain
+	s.concurrency = c.Scheduler.Concurrency
+	if s.concurrency < 1 {
+		err = fmt.Errorf("Scheduler concurrency must be at least 1: %d", s.concurrency)
+		return
+	}
+	s.minInterval, err = time.ParseDuration(c.Scheduler.MinInterval)
+	if err != nil {
+		return
+	}
+	s.maxInterval, err = time.ParseDuration(c.Scheduler.MaxInterval)
+	if err != nil {
+		return
+	}
+	if s.maxInterval < s.minInterval {
+		err = fmt.Errorf("Scheduler max interval %s is lower than min interval %s", s.maxInterval, s.minInterval)
+		return
+	}
+	s.stableAfter, err = time.ParseDuration(c.Scheduler.StableAfter)
+	if err != nil {
+		return
+	}
+	s.pollInterval, err = time.ParseDuration(c.Scheduler.PollInterval)
+	return
+}
+
+// Runs until stop is closed, waits for running checks before returning.
+// Due domains are put into the job queue which is worked off by concurrency workers.
+func (s *Scheduler) Run(stop <-chan bool) (err error) {
+	log.Printf("Starting scheduler with %d workers, interval %s to %s ...\n", s.concurrency, s.minInterval, s.maxInterval)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for i := 0; i < s.concurrency; i++ {
+		worker, workerErr := NewWorker(s.config, s.jobRepo, s.manager)
+		if workerErr != nil {
+			err = workerErr
+			return
+		}
+		worker.name = fmt.Sprintf("%s-%d", worker.name, i)
+		worker.scheduler = s
+		worker.checkDomain = s.checkDomain
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker.Run(stop)
+		}()
+	}
+	for {
+		enqueueErr := s.enqueueDue()
+		if enqueueErr != nil {
+			log.Printf("ERROR: Scheduler failed to queue domains: %s\n", enqueueErr.Error())
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(s.pollInterval):
+		}
+	}
+}
+
+// Queues a check for all due domains, new domains are picked up on the way.
+// The next check of a queued domain is moved by the min interval, so it is
+// checked again if the job gets lost.
+func (s *Scheduler) enqueueDue() (err error) {
+	err = s.scheduleRepo.Sync()
+	if err != nil {
+		return
+	}
+	for {
+		now := time.Now()
+		schedule, claimErr := s.scheduleRepo.ClaimDue(now, now.Add(s.minInterval))
+		if claimErr == sql.ErrNoRows {
+			return
+		}
+		if claimErr != nil {
+			err = claimErr
+			return
+		}
+		_, err = s.jobRepo.Enqueue(schedule.Domain)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Computes the next check of domain from its check history
+func (s *Scheduler) Reschedule(domain string) {
+	schedule, err := s.scheduleRepo.FindByDomain(domain)
+	if err != nil {
+		log.Printf("[%s] ERROR: Failed to find schedule: %s\n", domain, err.Error())
+		return
+	}
+	now := time.Now()
+	latest, latestErr := s.manager.domainCheckRepo.FindLatestByDomain(domain)
+	if latestErr != nil {
+		latest = nil
+	}
+	next := now.Add(NextCheckInterval(latest, now, s.minInterval, s.maxInterval, s.stableAfter))
+	schedule.LastCheck = &now
+	schedule.NextCheck = &next
+	err = s.scheduleRepo.Persist(schedule)
+	if err != nil {
+		log.Printf("[%s] ERROR: Failed to update schedule: %s\n", domain, err.Error())
+	}
+}
+
+// Computes the time until the next check of a domain from its latest check.
+// Checks are only stored if the result changed, so the latest check tells
+// since when the domain is in its current state.
+// Failing and recently changed domains are checked after min, the interval
+// grows with the time a domain has been valid and reaches max after stableAfter.
+func NextCheckInterval(latest *DomainCheck, now time.Time, min time.Duration, max time.Duration, stableAfter time.Duration) time.Duration {
+	if latest == nil || !latest.Valid || latest.Created == nil {
+		return min
+	}
+	stableFor := now.Sub(*latest.Created)
+	if stableFor <= 0 {
+		return min
+	}
+	if stableFor >= stableAfter {
+		return max
+	}
+	return min + time.Duration(float64(max-min)*float64(stableFor)/float64(stableAfter))
+}
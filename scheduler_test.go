@@ -0,0 +1,140 @@
+package hivdomainstatus
+
+import (
+	"database/sql"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func SetupSchedulerTest(t *testing.T) (c *Config, domainRepo *DomainRepository, scheduleRepo *DomainScheduleRepository, jobRepo *CheckJobRepository, manager *Manager) {
+	c, configErr := NewConfig()
+	if configErr != nil {
+		t.Fatal(configErr)
+	}
+	db, _ := sql.Open("postgres", c.DSN())
+	db.Exec("TRUNCATE domain RESTART IDENTITY")
+	db.Exec("TRUNCATE domain_check RESTART IDENTITY")
+	db.Exec("TRUNCATE domain_schedule RESTART IDENTITY")
+	db.Exec("TRUNCATE check_job RESTART IDENTITY")
+	domainRepo = NewDomainRepository(db)
+	scheduleRepo = NewDomainScheduleRepository(db)
+	jobRepo = NewCheckJobRepository(db)
+	manager = NewManager(db, domainRepo, NewDomainCheckRepository(db), NewEventRepository(db), NewMaintenanceWindowRepository(db))
+
+	for _, name := range []string{"example.hiv", "acme.hiv"} {
+		d := new(Domain)
+		d.Name = name
+		domainRepo.Persist(d)
+	}
+	return
+}
+
+func TestThatItSchedulesDomains(t *testing.T) {
+	assert := assert.New(t)
+	c, _, scheduleRepo, jobRepo, manager := SetupSchedulerTest(t)
+	c.Scheduler.Concurrency = 2
+	c.Scheduler.MinInterval = "1h"
+	c.Scheduler.PollInterval = "10ms"
+
+	s, err := NewScheduler(c, scheduleRepo, jobRepo, manager)
+	assert.Nil(err)
+
+	var mutex sync.Mutex
+	checked := make(map[string]int)
+	done := make(chan bool, 2)
+	s.checkDomain = func(config *Config, domain string) (result *DomainCheckResult, err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		checked[domain]++
+		result = NewDomainCheckResult(domain, isHivDomain)
+		result.URL, _ = url.Parse("http://" + domain + "/")
+		result.Valid = true
+		done <- true
+		return
+	}
+
+	stop := make(chan bool)
+	finished := make(chan bool)
+	go func() {
+		s.Run(stop)
+		finished <- true
+	}()
+	<-done
+	<-done
+	// Give the scheduler the chance to (wrongly) pick up domains again
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	<-finished
+
+	assert.Equal(1, checked["example.hiv"])
+	assert.Equal(1, checked["acme.hiv"])
+
+	schedules, findErr := scheduleRepo.FindAll()
+	assert.Nil(findErr)
+	assert.Equal(2, len(schedules))
+	for _, schedule := range schedules {
+		assert.NotNil(schedule.LastCheck)
+		assert.True(schedule.NextCheck.After(time.Now().Add(59 * time.Minute)))
+	}
+
+	jobs, jobsErr := jobRepo.FindAll()
+	assert.Nil(jobsErr)
+	assert.Equal(2, len(jobs))
+	for _, job := range jobs {
+		assert.Equal(JOB_STATE_DONE, job.State)
+		assert.True(job.CheckId > 0)
+	}
+}
+
+func TestThatItRejectsInvalidSchedulerConfig(t *testing.T) {
+	assert := assert.New(t)
+	c := NewDefaultConfig()
+	c.Scheduler.Concurrency = 0
+	_, err := NewScheduler(c, nil, nil, nil)
+	assert.NotNil(err)
+
+	c = NewDefaultConfig()
+	c.Scheduler.MinInterval = "soon"
+	_, err = NewScheduler(c, nil, nil, nil)
+	assert.NotNil(err)
+
+	c = NewDefaultConfig()
+	c.Scheduler.MinInterval = "2h"
+	c.Scheduler.MaxInterval = "1h"
+	_, err = NewScheduler(c, nil, nil, nil)
+	assert.NotNil(err)
+}
+
+func TestThatItAdaptsCheckInterval(t *testing.T) {
+	assert := assert.New(t)
+	min := 15 * time.Minute
+	max := 24 * time.Hour
+	stableAfter := 14 * 24 * time.Hour
+	now := time.Now()
+
+	// Never checked
+	assert.Equal(min, NextCheckInterval(nil, now, min, max, stableAfter))
+
+	// Failing
+	check := new(DomainCheck)
+	since := now.Add(-30 * 24 * time.Hour)
+	check.Created = &since
+	assert.Equal(min, NextCheckInterval(check, now, min, max, stableAfter))
+
+	// Valid for weeks
+	check.Valid = true
+	assert.Equal(max, NextCheckInterval(check, now, min, max, stableAfter))
+
+	// Just changed
+	since = now
+	assert.Equal(min, NextCheckInterval(check, now, min, max, stableAfter))
+
+	// Valid for a week
+	since = now.Add(-7 * 24 * time.Hour)
+	interval := NextCheckInterval(check, now, min, max, stableAfter)
+	assert.Equal(min+(max-min)/2, interval)
+}
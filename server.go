@@ -6,29 +6,134 @@ import (
 	"log"
 	"net/http"
 	"regexp"
+	"sort"
+	"strings"
+	"time"
 )
 
+// Handles a request to a route, routeParams are the submatches of the route pattern
+type RouteHandler func(w http.ResponseWriter, r *http.Request, routeParams []string)
+
+// Wraps a RouteHandler, e.g. to authenticate or log requests
+type Middleware func(handler RouteHandler) RouteHandler
+
 type route struct {
-	re      *regexp.Regexp
-	handler func(http.ResponseWriter, *http.Request, []string)
+	re       *regexp.Regexp
+	handlers map[string]RouteHandler
+}
+
+// Returns the methods of the route, HEAD is served with GET and OPTIONS always
+func (rt *route) allow() string {
+	methods := make([]string, 0, len(rt.handlers)+2)
+	for method := range rt.handlers {
+		methods = append(methods, method)
+	}
+	if _, ok := rt.handlers["GET"]; ok {
+		if _, ok := rt.handlers["HEAD"]; !ok {
+			methods = append(methods, "HEAD")
+		}
+	}
+	if _, ok := rt.handlers["OPTIONS"]; !ok {
+		methods = append(methods, "OPTIONS")
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
 }
 
+// Routes requests by the path and method
 type RegexpHandler struct {
-	routes []*route
+	routes     []*route
+	middleware []Middleware
+}
+
+// Adds middleware to all routes, it is applied before the middleware of a route
+func (h *RegexpHandler) Use(middleware ...Middleware) {
+	h.middleware = append(h.middleware, middleware...)
 }
 
-func (h *RegexpHandler) AddRoute(re string, handler func(http.ResponseWriter, *http.Request, []string)) {
-	r := &route{regexp.MustCompile(re), handler}
-	h.routes = append(h.routes, r)
+// Registers handler for the methods on paths matching re. Routes are matched in the
+// order they are added, adding the same pattern again adds methods to that route.
+func (h *RegexpHandler) AddRoute(re string, methods []string, handler RouteHandler, middleware ...Middleware) {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	var rt *route
+	for _, existing := range h.routes {
+		if existing.re.String() == re {
+			rt = existing
+		}
+	}
+	if rt == nil {
+		rt = &route{regexp.MustCompile(re), make(map[string]RouteHandler)}
+		h.routes = append(h.routes, rt)
+	}
+	for _, method := range methods {
+		rt.handlers[method] = handler
+	}
 }
 
 func (h *RegexpHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
-	for _, route := range h.routes {
-		matches := route.re.FindStringSubmatch(r.URL.Path)
-		if matches != nil {
-			route.handler(rw, r, matches)
-			break
+	handler := RouteHandler(h.dispatch)
+	for i := len(h.middleware) - 1; i >= 0; i-- {
+		handler = h.middleware[i](handler)
+	}
+	handler(rw, r, nil)
+}
+
+func (h *RegexpHandler) dispatch(w http.ResponseWriter, r *http.Request, routeParams []string) {
+	for _, rt := range h.routes {
+		matches := rt.re.FindStringSubmatch(r.URL.Path)
+		if matches == nil {
+			continue
 		}
+		if handler, ok := rt.handlers[r.Method]; ok {
+			handler(w, r, matches)
+			return
+		}
+		if handler, ok := rt.handlers["GET"]; ok && r.Method == "HEAD" {
+			// The server discards the body of responses to HEAD requests
+			get := new(http.Request)
+			*get = *r
+			get.Method = "GET"
+			handler(w, get, matches)
+			return
+		}
+		w.Header().Set("Allow", rt.allow())
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		HttpProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed: "+r.Method)
+		return
+	}
+	HttpProblem(w, r, http.StatusNotFound, "Not found: "+r.URL.Path)
+}
+
+// Logs method, path, status and duration of every request
+func LogRequests(handler RouteHandler) RouteHandler {
+	return func(w http.ResponseWriter, r *http.Request, routeParams []string) {
+		start := time.Now()
+		sw := &statusResponseWriter{w, http.StatusOK}
+		handler(sw, r, routeParams)
+		log.Printf("%s %s %d %s\n", r.Method, r.URL.RequestURI(), sw.status, time.Since(start))
+	}
+}
+
+// Remembers the status code of a response
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Streaming responses need to flush
+func (w *statusResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
 	}
 }
 
@@ -42,17 +147,102 @@ func Serve(c *Config) (err error) {
 	log.Println(fmt.Sprintf("Starting server on localhost:%d ...", c.Server.Port))
 
 	domainCntrl := new(DomainController)
+	domainCntrl.db = db
 	domainCntrl.domainRepo = NewDomainRepository(db)
 	domainCntrl.domainCheckRepo = NewDomainCheckRepository(db)
+	domainCntrl.domainScheduleRepo = NewDomainScheduleRepository(db)
+	domainCntrl.tagRepo = NewTagRepository(db)
+	domainCntrl.maintenanceRepo = NewMaintenanceWindowRepository(db)
+	domainCntrl.jobRepo = NewCheckJobRepository(db)
+	jobCntrl := new(CheckJobController)
+	jobCntrl.jobRepo = domainCntrl.jobRepo
+	tagCntrl := new(TagController)
+	tagCntrl.tagRepo = domainCntrl.tagRepo
 	domainCheckCntrl := new(DomainCheckController)
 	domainCheckCntrl.domainCheckRepo = domainCntrl.domainCheckRepo
+	domainCheckCntrl.domainRepo = domainCntrl.domainRepo
+	webhookCntrl := new(WebhookController)
+	webhookCntrl.webhookRepo = NewWebhookRepository(db)
+	webhookCntrl.deliveryRepo = NewWebhookDeliveryRepository(db)
+	unsubscribeCntrl := new(UnsubscribeController)
+	unsubscribeCntrl.unsubscribeRepo = NewUnsubscribeRepository(db)
+	importCntrl := new(ImportController)
+	importCntrl.importer = NewImporter(db, domainCntrl.domainRepo, domainCntrl.tagRepo)
+	statsCntrl := new(StatsController)
+	statsCntrl.domainRepo = domainCntrl.domainRepo
+	exportCntrl := new(ExportController)
+	exportCntrl.domainRepo = domainCntrl.domainRepo
 	entryPointCntrl := new(EntryPointController)
 
+	dispatcher, err := NewWebhookDispatcher(c, webhookCntrl.webhookRepo, webhookCntrl.deliveryRepo)
+	if err != nil {
+		return
+	}
+	go dispatcher.Run(make(chan bool))
+
+	var notifier *Notifier
+	if c.Smtp.Enabled {
+		notifier, err = NewNotifier(c, domainCntrl.domainRepo, NewNotificationRepository(db), unsubscribeCntrl.unsubscribeRepo)
+		if err != nil {
+			return
+		}
+		unsubscribeCntrl.secret = c.Smtp.Secret
+		go notifier.Run(make(chan bool))
+	}
+
+	manager := NewManager(db, domainCntrl.domainRepo, domainCntrl.domainCheckRepo, NewEventRepository(db), domainCntrl.maintenanceRepo)
+	dispatcher.Subscribe(manager.Events)
+	if notifier != nil {
+		notifier.Subscribe(manager.Events)
+	}
+	if c.Scheduler.Enabled {
+		scheduler, schedulerErr := NewScheduler(c, domainCntrl.domainScheduleRepo, domainCntrl.jobRepo, manager)
+		if schedulerErr != nil {
+			err = schedulerErr
+			return
+		}
+		go scheduler.Run(make(chan bool))
+	} else {
+		// Without the scheduler's workers the rechecks requested via the API are worked off here
+		worker, workerErr := NewWorker(c, domainCntrl.jobRepo, manager)
+		if workerErr != nil {
+			err = workerErr
+			return
+		}
+		go worker.Run(make(chan bool))
+	}
+
+	// Unless disabled every route but the unsubscribe links requires an API key
+	protect := []Middleware{}
+	if c.Server.Auth {
+		protect = append(protect, NewApiKeyAuth(NewApiKeyRepository(db)).Protect)
+	}
+
 	reHandler := new(RegexpHandler)
-	reHandler.AddRoute("^/domain/([0-9]+)$", domainCntrl.ItemHandler)
-	reHandler.AddRoute("^/domain$", domainCntrl.ListingHandler)
-	reHandler.AddRoute("^/check$", domainCheckCntrl.ListingHandler)
-	reHandler.AddRoute("^/$", entryPointCntrl.EntryPointHandler)
+	if c.Server.AccessLog {
+		reHandler.Use(LogRequests)
+	}
+	reHandler.AddRoute("^/domain/([0-9]+)/tags/([^/]+)$", []string{"DELETE"}, domainCntrl.TagHandler, protect...)
+	reHandler.AddRoute("^/domain/([0-9]+)/tags$", []string{"GET", "PUT", "POST"}, domainCntrl.TagsHandler, protect...)
+	reHandler.AddRoute("^/domain/([0-9]+)/maintenance/([0-9]+)$", []string{"GET", "DELETE"}, domainCntrl.MaintenanceItemHandler, protect...)
+	reHandler.AddRoute("^/domain/([0-9]+)/maintenance$", []string{"GET", "POST"}, domainCntrl.MaintenanceHandler, protect...)
+	reHandler.AddRoute("^/domain/([0-9]+)/check$", []string{"POST"}, domainCntrl.CheckHandler, protect...)
+	reHandler.AddRoute("^/domain/([0-9]+)/checks$", []string{"GET"}, domainCntrl.ChecksHandler, protect...)
+	reHandler.AddRoute("^/domain/([0-9]+)$", []string{"GET", "PATCH", "DELETE"}, domainCntrl.ItemHandler, protect...)
+	reHandler.AddRoute("^/domain/by-name/([^/]+)$", []string{"GET", "PUT", "DELETE"}, domainCntrl.ByNameHandler, protect...)
+	reHandler.AddRoute("^/domain/bulk$", []string{"POST"}, importCntrl.BulkHandler, protect...)
+	reHandler.AddRoute("^/domain$", []string{"GET", "POST"}, domainCntrl.ListingHandler, protect...)
+	reHandler.AddRoute("^/check/([0-9]+)$", []string{"GET"}, domainCheckCntrl.ItemHandler, protect...)
+	reHandler.AddRoute("^/check$", []string{"GET"}, domainCheckCntrl.ListingHandler, protect...)
+	reHandler.AddRoute("^/tag$", []string{"GET"}, tagCntrl.ListingHandler, protect...)
+	reHandler.AddRoute("^/export/domains$", []string{"GET"}, exportCntrl.DomainsHandler, protect...)
+	reHandler.AddRoute("^/stats$", []string{"GET"}, statsCntrl.StatsHandler, protect...)
+	reHandler.AddRoute("^/job/([0-9]+)$", []string{"GET"}, jobCntrl.ItemHandler, protect...)
+	reHandler.AddRoute("^/webhook/([0-9]+)/deliveries$", []string{"GET"}, webhookCntrl.DeliveriesHandler, protect...)
+	reHandler.AddRoute("^/webhook/([0-9]+)$", []string{"GET", "DELETE"}, webhookCntrl.ItemHandler, protect...)
+	reHandler.AddRoute("^/webhook$", []string{"GET", "POST"}, webhookCntrl.ListingHandler, protect...)
+	reHandler.AddRoute("^/unsubscribe$", []string{"GET", "POST"}, unsubscribeCntrl.UnsubscribeHandler)
+	reHandler.AddRoute("^/$", []string{"GET"}, entryPointCntrl.EntryPointHandler, protect...)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf("localhost:%d", c.Server.Port), reHandler))
 
 	return
@@ -0,0 +1,97 @@
+package hivdomainstatus
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatItRoutesByPathAndMethod(t *testing.T) {
+	assert := assert.New(t)
+
+	calls := make([]string, 0)
+	handler := func(w http.ResponseWriter, r *http.Request, routeParams []string) {
+		calls = append(calls, r.Method+" "+routeParams[0])
+		w.Write([]byte("body"))
+	}
+	h := new(RegexpHandler)
+	h.AddRoute("^/domain/([0-9]+)$", []string{"GET", "DELETE"}, handler)
+	h.AddRoute("^/domain$", []string{"GET"}, handler)
+	h.AddRoute("^/domain$", []string{"POST"}, handler)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	request := func(method string, path string) (res *http.Response, body string) {
+		req, _ := http.NewRequest(method, ts.URL+path, nil)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		body = string(b)
+		return
+	}
+
+	res, body := request("GET", "/domain/1")
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.Equal("body", body)
+	res, _ = request("POST", "/domain")
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.Equal([]string{"GET /domain/1", "POST /domain"}, calls)
+
+	// HEAD is served by GET without a body
+	res, body = request("HEAD", "/domain/1")
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.Equal("", body)
+	assert.Equal("GET /domain/1", calls[2])
+
+	res, _ = request("OPTIONS", "/domain")
+	assert.Equal(http.StatusNoContent, res.StatusCode)
+	assert.Equal("GET, HEAD, OPTIONS, POST", res.Header.Get("Allow"))
+
+	res, _ = request("PUT", "/domain/1")
+	assert.Equal(http.StatusMethodNotAllowed, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+	assert.Equal("DELETE, GET, HEAD, OPTIONS", res.Header.Get("Allow"))
+
+	res, _ = request("GET", "/unknown")
+	assert.Equal(http.StatusNotFound, res.StatusCode)
+	assert.Equal("application/problem+json", res.Header.Get("Content-Type"))
+	assert.Equal(3, len(calls))
+}
+
+func TestThatItChainsMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	order := make([]string, 0)
+	middleware := func(name string) Middleware {
+		return func(handler RouteHandler) RouteHandler {
+			return func(w http.ResponseWriter, r *http.Request, routeParams []string) {
+				order = append(order, name)
+				handler(w, r, routeParams)
+			}
+		}
+	}
+	h := new(RegexpHandler)
+	h.Use(middleware("global"))
+	h.AddRoute("^/$", []string{"GET"}, func(w http.ResponseWriter, r *http.Request, routeParams []string) {
+		order = append(order, "handler")
+	}, middleware("auth"), middleware("log"))
+
+	r, _ := http.NewRequest("GET", "http://localhost/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal([]string{"global", "auth", "log", "handler"}, order)
+
+	// Global middleware also sees unknown routes
+	order = make([]string, 0)
+	r, _ = http.NewRequest("GET", "http://localhost/unknown", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal([]string{"global"}, order)
+	assert.Equal(http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS api_key;
+
+-- Only the SHA-256 hash of a key is stored, the prefix identifies it in listings
+CREATE TABLE api_key (
+	id SERIAL PRIMARY KEY NOT NULL UNIQUE,
+	name varchar(128) NOT NULL,
+	prefix varchar(8) NOT NULL,
+	key_hash char(64) NOT NULL UNIQUE,
+	scope varchar(16) NOT NULL,
+	last_used timestamp DEFAULT NULL,
+	revoked timestamp DEFAULT NULL,
+	created timestamp DEFAULT current_timestamp
+);
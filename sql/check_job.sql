@@ -0,0 +1,19 @@
+DROP TABLE IF EXISTS check_job;
+
+CREATE TABLE check_job (
+	id SERIAL PRIMARY KEY NOT NULL UNIQUE,
+	domain varchar(128) NOT NULL,
+	state varchar(16) NOT NULL DEFAULT 'queued',
+	worker varchar(128) NOT NULL DEFAULT '',
+	attempts integer NOT NULL DEFAULT 0,
+	lease_until timestamp with time zone DEFAULT NULL,
+	heartbeat timestamp with time zone DEFAULT NULL,
+	check_id integer DEFAULT NULL,
+	error text NOT NULL DEFAULT '',
+	created timestamp with time zone DEFAULT current_timestamp,
+	finished timestamp with time zone DEFAULT NULL
+);
+
+CREATE INDEX check_job__state_idx ON check_job ( state, id );
+-- Only one open job per domain
+CREATE UNIQUE INDEX check_job__open_domain_idx ON check_job ( domain ) WHERE state IN ('queued', 'running');
@@ -4,7 +4,14 @@ CREATE TABLE domain (
 	id SERIAL PRIMARY KEY NOT NULL UNIQUE,
 	name varchar(128) NOT NULL UNIQUE,
 	valid boolean NOT NULL DEFAULT false,
+	owner_name varchar(256) NOT NULL DEFAULT '',
+	contacts json,
+	registrar varchar(128) NOT NULL DEFAULT '',
+	notes text NOT NULL DEFAULT '',
+	external_ref varchar(128) NOT NULL DEFAULT '',
+	disabled boolean NOT NULL DEFAULT false,
 	created timestamp DEFAULT current_timestamp
 );
 
 CREATE INDEX domain__dn_idx ON domain ( name );
+CREATE INDEX domain__external_ref_idx ON domain ( external_ref );
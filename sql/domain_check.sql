@@ -12,6 +12,9 @@ CREATE TABLE domain_check (
     iframe_target text DEFAULT NULL,
 	iframe_target_ok boolean DEFAULT NULL,
 	valid boolean NOT NULL DEFAULT false,
+	hosts json,
+	-- checked during a maintenance window of the domain
+	in_maintenance boolean NOT NULL DEFAULT false,
 	created timestamp DEFAULT current_timestamp
 );
 
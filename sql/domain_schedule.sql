@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS domain_schedule;
+
+CREATE TABLE domain_schedule (
+	id SERIAL PRIMARY KEY NOT NULL UNIQUE,
+	domain varchar(128) NOT NULL UNIQUE,
+	next_check timestamp with time zone NOT NULL DEFAULT current_timestamp,
+	last_check timestamp with time zone DEFAULT NULL,
+	created timestamp DEFAULT current_timestamp
+);
+
+CREATE INDEX domain_schedule__next_check_idx ON domain_schedule ( next_check );
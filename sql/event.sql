@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS event;
+
+CREATE TABLE event (
+	id SERIAL PRIMARY KEY NOT NULL UNIQUE,
+	type varchar(64) NOT NULL,
+	domain varchar(128) NOT NULL,
+	valid boolean NOT NULL DEFAULT false,
+	previous_valid boolean NOT NULL DEFAULT false,
+	check_id integer DEFAULT NULL,
+	previous_check_id integer DEFAULT NULL,
+	created timestamp DEFAULT current_timestamp
+);
+
+CREATE INDEX event__dn_idx ON event ( domain );
@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS maintenance_window;
+
+CREATE TABLE maintenance_window (
+	id SERIAL PRIMARY KEY NOT NULL UNIQUE,
+	domain_id integer NOT NULL,
+	starts timestamp with time zone NOT NULL,
+	ends timestamp with time zone NOT NULL,
+	reason text NOT NULL DEFAULT '',
+	created timestamp DEFAULT current_timestamp
+);
+
+CREATE INDEX maintenance_window__domain_idx ON maintenance_window ( domain_id, ends );
@@ -0,0 +1,3 @@
+-- Adds the results of the individual hosts to an existing domain_check table
+
+ALTER TABLE domain_check ADD COLUMN hosts json;
@@ -0,0 +1,3 @@
+-- Adds the maintenance flag to an existing domain_check table
+
+ALTER TABLE domain_check ADD COLUMN in_maintenance boolean NOT NULL DEFAULT false;
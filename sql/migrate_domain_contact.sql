@@ -0,0 +1,3 @@
+-- Adds the contact address to an existing domain table, run before migrate_domain_owner.sql
+
+ALTER TABLE domain ADD COLUMN contact varchar(256) NOT NULL DEFAULT '';
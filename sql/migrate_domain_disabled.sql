@@ -0,0 +1,3 @@
+-- Adds the monitoring switch to an existing domain table
+
+ALTER TABLE domain ADD COLUMN disabled boolean NOT NULL DEFAULT false;
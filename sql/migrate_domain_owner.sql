@@ -0,0 +1,11 @@
+-- Migrates an existing domain table from the single contact column to the owner metadata
+
+ALTER TABLE domain ADD COLUMN owner_name varchar(256) NOT NULL DEFAULT '';
+ALTER TABLE domain ADD COLUMN contacts json;
+ALTER TABLE domain ADD COLUMN registrar varchar(128) NOT NULL DEFAULT '';
+ALTER TABLE domain ADD COLUMN notes text NOT NULL DEFAULT '';
+ALTER TABLE domain ADD COLUMN external_ref varchar(128) NOT NULL DEFAULT '';
+UPDATE domain SET contacts = CASE WHEN contact = '' THEN '[]'::json ELSE json_build_array(contact) END;
+ALTER TABLE domain DROP COLUMN contact;
+
+CREATE INDEX domain__external_ref_idx ON domain ( external_ref );
@@ -0,0 +1,3 @@
+-- Adds the lease of notifications being sent to an existing notification table
+
+ALTER TABLE notification ADD COLUMN lease_until timestamp with time zone DEFAULT NULL;
@@ -0,0 +1,18 @@
+DROP TABLE IF EXISTS notification;
+
+CREATE TABLE notification (
+	id SERIAL PRIMARY KEY NOT NULL UNIQUE,
+	domain varchar(128) NOT NULL,
+	email varchar(256) NOT NULL,
+	kind varchar(16) NOT NULL,
+	state varchar(16) NOT NULL DEFAULT 'pending',
+	error text NOT NULL DEFAULT '',
+	sent timestamp with time zone DEFAULT NULL,
+	-- Until when a notifier holds a notification in state sending
+	lease_until timestamp with time zone DEFAULT NULL,
+	created timestamp with time zone DEFAULT current_timestamp
+);
+
+CREATE INDEX notification__dn_idx ON notification ( domain, id );
+CREATE INDEX notification__state_idx ON notification ( state, id );
+CREATE INDEX notification__sent_idx ON notification ( sent );
@@ -0,0 +1,19 @@
+DROP TABLE IF EXISTS domain_tag;
+DROP TABLE IF EXISTS tag;
+
+CREATE TABLE tag (
+	id SERIAL PRIMARY KEY NOT NULL UNIQUE,
+	name varchar(64) NOT NULL UNIQUE,
+	created timestamp DEFAULT current_timestamp
+);
+
+-- Domains are referenced by id without a foreign key, so the tests can truncate
+-- the domain table, the domain repository removes the tags with the domain
+CREATE TABLE domain_tag (
+	domain_id integer NOT NULL,
+	tag_id integer NOT NULL REFERENCES tag ( id ) ON DELETE CASCADE,
+	created timestamp DEFAULT current_timestamp,
+	PRIMARY KEY ( domain_id, tag_id )
+);
+
+CREATE INDEX domain_tag__tag_idx ON domain_tag ( tag_id );
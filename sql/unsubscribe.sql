@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS unsubscribe;
+
+CREATE TABLE unsubscribe (
+	id SERIAL PRIMARY KEY NOT NULL UNIQUE,
+	email varchar(256) NOT NULL UNIQUE,
+	created timestamp DEFAULT current_timestamp
+);
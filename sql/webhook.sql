@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS webhook CASCADE;
+
+CREATE TABLE webhook (
+	id SERIAL PRIMARY KEY NOT NULL UNIQUE,
+	url text NOT NULL,
+	secret varchar(256) NOT NULL,
+	events json,
+	created timestamp DEFAULT current_timestamp
+);
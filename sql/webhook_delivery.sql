@@ -0,0 +1,19 @@
+DROP TABLE IF EXISTS webhook_delivery;
+
+CREATE TABLE webhook_delivery (
+	id SERIAL PRIMARY KEY NOT NULL UNIQUE,
+	webhook_id integer NOT NULL REFERENCES webhook ( id ) ON DELETE CASCADE,
+	event_type varchar(64) NOT NULL,
+	payload text NOT NULL,
+	state varchar(16) NOT NULL DEFAULT 'pending',
+	attempts integer NOT NULL DEFAULT 0,
+	next_attempt timestamp with time zone NOT NULL DEFAULT current_timestamp,
+	status_code integer NOT NULL DEFAULT 0,
+	response text NOT NULL DEFAULT '',
+	error text NOT NULL DEFAULT '',
+	created timestamp DEFAULT current_timestamp,
+	delivered timestamp with time zone DEFAULT NULL
+);
+
+CREATE INDEX webhook_delivery__webhook_idx ON webhook_delivery ( webhook_id );
+CREATE INDEX webhook_delivery__next_attempt_idx ON webhook_delivery ( state, next_attempt );
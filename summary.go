@@ -0,0 +1,60 @@
+package hivdomainstatus
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Collects the outcome of a check run, a failing domain does not stop the run
+type RunSummary struct {
+	mutex   sync.Mutex
+	Checked int
+	Valid   int
+	Invalid int
+	Errors  map[string]error
+}
+
+func NewRunSummary() (s *RunSummary) {
+	s = new(RunSummary)
+	s.Errors = make(map[string]error)
+	return
+}
+
+// Records the check of domain, err is set if the result could not be stored
+func (s *RunSummary) Add(domain string, valid bool, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Checked++
+	if valid {
+		s.Valid++
+	} else {
+		s.Invalid++
+	}
+	if err != nil {
+		s.Errors[domain] = err
+	}
+}
+
+// Records an error of domain which occurred after its check was added
+func (s *RunSummary) AddError(domain string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Errors[domain] = err
+}
+
+func (s *RunSummary) String() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	lines := []string{fmt.Sprintf("Checked %d domains: %d valid, %d invalid, %d errors", s.Checked, s.Valid, s.Invalid, len(s.Errors))}
+	domains := make([]string, 0, len(s.Errors))
+	for domain := range s.Errors {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	for _, domain := range domains {
+		lines = append(lines, fmt.Sprintf("  %s: %s", domain, s.Errors[domain].Error()))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,35 @@
+package hivdomainstatus
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatItSummarizesRun(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewRunSummary()
+	s.Add("example.hiv", true, nil)
+	s.Add("acme.hiv", false, nil)
+	s.Add("broken.hiv", false, fmt.Errorf("connection refused"))
+
+	assert.Equal(3, s.Checked)
+	assert.Equal(1, s.Valid)
+	assert.Equal(2, s.Invalid)
+	assert.Equal(1, len(s.Errors))
+	assert.Equal("Checked 3 domains: 1 valid, 2 invalid, 1 errors\n  broken.hiv: connection refused", s.String())
+}
+
+func TestThatItRecordsErrorsAfterTheCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewRunSummary()
+	s.Add("example.hiv", true, nil)
+	s.AddError("example.hiv", fmt.Errorf("Lease of job has been lost"))
+
+	assert.Equal(1, s.Checked)
+	assert.Equal(1, s.Valid)
+	assert.Equal("Checked 1 domains: 1 valid, 0 invalid, 1 errors\n  example.hiv: Lease of job has been lost", s.String())
+}
@@ -1,6 +1,11 @@
 package hivdomainstatus
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"time"
+)
 
 func transformCheckEntity(check *DomainCheck, route string) (m *DomainCheckModel) {
 	m = new(DomainCheckModel)
@@ -17,6 +22,18 @@ func transformCheckEntity(check *DomainCheck, route string) (m *DomainCheckModel
 	m.IframeTarget = check.IframeTarget
 	m.IframeTargetOk = check.IframeTargetOk
 	m.Valid = check.Valid
+	m.InMaintenance = check.InMaintenance
+	m.Hosts = make([]*DomainCheckHostModel, len(check.Hosts))
+	for i, host := range check.Hosts {
+		h := new(DomainCheckHostModel)
+		h.Host = host.Host
+		h.URL = host.URL
+		h.StatusCode = host.StatusCode
+		h.ScriptPresent = host.ScriptPresent
+		h.Valid = host.Valid
+		h.Error = host.Error
+		m.Hosts[i] = h
+	}
 	m.Created = check.Created
 	return
 }
@@ -27,6 +44,160 @@ func transformEntity(e *Domain, route string) (m *DomainModel) {
 	m.JsonLDId = fmt.Sprintf(route, e.Id)
 	m.Id = fmt.Sprintf("%d", e.Id)
 	m.Name = e.Name
+	m.OwnerName = e.OwnerName
+	m.Contacts = e.Contacts
+	if m.Contacts == nil {
+		m.Contacts = []string{}
+	}
+	m.Registrar = e.Registrar
+	m.Notes = e.Notes
+	m.ExternalRef = e.ExternalRef
+	enabled := !e.Disabled
+	m.Enabled = &enabled
+	m.Tags = []string{}
+	m.Maintenance = []*MaintenanceWindowModel{}
 	m.Created = e.Created
 	return
 }
+
+func transformWebhookEntity(e *Webhook, route string) (m *WebhookModel) {
+	m = new(WebhookModel)
+	m.JsonLDContext = "http://jsonld.click4life.hiv/Webhook"
+	m.JsonLDId = fmt.Sprintf(route, e.Id)
+	m.Id = fmt.Sprintf("%d", e.Id)
+	m.URL = e.URL
+	m.Events = e.Events
+	if m.Events == nil {
+		m.Events = []string{}
+	}
+	m.Deliveries = m.JsonLDId + "/deliveries"
+	m.Created = e.Created
+	return
+}
+
+func transformWebhookDeliveryEntity(e *WebhookDelivery, route string) (m *WebhookDeliveryModel) {
+	m = new(WebhookDeliveryModel)
+	m.JsonLDContext = "http://jsonld.click4life.hiv/WebhookDelivery"
+	m.JsonLDId = fmt.Sprintf(route, e.Id)
+	m.Id = fmt.Sprintf("%d", e.Id)
+	m.Event = e.EventType
+	m.State = e.State
+	m.Attempts = e.Attempts
+	m.NextAttempt = e.NextAttempt
+	m.StatusCode = e.StatusCode
+	m.Error = e.Error
+	m.Created = e.Created
+	m.Delivered = e.Delivered
+	return
+}
+
+func transformEvent(e *Event, baseUrl string) (m *EventModel) {
+	m = new(EventModel)
+	m.JsonLDContext = "http://jsonld.click4life.hiv/Event"
+	m.JsonLDType = e.Type
+	m.Event = e.Type
+	m.Domain = e.Domain
+	m.Valid = e.Valid
+	m.PreviousValid = e.PreviousValid
+	if e.Check != nil {
+		m.Check = transformCheckEntity(e.Check, baseUrl+"/check/%d")
+	}
+	m.Created = e.Created
+	return
+}
+
+func transformTagEntity(e *Tag, route string) (m *TagModel) {
+	m = new(TagModel)
+	m.JsonLDContext = "http://jsonld.click4life.hiv/Tag"
+	m.JsonLDId = fmt.Sprintf(route, url.QueryEscape(e.Name))
+	m.Name = e.Name
+	m.Domains = e.Domains
+	m.Created = e.Created
+	return
+}
+
+func transformMaintenanceWindowEntity(e *MaintenanceWindow, route string, now time.Time) (m *MaintenanceWindowModel) {
+	m = new(MaintenanceWindowModel)
+	m.JsonLDContext = "http://jsonld.click4life.hiv/MaintenanceWindow"
+	m.JsonLDId = fmt.Sprintf(route, e.DomainId, e.Id)
+	m.Starts = e.Starts
+	m.Ends = e.Ends
+	m.Reason = e.Reason
+	m.Active = e.ActiveAt(now)
+	m.Created = e.Created
+	return
+}
+
+func transformCheckJobEntity(e *CheckJob, route string, checkRoute string) (m *CheckJobModel) {
+	m = new(CheckJobModel)
+	m.JsonLDContext = "http://jsonld.click4life.hiv/CheckJob"
+	m.JsonLDId = fmt.Sprintf(route, e.Id)
+	m.Domain = e.Domain
+	m.State = e.State
+	m.Attempts = e.Attempts
+	if e.CheckId > 0 {
+		m.Check = fmt.Sprintf(checkRoute, e.CheckId)
+	}
+	m.Error = e.Error
+	m.Created = e.Created
+	m.Finished = e.Finished
+	return
+}
+
+func transformExportEntity(e *Domain, check *DomainCheck) (m *DomainExportModel) {
+	m = new(DomainExportModel)
+	m.Id = e.Id
+	m.Name = e.Name
+	m.Valid = e.Valid
+	m.Enabled = !e.Disabled
+	m.OwnerName = e.OwnerName
+	m.Contacts = e.Contacts
+	m.Registrar = e.Registrar
+	m.Notes = e.Notes
+	m.ExternalRef = e.ExternalRef
+	m.Created = e.Created
+	if check == nil {
+		return
+	}
+	m.CheckId = check.Id
+	m.Checked = check.Created
+	m.DnsOK = check.DnsOK
+	m.URL = check.URL
+	m.StatusCode = check.StatusCode
+	m.ScriptPresent = check.ScriptPresent
+	m.IframePresent = check.IframePresent
+	m.IframeTarget = check.IframeTarget
+	m.IframeTargetOk = check.IframeTargetOk
+	m.FailureReason = check.FailureReason()
+	m.InMaintenance = check.InMaintenance
+	return
+}
+
+func transformStats(stats *DomainStats, days []*DailyValidity, id string) (m *StatsModel) {
+	m = new(StatsModel)
+	m.JsonLDContext = "http://jsonld.click4life.hiv/Stats"
+	m.JsonLDId = id
+	m.Domains = stats.Domains
+	m.Valid = stats.Valid
+	m.Invalid = stats.Invalid
+	m.Disabled = stats.Disabled
+	m.NeverChecked = stats.NeverChecked
+	m.DnsOK = stats.DnsOK
+	m.ScriptPresent = stats.ScriptPresent
+	m.IframePresent = stats.IframePresent
+	m.HttpsOK = stats.HttpsOK
+	m.FailureReasons = stats.FailureReasons
+	m.Daily = make([]*DailyValidityModel, len(days))
+	for i, day := range days {
+		d := new(DailyValidityModel)
+		d.Day = day.Day.Format("2006-01-02")
+		d.Checked = day.Checked
+		d.Valid = day.Valid
+		if day.Checked > 0 {
+			percent := math.Floor(float64(day.Valid)*1000/float64(day.Checked)+0.5) / 10
+			d.ValidPercent = &percent
+		}
+		m.Daily[i] = d
+	}
+	return
+}
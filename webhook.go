@@ -0,0 +1,183 @@
+package hivdomainstatus
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Name of the header which carries the HMAC-SHA256 signature of the payload
+const WEBHOOK_SIGNATURE_HEADER = "X-Hiv-Signature"
+
+// Queues events for the subscribed webhooks and delivers them.
+// Every process which runs checks queues deliveries, the server sends them.
+type WebhookDispatcher struct {
+	webhookRepo  WebhookRepositoryInterface
+	deliveryRepo WebhookDeliveryRepositoryInterface
+	baseUrl      string
+	maxAttempts  int
+	backoff      time.Duration
+	pollInterval time.Duration
+	client       *http.Client
+}
+
+func NewWebhookDispatcher(c *Config, webhookRepo WebhookRepositoryInterface, deliveryRepo WebhookDeliveryRepositoryInterface) (d *WebhookDispatcher, err error) {
+	d = new(WebhookDispatcher)
+	d.webhookRepo = webhookRepo
+	d.deliveryRepo = deliveryRepo
+	d.baseUrl = c.BaseUrl()
+	d.maxAttempts = c.Webhook.MaxAttempts
+	if d.maxAttempts < 1 {
+		err = fmt.Errorf("Webhook max attempts must be at least 1: %d", d.maxAttempts)
+		return
+	}
+	d.backoff, err = time.ParseDuration(c.Webhook.Backoff)
+	if err != nil {
+		return
+	}
+	timeout, err := time.ParseDuration(c.Webhook.Timeout)
+	if err != nil {
+		return
+	}
+	d.client = &http.Client{Timeout: timeout}
+	d.pollInterval, err = time.ParseDuration(c.Scheduler.PollInterval)
+	return
+}
+
+// Signs payload with secret, the value of the signature header
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Queues the events of bus for delivery
+func (d *WebhookDispatcher) Subscribe(bus *EventBus) {
+	bus.Subscribe(EVENT_ALL, d.OnEvent)
+}
+
+// Queues a delivery for every webhook whose filter matches event,
+// checks during a maintenance window are not sent
+func (d *WebhookDispatcher) OnEvent(event *Event) {
+	if event.Check != nil && event.Check.InMaintenance {
+		return
+	}
+	webhooks, err := d.webhookRepo.FindAll()
+	if err != nil {
+		log.Printf("ERROR: Failed to find webhooks: %s\n", err.Error())
+		return
+	}
+	payload, err := json.Marshal(transformEvent(event, d.baseUrl))
+	if err != nil {
+		log.Printf("ERROR: Failed to encode event: %s\n", err.Error())
+		return
+	}
+	for _, webhook := range webhooks {
+		if !webhook.Accepts(event.Type) {
+			continue
+		}
+		delivery := new(WebhookDelivery)
+		delivery.WebhookId = webhook.Id
+		delivery.EventType = event.Type
+		delivery.Payload = payload
+		err = d.deliveryRepo.Persist(delivery)
+		if err != nil {
+			log.Printf("ERROR: Failed to queue %s for webhook %d: %s\n", event.Type, webhook.Id, err.Error())
+		}
+	}
+}
+
+// Sends due deliveries until stop is closed
+func (d *WebhookDispatcher) Run(stop <-chan bool) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		processed, err := d.DeliverNext()
+		if err != nil {
+			log.Printf("ERROR: Webhook delivery: %s\n", err.Error())
+		}
+		if processed {
+			continue
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(d.pollInterval):
+		}
+	}
+}
+
+// Sends the next due delivery, processed is false if none is due
+func (d *WebhookDispatcher) DeliverNext() (processed bool, err error) {
+	delivery, err := d.deliveryRepo.ClaimDue(d.client.Timeout + d.backoff)
+	if err == sql.ErrNoRows {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	processed = true
+	webhook, findErr := d.webhookRepo.FindById(delivery.WebhookId)
+	if findErr != nil {
+		delivery.State = DELIVERY_STATE_FAILED
+		delivery.Error = "Webhook not found"
+		err = d.deliveryRepo.Persist(delivery)
+		return
+	}
+	d.send(webhook, delivery)
+	err = d.deliveryRepo.Persist(delivery)
+	return
+}
+
+// Posts the payload and records the outcome, failed attempts are retried with exponential backoff
+func (d *WebhookDispatcher) send(webhook *Webhook, delivery *WebhookDelivery) {
+	delivery.Attempts++
+	delivery.Error = ""
+	delivery.StatusCode = 0
+	delivery.Response = ""
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(delivery.Payload))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/ld+json")
+		req.Header.Set("X-Hiv-Event", delivery.EventType)
+		req.Header.Set("X-Hiv-Delivery", fmt.Sprintf("%d", delivery.Id))
+		req.Header.Set(WEBHOOK_SIGNATURE_HEADER, SignWebhookPayload(webhook.Secret, delivery.Payload))
+		var res *http.Response
+		res, err = d.client.Do(req)
+		if err == nil {
+			body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+			res.Body.Close()
+			delivery.StatusCode = res.StatusCode
+			delivery.Response = string(body)
+			if res.StatusCode < 200 || res.StatusCode > 299 {
+				err = fmt.Errorf("Unexpected status %d", res.StatusCode)
+			}
+		}
+	}
+	now := time.Now()
+	if err == nil {
+		delivery.State = DELIVERY_STATE_DELIVERED
+		delivery.Delivered = &now
+		return
+	}
+	delivery.Error = err.Error()
+	if delivery.Attempts >= d.maxAttempts {
+		delivery.State = DELIVERY_STATE_FAILED
+		log.Printf("ERROR: Giving up delivery %d to webhook %d: %s\n", delivery.Id, webhook.Id, delivery.Error)
+		return
+	}
+	next := now.Add(d.backoff * time.Duration(1<<uint(delivery.Attempts-1)))
+	delivery.NextAttempt = &next
+}
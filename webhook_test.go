@@ -0,0 +1,153 @@
+package hivdomainstatus
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func SetupWebhookDispatcherTest(t *testing.T) (db *sql.DB, webhookRepo *WebhookRepository, deliveryRepo *WebhookDeliveryRepository, dispatcher *WebhookDispatcher) {
+	c, configErr := NewConfig()
+	if configErr != nil {
+		t.Fatal(configErr)
+	}
+	c.Server.Url = "https://status.example.com/"
+	c.Webhook.MaxAttempts = 2
+	c.Webhook.Backoff = "1h"
+	db, _ = sql.Open("postgres", c.DSN())
+	db.Exec("TRUNCATE webhook RESTART IDENTITY CASCADE")
+	db.Exec("TRUNCATE webhook_delivery RESTART IDENTITY")
+	webhookRepo = NewWebhookRepository(db)
+	deliveryRepo = NewWebhookDeliveryRepository(db)
+	dispatcher, err := NewWebhookDispatcher(c, webhookRepo, deliveryRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestThatItSignsWebhookPayload(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8", SignWebhookPayload("key", []byte("The quick brown fox jumps over the lazy dog")))
+}
+
+func TestThatItDeliversSignedEvents(t *testing.T) {
+	assert := assert.New(t)
+	_, webhookRepo, deliveryRepo, dispatcher := SetupWebhookDispatcherTest(t)
+
+	var body []byte
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		header = r.Header
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	webhook := new(Webhook)
+	webhook.URL = ts.URL
+	webhook.Secret = "s3cr3t"
+	webhook.Events = []string{EVENT_DOMAIN_BECAME_INVALID}
+	assert.Nil(webhookRepo.Persist(webhook))
+
+	check := new(DomainCheck)
+	check.Id = 17
+	check.Domain = "example.hiv"
+	// Filtered
+	dispatcher.OnEvent(newEvent(EVENT_CHECK_COMPLETED, "example.hiv", check, true, nil))
+	dispatcher.OnEvent(newEvent(EVENT_DOMAIN_BECAME_INVALID, "example.hiv", check, true, nil))
+
+	processed, err := dispatcher.DeliverNext()
+	assert.True(processed)
+	assert.Nil(err)
+	processed, err = dispatcher.DeliverNext()
+	assert.False(processed)
+	assert.Nil(err)
+
+	assert.Equal("application/ld+json", header.Get("Content-Type"))
+	assert.Equal(EVENT_DOMAIN_BECAME_INVALID, header.Get("X-Hiv-Event"))
+	assert.Equal(SignWebhookPayload("s3cr3t", body), header.Get(WEBHOOK_SIGNATURE_HEADER))
+	var m EventModel
+	assert.Nil(json.Unmarshal(body, &m))
+	assert.Equal(EVENT_DOMAIN_BECAME_INVALID, m.Event)
+	assert.Equal("example.hiv", m.Domain)
+	assert.Equal("https://status.example.com/check/17", m.Check.JsonLDId)
+
+	deliveries, findErr := deliveryRepo.FindByWebhook(webhook.Id, 10, "")
+	assert.Nil(findErr)
+	assert.Equal(1, len(deliveries))
+	assert.Equal(DELIVERY_STATE_DELIVERED, deliveries[0].State)
+	assert.Equal(1, deliveries[0].Attempts)
+	assert.Equal(http.StatusNoContent, deliveries[0].StatusCode)
+	assert.NotNil(deliveries[0].Delivered)
+}
+
+func TestThatItDoesNotDeliverChecksDuringMaintenance(t *testing.T) {
+	assert := assert.New(t)
+	_, webhookRepo, _, dispatcher := SetupWebhookDispatcherTest(t)
+
+	webhook := new(Webhook)
+	webhook.URL = "http://localhost/hook"
+	webhook.Events = []string{EVENT_ALL}
+	assert.Nil(webhookRepo.Persist(webhook))
+
+	check := new(DomainCheck)
+	check.Domain = "example.hiv"
+	check.InMaintenance = true
+	dispatcher.OnEvent(newEvent(EVENT_CHECK_CHANGED, "example.hiv", check, true, nil))
+	dispatcher.OnEvent(newEvent(EVENT_CHECK_COMPLETED, "example.hiv", check, true, nil))
+
+	processed, err := dispatcher.DeliverNext()
+	assert.False(processed)
+	assert.Nil(err)
+}
+
+func TestThatItRetriesFailedDeliveries(t *testing.T) {
+	assert := assert.New(t)
+	db, webhookRepo, deliveryRepo, dispatcher := SetupWebhookDispatcherTest(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	webhook := new(Webhook)
+	webhook.URL = ts.URL
+	webhook.Secret = "s3cr3t"
+	assert.Nil(webhookRepo.Persist(webhook))
+
+	check := new(DomainCheck)
+	check.Domain = "example.hiv"
+	dispatcher.OnEvent(newEvent(EVENT_DOMAIN_RECOVERED, "example.hiv", check, false, nil))
+
+	// First attempt fails and is postponed by the backoff
+	before := time.Now()
+	processed, err := dispatcher.DeliverNext()
+	assert.True(processed)
+	assert.Nil(err)
+	deliveries, _ := deliveryRepo.FindByWebhook(webhook.Id, 10, "")
+	assert.Equal(DELIVERY_STATE_PENDING, deliveries[0].State)
+	assert.Equal(1, deliveries[0].Attempts)
+	assert.Equal(http.StatusServiceUnavailable, deliveries[0].StatusCode)
+	assert.True(deliveries[0].NextAttempt.After(before.Add(59 * time.Minute)))
+	processed, _ = dispatcher.DeliverNext()
+	assert.False(processed)
+
+	// Last attempt fails for good
+	db.Exec("UPDATE webhook_delivery SET next_attempt = now()")
+	processed, err = dispatcher.DeliverNext()
+	assert.True(processed)
+	assert.Nil(err)
+	deliveries, _ = deliveryRepo.FindByWebhook(webhook.Id, 10, "")
+	assert.Equal(DELIVERY_STATE_FAILED, deliveries[0].State)
+	assert.Equal(2, deliveries[0].Attempts)
+	db.Exec("UPDATE webhook_delivery SET next_attempt = now()")
+	processed, _ = dispatcher.DeliverNext()
+	assert.False(processed)
+}
@@ -0,0 +1,158 @@
+package hivdomainstatus
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Works off the check jobs in the queue. Any number of workers on any number
+// of machines can share one queue, a job is only claimed by one of them.
+type Worker struct {
+	config       *Config
+	jobRepo      CheckJobRepositoryInterface
+	manager      *Manager
+	scheduler    *Scheduler
+	Summary      *RunSummary
+	name         string
+	lease        time.Duration
+	heartbeat    time.Duration
+	maxAttempts  int
+	pollInterval time.Duration
+	checkDomain  func(config *Config, domain string) (*DomainCheckResult, error)
+}
+
+func NewWorker(c *Config, jobRepo CheckJobRepositoryInterface, manager *Manager) (w *Worker, err error) {
+	w = new(Worker)
+	w.config = c
+	w.jobRepo = jobRepo
+	w.manager = manager
+	w.checkDomain = CheckDomain
+	w.Summary = NewRunSummary()
+	hostname, _ := os.Hostname()
+	w.name = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	w.maxAttempts = c.Queue.MaxAttempts
+	if w.maxAttempts < 1 {
+		err = fmt.Errorf("Queue max attempts must be at least 1: %d", w.maxAttempts)
+		return
+	}
+	w.lease, err = time.ParseDuration(c.Queue.Lease)
+	if err != nil {
+		return
+	}
+	w.heartbeat, err = time.ParseDuration(c.Queue.Heartbeat)
+	if err != nil {
+		return
+	}
+	if w.heartbeat >= w.lease {
+		err = fmt.Errorf("Queue heartbeat %s must be shorter than the lease %s", w.heartbeat, w.lease)
+		return
+	}
+	w.pollInterval, err = time.ParseDuration(c.Scheduler.PollInterval)
+	return
+}
+
+// Processes jobs until stop is closed
+func (w *Worker) Run(stop <-chan bool) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		processed, err := w.RunOnce()
+		if err != nil {
+			log.Printf("ERROR: Worker %s: %s\n", w.name, err.Error())
+		}
+		if processed {
+			continue
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(w.pollInterval):
+		}
+	}
+}
+
+// Processes jobs until the queue is empty. Errors of a job are recorded in
+// the summary, only errors of the queue itself stop draining.
+func (w *Worker) Drain() (err error) {
+	for {
+		processed, runErr := w.RunOnce()
+		if runErr != nil && !processed {
+			return runErr
+		}
+		if !processed {
+			return
+		}
+	}
+}
+
+// Claims and processes the next job, processed is false if the queue is empty
+func (w *Worker) RunOnce() (processed bool, err error) {
+	requeued, err := w.jobRepo.RequeueExpired(w.maxAttempts)
+	if err != nil {
+		return
+	}
+	if requeued > 0 {
+		log.Printf("Requeued %d jobs with expired lease\n", requeued)
+	}
+	job, err := w.jobRepo.Claim(w.name, w.lease)
+	if err == sql.ErrNoRows {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	processed = true
+	err = w.Process(job)
+	return
+}
+
+// Checks the domain of a claimed job, the lease is renewed while the check is running
+func (w *Worker) Process(job *CheckJob) (err error) {
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(w.heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				heartbeatErr := w.jobRepo.Heartbeat(job, w.lease)
+				if heartbeatErr != nil {
+					log.Printf("[%s] ERROR: Heartbeat of job %d failed: %s\n", job.Domain, job.Id, heartbeatErr.Error())
+				}
+			}
+		}
+	}()
+
+	result, _ := w.checkDomain(w.config, job.Domain)
+	job.State = JOB_STATE_DONE
+	resultErr := w.manager.OnCheckDomainResult(result)
+	w.Summary.Add(job.Domain, result != nil && result.Valid, resultErr)
+	if resultErr != nil {
+		log.Printf("[%s] ERROR: %s\n", job.Domain, resultErr.Error())
+		job.State = JOB_STATE_FAILED
+		job.Error = resultErr.Error()
+	} else {
+		check, checkErr := w.manager.domainCheckRepo.FindLatestByDomain(job.Domain)
+		if checkErr == nil {
+			job.CheckId = check.Id
+		}
+	}
+	if w.scheduler != nil {
+		w.scheduler.Reschedule(job.Domain)
+	}
+	err = w.jobRepo.Complete(job)
+	if err != nil {
+		w.Summary.AddError(job.Domain, err)
+	}
+	return
+}